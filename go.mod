@@ -1,15 +1,16 @@
 module github.com/sylabs/oci-tools
 
-go 1.21.0
+go 1.22.0
 
 require (
+	github.com/containerd/stargz-snapshotter/estargz v0.14.3
 	github.com/google/go-containerregistry v0.19.1
+	github.com/opencontainers/go-digest v1.0.0
 	github.com/sebdah/goldie/v2 v2.5.3
 	github.com/sylabs/sif/v2 v2.16.0
 )
 
 require (
-	github.com/containerd/stargz-snapshotter/estargz v0.14.3 // indirect
 	github.com/docker/cli v24.0.0+incompatible // indirect
 	github.com/docker/distribution v2.8.2+incompatible // indirect
 	github.com/docker/docker v24.0.9+incompatible // indirect
@@ -18,7 +19,6 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/klauspost/compress v1.16.5 // indirect
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
-	github.com/opencontainers/go-digest v1.0.0 // indirect
 	github.com/opencontainers/image-spec v1.1.0-rc3 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
@@ -0,0 +1,147 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"maps"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// AnnotationTarget specifies where Annotate records a key/value pair.
+type AnnotationTarget int
+
+const (
+	// AnnotationTargetConfigLabel records the pair as a label in the image config.
+	AnnotationTargetConfigLabel AnnotationTarget = 1 << iota
+
+	// AnnotationTargetManifest records the pair as an annotation in the image manifest.
+	AnnotationTargetManifest
+
+	// AnnotationTargetBoth records the pair as both a config label and a manifest annotation.
+	AnnotationTargetBoth = AnnotationTargetConfigLabel | AnnotationTargetManifest
+)
+
+// Annotate sets the key/value pair as a label in the image config, an annotation in the image
+// manifest, or both, according to target. Existing values for key are replaced.
+func Annotate(key, value string, target AnnotationTarget) Mutation {
+	return func(img *image) error {
+		if target&AnnotationTargetConfigLabel != 0 {
+			img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+				if cf.Config.Labels == nil {
+					cf.Config.Labels = make(map[string]string)
+				}
+				cf.Config.Labels[key] = value
+			})
+		}
+
+		if target&AnnotationTargetManifest != 0 {
+			img.manifestMutations = append(img.manifestMutations, func(m *v1.Manifest) {
+				if m.Annotations == nil {
+					m.Annotations = make(map[string]string)
+				}
+				m.Annotations[key] = value
+			})
+		}
+
+		return nil
+	}
+}
+
+// Annotations merges ann into the annotations of the image manifest. Existing values are
+// replaced. Where the value in ann is empty, the corresponding annotation is removed instead.
+func Annotations(ann map[string]string) Mutation {
+	ann = maps.Clone(ann)
+
+	return func(img *image) error {
+		img.manifestMutations = append(img.manifestMutations, func(m *v1.Manifest) {
+			for k, v := range ann {
+				if v == "" {
+					delete(m.Annotations, k)
+					continue
+				}
+
+				if m.Annotations == nil {
+					m.Annotations = make(map[string]string, len(ann))
+				}
+				m.Annotations[k] = v
+			}
+
+			if len(m.Annotations) == 0 {
+				m.Annotations = nil
+			}
+		})
+		return nil
+	}
+}
+
+// copyAnnotationsOpts accumulates annotation copy options.
+type copyAnnotationsOpts struct {
+	overwrite bool
+}
+
+// CopyAnnotationsOpt are used to specify annotation copy options.
+type CopyAnnotationsOpt func(*copyAnnotationsOpts) error
+
+// OptCopyAnnotationsOverwrite specifies whether annotations copied from the source image replace
+// annotations with the same key in the destination image.
+func OptCopyAnnotationsOverwrite(b bool) CopyAnnotationsOpt {
+	return func(co *copyAnnotationsOpts) error {
+		co.overwrite = b
+		return nil
+	}
+}
+
+// CopyAnnotations applies the manifest annotations of src to the image manifest. By default, where
+// a key is annotated in both images, the existing value is retained. To prefer the value from src,
+// consider using OptCopyAnnotationsOverwrite.
+func CopyAnnotations(src v1.Image, opts ...CopyAnnotationsOpt) Mutation {
+	return func(img *image) error {
+		co := copyAnnotationsOpts{}
+		for _, opt := range opts {
+			if err := opt(&co); err != nil {
+				return err
+			}
+		}
+
+		m, err := src.Manifest()
+		if err != nil {
+			return err
+		}
+
+		if len(m.Annotations) == 0 {
+			return nil
+		}
+
+		annotations := maps.Clone(m.Annotations)
+
+		img.manifestMutations = append(img.manifestMutations, func(m *v1.Manifest) {
+			if m.Annotations == nil {
+				m.Annotations = make(map[string]string, len(annotations))
+			}
+
+			for k, v := range annotations {
+				if _, ok := m.Annotations[k]; ok && !co.overwrite {
+					continue
+				}
+				m.Annotations[k] = v
+			}
+		})
+		return nil
+	}
+}
+
+// StripLayerAnnotations removes the annotations from each layer descriptor in the image manifest.
+// Layer content is not modified, so layer digests are unchanged.
+func StripLayerAnnotations() Mutation {
+	return func(img *image) error {
+		img.manifestMutations = append(img.manifestMutations, func(m *v1.Manifest) {
+			for i := range m.Layers {
+				m.Layers[i].Annotations = nil
+			}
+		})
+		return nil
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"bytes"
+	"maps"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ggcrmutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestAnnotate(t *testing.T) {
+	const key, value = "org.example.key", "value"
+
+	tests := []struct {
+		name           string
+		target         AnnotationTarget
+		wantLabel      bool
+		wantAnnotation bool
+	}{
+		{
+			name:      "ConfigLabel",
+			target:    AnnotationTargetConfigLabel,
+			wantLabel: true,
+		},
+		{
+			name:           "Manifest",
+			target:         AnnotationTargetManifest,
+			wantAnnotation: true,
+		},
+		{
+			name:           "Both",
+			target:         AnnotationTargetBoth,
+			wantLabel:      true,
+			wantAnnotation: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"),
+				Annotate(key, value, tt.target),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Image(img, validate.Fast); err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if v, ok := cf.Config.Labels[key]; ok != tt.wantLabel || (ok && v != value) {
+				t.Errorf("got label %q (present %v), want present %v", v, ok, tt.wantLabel)
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if v, ok := m.Annotations[key]; ok != tt.wantAnnotation || (ok && v != value) {
+				t.Errorf("got annotation %q (present %v), want present %v", v, ok, tt.wantAnnotation)
+			}
+		})
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	base, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"),
+		Annotate("org.example.a", "a", AnnotationTargetManifest),
+		Annotate("org.example.b", "b", AnnotationTargetManifest),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		ann             map[string]string
+		wantAnnotations map[string]string
+	}{
+		{
+			name: "Merge",
+			ann: map[string]string{
+				"org.opencontainers.image.created": "2023-01-01T00:00:00Z",
+				"org.example.a":                    "new-a",
+			},
+			wantAnnotations: map[string]string{
+				"org.opencontainers.image.created": "2023-01-01T00:00:00Z",
+				"org.example.a":                    "new-a",
+				"org.example.b":                    "b",
+			},
+		},
+		{
+			name: "Delete",
+			ann: map[string]string{
+				"org.example.a": "",
+			},
+			wantAnnotations: map[string]string{
+				"org.example.b": "b",
+			},
+		},
+		{
+			name: "DeleteAll",
+			ann: map[string]string{
+				"org.example.a": "",
+				"org.example.b": "",
+			},
+			wantAnnotations: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(base, Annotations(tt.ann))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Image(img, validate.Fast); err != nil {
+				t.Fatal(err)
+			}
+
+			// Check the serialized manifest, to confirm the annotations survive serialization.
+			b, err := img.RawManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := v1.ParseManifest(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := m.Annotations, tt.wantAnnotations; !maps.Equal(got, want) {
+				t.Errorf("got annotations %v, want %v", got, want)
+			}
+
+			got, err := img.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := base.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got == want {
+				t.Errorf("got unchanged digest %v", got)
+			}
+		})
+	}
+}
+
+func TestCopyAnnotations(t *testing.T) {
+	src, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"),
+		Annotate("org.example.a", "src-a", AnnotationTargetManifest),
+		Annotate("org.example.b", "src-b", AnnotationTargetManifest),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := Apply(corpus.Image(t, "many-layers"),
+		Annotate("org.example.b", "dst-b", AnnotationTargetManifest),
+		Annotate("org.example.c", "dst-c", AnnotationTargetManifest),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		opts            []CopyAnnotationsOpt
+		wantAnnotations map[string]string
+	}{
+		{
+			name: "Default",
+			wantAnnotations: map[string]string{
+				"org.example.a": "src-a",
+				"org.example.b": "dst-b",
+				"org.example.c": "dst-c",
+			},
+		},
+		{
+			name: "Overwrite",
+			opts: []CopyAnnotationsOpt{OptCopyAnnotationsOverwrite(true)},
+			wantAnnotations: map[string]string{
+				"org.example.a": "src-a",
+				"org.example.b": "src-b",
+				"org.example.c": "dst-c",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(dst, CopyAnnotations(src, tt.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Image(img, validate.Fast); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := m.Annotations, tt.wantAnnotations; !maps.Equal(got, want) {
+				t.Errorf("got annotations %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStripLayerAnnotations(t *testing.T) {
+	base, err := ggcrmutate.Append(corpus.Image(t, "hello-world-docker-v2-manifest"), ggcrmutate.Addendum{
+		Layer:       static.NewLayer([]byte("layer"), types.DockerLayer),
+		Annotations: map[string]string{"org.example.key": "value"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, err = Apply(base, AppendLayerWithPlatformGuard(
+		static.NewLayer([]byte("guarded"), types.DockerLayer),
+		v1.Platform{OS: "linux", Architecture: "amd64"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Apply(base, StripLayerAnnotations())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img, validate.Fast); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(m.Layers), 3; got != want {
+		t.Fatalf("got %v layers, want %v", got, want)
+	}
+
+	for i, d := range m.Layers {
+		if got := d.Annotations; got != nil {
+			t.Errorf("layer %v: got annotations %v, want nil", i, got)
+		}
+	}
+}
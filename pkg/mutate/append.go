@@ -0,0 +1,161 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"maps"
+	"slices"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+)
+
+// LayerPlatformAnnotation is the layer descriptor annotation that records the platform a layer is
+// intended for, in the form "os/arch[/variant]".
+const LayerPlatformAnnotation = "io.sylabs.oci-tools.layer.platform"
+
+// annotatedLayer wraps a layer, adding annotations to its descriptor.
+type annotatedLayer struct {
+	v1.Layer
+	annotations map[string]string
+}
+
+// Descriptor returns the descriptor of the underlying layer, with annotations added.
+func (l *annotatedLayer) Descriptor() (*v1.Descriptor, error) {
+	d, err := partial.Descriptor(l.Layer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Take a copy, so the descriptor of the underlying layer is not modified.
+	desc := *d
+	desc.Annotations = maps.Clone(desc.Annotations)
+	if desc.Annotations == nil {
+		desc.Annotations = make(map[string]string, len(l.annotations))
+	}
+	maps.Copy(desc.Annotations, l.annotations)
+
+	return &desc, nil
+}
+
+// appendLayer appends l to the layers of img, along with a corresponding history entry if the image
+// has history.
+func appendLayer(img *image, l v1.Layer) {
+	img.overrides = append(img.overrides, l)
+
+	img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+		if len(cf.History) > 0 {
+			cf.History = append(cf.History, v1.History{})
+		}
+	})
+}
+
+// AppendLayer appends l to the image. The media type of the layer, as reported by l, is recorded in
+// the manifest unchanged, so a compressed layer is appended as-is (e.g. a zstd-compressed layer
+// pulled from a registry retains types.OCILayerZStd).
+func AppendLayer(l v1.Layer) Mutation {
+	return func(img *image) error {
+		appendLayer(img, l)
+		return nil
+	}
+}
+
+// padLayerHistory returns a copy of history, with empty entries appended such that it describes at
+// least n layers.
+func padLayerHistory(history []v1.History, n int) []v1.History {
+	var i int
+	for _, h := range history {
+		if !h.EmptyLayer {
+			i++
+		}
+	}
+
+	history = slices.Clone(history)
+
+	for ; i < n; i++ {
+		history = append(history, v1.History{})
+	}
+
+	return history
+}
+
+// appendLayerHistory returns history with h appended as the entry for the layer at index i. If
+// history describes fewer than i layers, empty entries are inserted so that each layer has a
+// corresponding entry.
+func appendLayerHistory(history []v1.History, i int, h v1.History) []v1.History {
+	return append(padLayerHistory(history, i), h)
+}
+
+// AppendLayerWithHistory appends l to the image, with h as the corresponding history entry. The
+// history of the base image is retained. If the base image does not have a history entry for each
+// existing layer, empty entries are added so that the history remains consistent with the layers.
+// The EmptyLayer field of h is ignored.
+func AppendLayerWithHistory(l v1.Layer, h v1.History) Mutation {
+	h.EmptyLayer = false
+
+	return func(img *image) error {
+		i := len(img.overrides)
+
+		img.overrides = append(img.overrides, l)
+
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.History = appendLayerHistory(cf.History, i, h)
+		})
+
+		return nil
+	}
+}
+
+// AppendLayerWithCreatedTime appends l to the image, with a corresponding history entry recording
+// t as the time the layer was created. Other history entries are not modified. History is
+// otherwise handled as described for AppendLayerWithHistory.
+func AppendLayerWithCreatedTime(l v1.Layer, t v1.Time) Mutation {
+	return AppendLayerWithHistory(l, v1.History{Created: t})
+}
+
+// AppendLayers returns an image consisting of the layers of base, followed by layers. Where base
+// has history, an empty history entry is appended for each appended layer. The media type of each
+// layer is recorded in the manifest unchanged, as described for AppendLayer.
+func AppendLayers(base v1.Image, layers ...v1.Layer) (v1.Image, error) {
+	ms := make([]Mutation, 0, len(layers))
+	for _, l := range layers {
+		ms = append(ms, AppendLayer(l))
+	}
+
+	return Apply(base, ms...)
+}
+
+// AppendLayersWithResult appends layers to base as described for AppendLayers, and returns the
+// resulting image along with the manifest descriptors of the appended layers, in order.
+func AppendLayersWithResult(base v1.Image, layers ...v1.Layer) (v1.Image, []v1.Descriptor, error) {
+	img, err := AppendLayers(base, layers...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	descs := slices.Clone(m.Layers[len(m.Layers)-len(layers):])
+
+	return img, descs, nil
+}
+
+// AppendLayerWithPlatformGuard appends l to the image. The descriptor of the layer is annotated
+// with LayerPlatformAnnotation, recording p as the platform the layer is intended for. This is
+// metadata only; the platform of the image is not modified, and no validation is performed.
+func AppendLayerWithPlatformGuard(l v1.Layer, p v1.Platform) Mutation {
+	return func(img *image) error {
+		appendLayer(img, &annotatedLayer{
+			Layer: l,
+			annotations: map[string]string{
+				LayerPlatformAnnotation: p.String(),
+			},
+		})
+		return nil
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/compression"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestAppendLayer(t *testing.T) {
+	b := tarBytes(t, tarEntry{typeflag: tar.TypeReg, name: "file", content: "content"})
+
+	tests := []struct {
+		name          string
+		base          v1.Image
+		opts          []tarball.LayerOption
+		wantMediaType types.MediaType
+	}{
+		{
+			name:          "Gzip",
+			base:          corpus.Image(t, "many-layers"),
+			wantMediaType: types.DockerLayer,
+		},
+		{
+			name: "ZStd",
+			base: corpus.Image(t, "many-layers"),
+			opts: []tarball.LayerOption{
+				tarball.WithCompression(compression.ZStd),
+				tarball.WithMediaType(types.OCILayerZStd),
+			},
+			wantMediaType: types.OCILayerZStd,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(b)), nil
+			}, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := Apply(tt.base, AppendLayer(l))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Layer content validation assumes gzip compression, so is skipped.
+			if err := validate.Image(img, validate.Fast); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := m.Layers[len(m.Layers)-1].MediaType, tt.wantMediaType; got != want {
+				t.Errorf("got media type %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestAppendLayers(t *testing.T) {
+	base := corpus.Image(t, "many-layers")
+
+	ls := []v1.Layer{
+		static.NewLayer([]byte("foo"), types.DockerLayer),
+		static.NewLayer([]byte("bar"), types.DockerLayer),
+	}
+
+	img, err := AppendLayers(base, ls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img, validate.Fast); err != nil {
+		t.Fatal(err)
+	}
+
+	want := layerDigests(t, base)
+	for _, l := range ls {
+		h, err := l.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, h)
+	}
+
+	if got := layerDigests(t, img); !slices.Equal(got, want) {
+		t.Errorf("got layers %v, want %v", got, want)
+	}
+
+	bcf, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(cf.RootFS.DiffIDs), len(bcf.RootFS.DiffIDs)+len(ls); got != want {
+		t.Errorf("got %v diffIDs, want %v", got, want)
+	}
+
+	if got, want := len(cf.History), len(bcf.History)+len(ls); got != want {
+		t.Errorf("got %v history entries, want %v", got, want)
+	}
+
+	// The digest of the resulting image is stable.
+	again, err := AppendLayers(base, ls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h1, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2, err := again.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h1 != h2 {
+		t.Errorf("got digests %v and %v, want equal", h1, h2)
+	}
+}
+
+func TestAppendLayersWithResult(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	ls := []v1.Layer{
+		static.NewLayer([]byte("foo"), types.DockerLayer),
+		static.NewLayer([]byte("bar"), types.DockerLayer),
+	}
+
+	img, descs, err := AppendLayersWithResult(base, ls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img, validate.Fast); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(descs), len(ls); got != want {
+		t.Fatalf("got %v descriptors, want %v", got, want)
+	}
+
+	for i, desc := range descs {
+		if got, want := desc, m.Layers[len(m.Layers)-len(ls)+i]; !reflect.DeepEqual(got, want) {
+			t.Errorf("got descriptor %+v, want %+v", got, want)
+		}
+
+		h, err := ls[i].Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := desc.Digest, h; got != want {
+			t.Errorf("got digest %v, want %v", got, want)
+		}
+	}
+}
+
+func TestAppendLayerWithHistory(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	noHistory, err := Apply(base, func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.History = nil
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		base          v1.Image
+		layers        int
+		wantCreatedBy []string
+	}{
+		{
+			name:          "Empty",
+			base:          empty.Image,
+			layers:        3,
+			wantCreatedBy: []string{"layer 0", "layer 1", "layer 2"},
+		},
+		{
+			name:   "BaseHistory",
+			base:   base,
+			layers: 2,
+			wantCreatedBy: []string{
+				"/bin/sh -c #(nop) COPY file:a79dd5bda1e77203401956a93401d3aef45221fc750295a4291896f3386f4f54 in / ",
+				"/bin/sh -c #(nop)  CMD [\"/hello\"]",
+				"layer 0",
+				"layer 1",
+			},
+		},
+		{
+			name:          "BaseNoHistory",
+			base:          noHistory,
+			layers:        2,
+			wantCreatedBy: []string{"", "layer 0", "layer 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := make([]Mutation, 0, tt.layers)
+			for i := 0; i < tt.layers; i++ {
+				l := static.NewLayer([]byte(fmt.Sprint(i)), types.DockerLayer)
+				ms = append(ms, AppendLayerWithHistory(l, v1.History{CreatedBy: fmt.Sprint("layer ", i)}))
+			}
+
+			img, err := Apply(tt.base, ms...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Image(img, validate.Fast); err != nil {
+				t.Fatal(err)
+			}
+
+			// Round-trip the config through its serialized form.
+			b, err := img.RawConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := v1.ParseConfigFile(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			createdBy := make([]string, 0, len(cf.History))
+			nonEmpty := 0
+
+			for _, h := range cf.History {
+				createdBy = append(createdBy, h.CreatedBy)
+				if !h.EmptyLayer {
+					nonEmpty++
+				}
+			}
+
+			if got, want := createdBy, tt.wantCreatedBy; !slices.Equal(got, want) {
+				t.Errorf("got created by %q, want %q", got, want)
+			}
+
+			if got, want := nonEmpty, len(cf.RootFS.DiffIDs); got != want {
+				t.Errorf("got %v non-empty history entries, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestAppendLayerWithCreatedTime(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	baseCF, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := v1.Time{Time: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)}
+
+	img, err := Apply(base,
+		AppendLayerWithCreatedTime(static.NewLayer([]byte("foobar"), types.DockerLayer), created),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img, validate.Fast); err != nil {
+		t.Fatal(err)
+	}
+
+	// Round-trip the config through its serialized form.
+	b, err := img.RawConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := v1.ParseConfigFile(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(cf.History), len(baseCF.History)+1; got != want {
+		t.Fatalf("got %v history entries, want %v", got, want)
+	}
+
+	// Existing history entries are untouched.
+	for i, want := range baseCF.History {
+		if got := cf.History[i]; !reflect.DeepEqual(got, want) {
+			t.Errorf("history %v: got %+v, want %+v", i, got, want)
+		}
+	}
+
+	if got, want := cf.History[len(cf.History)-1].Created, created; !got.Equal(want.Time) {
+		t.Errorf("got created %v, want %v", got, want)
+	}
+}
+
+func TestAppendLayerWithPlatformGuard(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+	l := static.NewLayer([]byte("foobar"), types.DockerLayer)
+
+	img, err := Apply(base,
+		AppendLayerWithPlatformGuard(l, v1.Platform{OS: "linux", Architecture: "riscv64"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img, validate.Fast); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(m.Layers), 2; got != want {
+		t.Fatalf("got %v layers, want %v", got, want)
+	}
+
+	if got, want := m.Layers[0].Annotations[LayerPlatformAnnotation], ""; got != want {
+		t.Errorf("got base layer platform %q, want %q", got, want)
+	}
+
+	if got, want := m.Layers[1].Annotations[LayerPlatformAnnotation], "linux/riscv64"; got != want {
+		t.Errorf("got appended layer platform %q, want %q", got, want)
+	}
+
+	baseCF, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(cf.History), len(baseCF.History)+1; got != want {
+		t.Errorf("got %v history entries, want %v", got, want)
+	}
+
+	// The image platform is not modified.
+	if got, want := cf.Platform(), baseCF.Platform(); !got.Equals(*want) {
+		t.Errorf("got image platform %v, want %v", got, want)
+	}
+}
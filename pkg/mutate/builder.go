@@ -0,0 +1,357 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// layerOpts accumulates layer options.
+type layerOpts struct {
+	mediaType    types.MediaType
+	progress     func(int64)
+	reproducible bool
+}
+
+// LayerOpt are used to specify layer options.
+type LayerOpt func(*layerOpts) error
+
+// OptLayerMediaType specifies the media type of the layer.
+func OptLayerMediaType(mt types.MediaType) LayerOpt {
+	return func(lo *layerOpts) error {
+		lo.mediaType = mt
+		return nil
+	}
+}
+
+// OptLayerProgress specifies a function to be called as file content is written to the TAR stream
+// of the layer. The function is called with the number of bytes written since it was last called.
+func OptLayerProgress(fn func(n int64)) LayerOpt {
+	return func(lo *layerOpts) error {
+		lo.progress = fn
+		return nil
+	}
+}
+
+// OptLayerReproducible specifies whether the TAR stream of the layer is normalized so that
+// identical inputs produce a byte-identical layer. When enabled, modification times are zeroed,
+// and ownership is set to root. Permissions are retained, as they affect the behaviour of the
+// content. Entries are always written in lexical order.
+func OptLayerReproducible(b bool) LayerOpt {
+	return func(lo *layerOpts) error {
+		lo.reproducible = b
+		return nil
+	}
+}
+
+// progressWriter is an io.Writer that reports the number of bytes written.
+type progressWriter struct {
+	w  io.Writer
+	fn func(int64)
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	if n > 0 {
+		pw.fn(int64(n))
+	}
+	return n, err
+}
+
+// tarBuilder writes entries to a TAR stream.
+type tarBuilder struct {
+	tw           *tar.Writer
+	progress     func(int64)
+	reproducible bool
+}
+
+// normalizeHeader normalizes the metadata in hdr that varies between otherwise identical inputs.
+// Permission bits, including the setuid, setgid and sticky bits, are retained.
+func normalizeHeader(hdr *tar.Header) {
+	hdr.ModTime = time.Unix(0, 0)
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	hdr.Uid = 0
+	hdr.Gid = 0
+	hdr.Uname = ""
+	hdr.Gname = ""
+}
+
+// writeFile writes the file at src to the TAR stream, with the specified name.
+func (tb *tarBuilder) writeFile(src, name string, fi fs.FileInfo) error {
+	var link string
+
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		l, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		link = l
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	if fi.IsDir() {
+		// The directory name in the name field should end with a slash.
+		hdr.Name += "/"
+	}
+
+	if tb.reproducible {
+		normalizeHeader(hdr)
+	}
+
+	if err := tb.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = tb.tw
+	if tb.progress != nil {
+		w = &progressWriter{w: w, fn: tb.progress}
+	}
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeDirectory writes the contents of dir to the TAR stream, in lexical order. The directory
+// itself is not written.
+func (tb *tarBuilder) writeDirectory(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		// Sockets cannot be represented in a TAR stream.
+		if d.Type()&fs.ModeSocket != 0 {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		return tb.writeFile(p, filepath.ToSlash(rel), fi)
+	})
+}
+
+// tempFileLayer is a layer backed by a temporary file, which is removed by Close.
+type tempFileLayer struct {
+	v1.Layer
+	path string
+}
+
+// Descriptor returns the descriptor of the underlying layer.
+func (l *tempFileLayer) Descriptor() (*v1.Descriptor, error) {
+	return partial.Descriptor(l.Layer)
+}
+
+// Close removes the temporary file backing the layer. Once closed, the content of the layer can
+// no longer be read.
+func (l *tempFileLayer) Close() error {
+	return os.Remove(l.path)
+}
+
+// buildLayer returns a layer containing the TAR stream produced by write. The stream is
+// gzip-compressed and written to a temporary file, so it is produced exactly once, and memory use
+// does not grow with the size of the layer.
+//
+// The temporary file is opened each time the layer content is read, so no file descriptor is held
+// between reads. The returned layer implements io.Closer; Close removes the temporary file.
+func buildLayer(write func(*tarBuilder) error, opts ...LayerOpt) (v1.Layer, error) {
+	lo := layerOpts{
+		mediaType:    types.OCILayer,
+		reproducible: true,
+	}
+
+	for _, opt := range opts {
+		if err := opt(&lo); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.CreateTemp("", "oci-tools-layer-")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeLayer(f, write, lo); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+
+	path := f.Name()
+
+	l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}, tarball.WithMediaType(lo.mediaType))
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	return &tempFileLayer{Layer: l, path: path}, nil
+}
+
+// writeLayer writes the gzip-compressed TAR stream produced by write to w.
+func writeLayer(w io.Writer, write func(*tarBuilder) error, lo layerOpts) error {
+	zw := gzip.NewWriter(w)
+
+	tb := tarBuilder{
+		tw:           tar.NewWriter(zw),
+		progress:     lo.progress,
+		reproducible: lo.reproducible,
+	}
+
+	if err := write(&tb); err != nil {
+		return err
+	}
+
+	if err := tb.tw.Close(); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// LayerFromDirectory returns a layer containing the contents of dir. Paths within the layer are
+// relative to dir.
+//
+// By default, the layer has media type types.OCILayer. To override this, consider using
+// OptLayerMediaType. To monitor progress as the layer is built, consider using OptLayerProgress.
+//
+// By default, the layer is reproducible, as described for OptLayerReproducible. To retain the
+// metadata of the source files, consider using OptLayerReproducible.
+//
+// The compressed layer is written to a temporary file, rather than buffered in memory. The
+// returned layer implements io.Closer; once the layer is no longer required, call Close to remove
+// the temporary file.
+func LayerFromDirectory(dir string, opts ...LayerOpt) (v1.Layer, error) {
+	return buildLayer(func(tb *tarBuilder) error {
+		return tb.writeDirectory(dir)
+	}, opts...)
+}
+
+// LayerFromFile returns a layer containing the file at src, with the specified name within the
+// layer.
+//
+// By default, the layer has media type types.OCILayer. To override this, consider using
+// OptLayerMediaType. To monitor progress as the layer is built, consider using OptLayerProgress.
+//
+// By default, the layer is reproducible, as described for OptLayerReproducible. To retain the
+// metadata of the source file, consider using OptLayerReproducible.
+//
+// The compressed layer is written to a temporary file, rather than buffered in memory. The
+// returned layer implements io.Closer; once the layer is no longer required, call Close to remove
+// the temporary file.
+func LayerFromFile(src, name string, opts ...LayerOpt) (v1.Layer, error) {
+	return buildLayer(func(tb *tarBuilder) error {
+		fi, err := os.Lstat(src)
+		if err != nil {
+			return err
+		}
+
+		return tb.writeFile(src, cleanName(name), fi)
+	}, opts...)
+}
+
+// cleanName returns name in the form of a relative path within a layer.
+func cleanName(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return name[1:]
+}
+
+// defaultLayerMediaType returns the media type for a layer appended to img, which is consistent
+// with the media type of the image manifest.
+func defaultLayerMediaType(img v1.Image) (types.MediaType, error) {
+	mt, err := img.MediaType()
+	if err != nil {
+		return "", err
+	}
+
+	if mt == types.DockerManifestSchema2 {
+		return types.DockerLayer, nil
+	}
+	return types.OCILayer, nil
+}
+
+// appendBuiltLayer returns a Mutation that appends the layer returned by build. Unless otherwise
+// specified in opts, the media type of the layer is consistent with the image manifest.
+func appendBuiltLayer(build func(...LayerOpt) (v1.Layer, error), opts ...LayerOpt) Mutation {
+	return func(img *image) error {
+		mt, err := defaultLayerMediaType(img.base)
+		if err != nil {
+			return err
+		}
+
+		l, err := build(append([]LayerOpt{OptLayerMediaType(mt)}, opts...)...)
+		if err != nil {
+			return err
+		}
+
+		appendLayer(img, l)
+
+		return nil
+	}
+}
+
+// AppendDirectory appends a layer containing the contents of dir to the image. Options are applied
+// as described for LayerFromDirectory, except that the default media type of the layer is
+// consistent with the image manifest.
+func AppendDirectory(dir string, opts ...LayerOpt) Mutation {
+	return appendBuiltLayer(func(opts ...LayerOpt) (v1.Layer, error) {
+		return LayerFromDirectory(dir, opts...)
+	}, opts...)
+}
+
+// AppendFile appends a layer containing the file at src, with the specified name within the
+// layer, to the image. Options are applied as described for LayerFromFile, except that the
+// default media type of the layer is consistent with the image manifest.
+func AppendFile(src, name string, opts ...LayerOpt) Mutation {
+	return appendBuiltLayer(func(opts ...LayerOpt) (v1.Layer, error) {
+		return LayerFromFile(src, name, opts...)
+	}, opts...)
+}
@@ -0,0 +1,307 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+// testDirectory returns a temporary directory populated with files, directories and symlinks. The
+// total size of regular file content is returned.
+func testDirectory(tb testing.TB) (string, int64) {
+	tb.Helper()
+
+	dir := tb.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(dir, "usr", "bin"), 0o755); err != nil {
+		tb.Fatal(err)
+	}
+
+	files := map[string]string{
+		"etc-hostname":   "localhost\n",
+		"usr/bin/foo":    strings.Repeat("foo", 100<<10),
+		"usr/bin/bar":    strings.Repeat("bar", 10),
+		"usr/empty-file": "",
+	}
+
+	var size int64
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		size += int64(len(content))
+	}
+
+	if err := os.Symlink("foo", filepath.Join(dir, "usr", "bin", "baz")); err != nil {
+		tb.Fatal(err)
+	}
+
+	return dir, size
+}
+
+func TestLayerFromDirectory(t *testing.T) {
+	dir, size := testDirectory(t)
+
+	var events int
+	var total int64
+
+	l, err := LayerFromDirectory(dir, OptLayerProgress(func(n int64) {
+		events++
+		total += n
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if events == 0 {
+		t.Error("got no progress events")
+	}
+
+	if got, want := total, size; got != want {
+		t.Errorf("got progress total %v, want %v", got, want)
+	}
+
+	tes, err := readTAR(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(tes))
+	for _, te := range tes {
+		names = append(names, te.name)
+	}
+
+	wantNames := []string{
+		"etc-hostname",
+		"usr/",
+		"usr/bin/",
+		"usr/bin/bar",
+		"usr/bin/baz",
+		"usr/bin/foo",
+		"usr/empty-file",
+	}
+
+	if got, want := names, wantNames; !slices.Equal(got, want) {
+		t.Errorf("got names %v, want %v", got, want)
+	}
+
+	if mt, err := l.MediaType(); err != nil {
+		t.Fatal(err)
+	} else if got, want := mt, types.OCILayer; got != want {
+		t.Errorf("got media type %v, want %v", got, want)
+	}
+}
+
+func TestLayerFromDirectoryClose(t *testing.T) {
+	dir, _ := testDirectory(t)
+
+	l, err := LayerFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readTAR(l); err != nil {
+		t.Fatal(err)
+	}
+
+	c, ok := l.(io.Closer)
+	if !ok {
+		t.Fatal("layer does not implement io.Closer")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Compressed(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestLayerFromDirectoryReproducible(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []LayerOpt
+		wantEqual bool
+	}{
+		{
+			name:      "Default",
+			wantEqual: true,
+		},
+		{
+			name: "NotReproducible",
+			opts: []LayerOpt{OptLayerReproducible(false)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, _ := testDirectory(t)
+
+			digest := func() v1.Hash {
+				l, err := LayerFromDirectory(dir, tt.opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				h, err := l.Digest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return h
+			}
+
+			first := digest()
+
+			// Modify metadata that does not affect the content of the directory.
+			mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+
+			if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+				if err != nil || d.Type()&fs.ModeSymlink != 0 {
+					return err
+				}
+				return os.Chtimes(p, mtime, mtime)
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := digest() == first, tt.wantEqual; got != want {
+				t.Errorf("got equal digests %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLayerFromDirectoryModes(t *testing.T) {
+	dir := t.TempDir()
+
+	wantModes := map[string]int64{
+		"private":    0o600,
+		"setuid":     0o4755,
+		"setgid":     0o2755,
+		"shared/":    0o1777,
+		"executable": 0o755,
+	}
+
+	for name, mode := range wantModes {
+		p := filepath.Join(dir, name)
+
+		if strings.HasSuffix(name, "/") {
+			if err := os.Mkdir(p, 0o700); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.WriteFile(p, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		// Set permissions explicitly, as they are subject to umask on creation.
+		if err := os.Chmod(p, fs.FileMode(mode&0o777)|unixModeBits(mode)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := LayerFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := l.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := hdr.Mode, wantModes[hdr.Name]; got != want {
+			t.Errorf("%v: got mode %o, want %o", hdr.Name, got, want)
+		}
+
+		if got, want := hdr.ModTime, time.Unix(0, 0); !got.Equal(want) {
+			t.Errorf("%v: got modification time %v, want %v", hdr.Name, got, want)
+		}
+	}
+}
+
+// unixModeBits returns the fs.FileMode bits corresponding to the setuid, setgid and sticky bits in
+// mode.
+func unixModeBits(mode int64) fs.FileMode {
+	var m fs.FileMode
+
+	if mode&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+
+	return m
+}
+
+func TestAppendFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(src, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	img, err := Apply(base, AppendFile(src, "/opt/file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img); err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := ls[len(ls)-1]
+
+	if mt, err := l.MediaType(); err != nil {
+		t.Fatal(err)
+	} else if got, want := mt, types.DockerLayer; got != want {
+		t.Errorf("got media type %v, want %v", got, want)
+	}
+
+	tes, err := readTAR(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []tarEntry{{typeflag: tar.TypeReg, name: "opt/file", content: "content"}}
+
+	if got := tes; !slices.Equal(got, want) {
+		t.Errorf("got entries %+v, want %+v", got, want)
+	}
+}
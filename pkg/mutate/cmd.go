@@ -0,0 +1,27 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"slices"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// SetCmdShell sets the command in the image config to the shell form of cmd. As with the shell
+// form of CMD in a Dockerfile, cmd is run via "/bin/sh -c".
+func SetCmdShell(cmd string) Mutation {
+	return SetCmdExec([]string{"/bin/sh", "-c", cmd})
+}
+
+// SetCmdExec sets the command in the image config to args. As with the exec form of CMD in a
+// Dockerfile, args are used verbatim, so no shell processing occurs.
+func SetCmdExec(args []string) Mutation {
+	args = slices.Clone(args)
+
+	return Config(func(c *v1.Config) {
+		c.Cmd = slices.Clone(args)
+	})
+}
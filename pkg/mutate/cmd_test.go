@@ -0,0 +1,58 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSetCmd(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	tests := []struct {
+		name    string
+		m       Mutation
+		wantCmd []string
+	}{
+		{
+			name:    "Shell",
+			m:       SetCmdShell("echo $HOME && ls"),
+			wantCmd: []string{"/bin/sh", "-c", "echo $HOME && ls"},
+		},
+		{
+			name:    "Exec",
+			m:       SetCmdExec([]string{"/bin/echo", "$HOME"}),
+			wantCmd: []string{"/bin/echo", "$HOME"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(base, tt.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Config.Cmd, tt.wantCmd; !slices.Equal(got, want) {
+				t.Errorf("got cmd %q, want %q", got, want)
+			}
+
+			// Other runtime configuration is retained.
+			bcf, err := base.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Config.Env, bcf.Config.Env; !slices.Equal(got, want) {
+				t.Errorf("got env %v, want %v", got, want)
+			}
+		})
+	}
+}
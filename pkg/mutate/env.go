@@ -0,0 +1,200 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// splitEnv splits an environment variable of the form "KEY=VALUE" into its key and value. Values
+// may themselves contain '=', so the split occurs on the first '=' only.
+func splitEnv(s string) (string, string) {
+	k, v, _ := strings.Cut(s, "=")
+	return k, v
+}
+
+// GetEnv returns the environment variables set in the config of img, keyed by name.
+func GetEnv(img v1.Image) (map[string]string, error) {
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]string, len(cf.Config.Env))
+	for _, e := range cf.Config.Env {
+		k, v := splitEnv(e)
+		env[k] = v
+	}
+
+	return env, nil
+}
+
+// setEnv sets the environment variables in env. Variables that are already present are updated in
+// place. New variables are appended in lexical order of name.
+func setEnv(env []string, vars map[string]string) []string {
+	env = slices.Clone(env)
+
+	seen := make(map[string]bool, len(vars))
+
+	for i, e := range env {
+		k, _ := splitEnv(e)
+		if v, ok := vars[k]; ok {
+			env[i] = k + "=" + v
+			seen[k] = true
+		}
+	}
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		if !seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		env = append(env, k+"="+vars[k])
+	}
+
+	return env
+}
+
+// SetEnv sets the environment variables in env in the image config. Variables that are already
+// present in the image config are updated in place. New variables are appended in lexical order of
+// name.
+func SetEnv(env map[string]string) Mutation {
+	return func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.Config.Env = setEnv(cf.Config.Env, env)
+		})
+		return nil
+	}
+}
+
+// EnvVar is an environment variable.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// setEnvOrdered sets the environment variables in vars, in order. Variables that are already
+// present are updated in place. New variables are appended in the order they appear in vars.
+func setEnvOrdered(env []string, vars []EnvVar) []string {
+	env = slices.Clone(env)
+
+	index := make(map[string]int, len(env))
+	for i, e := range env {
+		k, _ := splitEnv(e)
+		index[k] = i
+	}
+
+	for _, v := range vars {
+		e := v.Name + "=" + v.Value
+
+		if i, ok := index[v.Name]; ok {
+			env[i] = e
+		} else {
+			index[v.Name] = len(env)
+			env = append(env, e)
+		}
+	}
+
+	return env
+}
+
+// SetEnvOrdered sets the environment variables in vars in the image config. Variables that are
+// already present in the image config are updated in place. New variables are appended in the order
+// they appear in vars. Where a variable appears more than once in vars, the last value is used.
+func SetEnvOrdered(vars []EnvVar) Mutation {
+	vars = slices.Clone(vars)
+
+	return func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.Config.Env = setEnvOrdered(cf.Config.Env, vars)
+		})
+		return nil
+	}
+}
+
+// expandEnvOpts accumulates ExpandEnv options.
+type expandEnvOpts struct {
+	unresolved func(name string)
+	extra      map[string]string
+}
+
+// ExpandEnvOpt are used to specify ExpandEnv options.
+type ExpandEnvOpt func(*expandEnvOpts) error
+
+// OptExpandEnvUnresolved specifies a function to call with the name of each variable that is
+// referenced, but cannot be resolved. The reference expands to an empty string in any case, so fn
+// may be used to warn of a likely mistake.
+func OptExpandEnvUnresolved(fn func(name string)) ExpandEnvOpt {
+	return func(eo *expandEnvOpts) error {
+		eo.unresolved = fn
+		return nil
+	}
+}
+
+// OptExpandEnvExtra specifies additional variables against which references are resolved, where
+// they are not present in the environment of the base image. The variables in extra are not
+// themselves set in the image config.
+func OptExpandEnvExtra(extra map[string]string) ExpandEnvOpt {
+	return func(eo *expandEnvOpts) error {
+		eo.extra = maps.Clone(extra)
+		return nil
+	}
+}
+
+// ExpandEnv sets the environment variables in vars in the image config, as described for SetEnv.
+// References of the form $VAR or ${VAR} within the values in vars are expanded, so that literal
+// values are stored.
+//
+// References are resolved against the environment in the config of the base image when the
+// mutation is applied, so that "$PATH:/opt/bin" extends the existing PATH. References are not
+// resolved against other values in vars, so the result does not depend on the order in which they
+// are expanded. To supply additional values, consider using OptExpandEnvExtra. Variables that
+// cannot be resolved, including a variable that references itself but is not present in the base
+// image, expand to an empty string.
+func ExpandEnv(vars map[string]string, opts ...ExpandEnvOpt) Mutation {
+	return func(img *image) error {
+		eo := expandEnvOpts{}
+
+		for _, opt := range opts {
+			if err := opt(&eo); err != nil {
+				return err
+			}
+		}
+
+		env, err := GetEnv(img.base)
+		if err != nil {
+			return err
+		}
+
+		mapping := func(name string) string {
+			if v, ok := env[name]; ok {
+				return v
+			}
+			if v, ok := eo.extra[name]; ok {
+				return v
+			}
+			if eo.unresolved != nil {
+				eo.unresolved(name)
+			}
+			return ""
+		}
+
+		expanded := make(map[string]string, len(vars))
+		for k, v := range vars {
+			expanded[k] = os.Expand(v, mapping)
+		}
+
+		return SetEnv(expanded)(img)
+	}
+}
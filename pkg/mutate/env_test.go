@@ -0,0 +1,282 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestGetEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    v1.Image
+		ms      []Mutation
+		wantEnv map[string]string
+	}{
+		{
+			name: "DockerManifest",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			wantEnv: map[string]string{
+				"PATH": "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+			},
+		},
+		{
+			name: "ValueWithEquals",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			ms: []Mutation{
+				SetConfig(&v1.ConfigFile{
+					Config: v1.Config{
+						Env: []string{"FOO=bar=baz", "EMPTY=", "NOVALUE"},
+					},
+				}, types.DockerConfigJSON),
+			},
+			wantEnv: map[string]string{
+				"FOO":     "bar=baz",
+				"EMPTY":   "",
+				"NOVALUE": "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(tt.base, tt.ms...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env, err := GetEnv(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := env, tt.wantEnv; !maps.Equal(got, want) {
+				t.Errorf("got env %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSetEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    v1.Image
+		env     map[string]string
+		wantEnv []string
+	}{
+		{
+			name: "Update",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			env: map[string]string{
+				"PATH": "/opt/bin",
+			},
+			wantEnv: []string{
+				"PATH=/opt/bin",
+			},
+		},
+		{
+			name: "Append",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			env: map[string]string{
+				"FOO": "bar=baz",
+				"BAR": "",
+			},
+			wantEnv: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"BAR=",
+				"FOO=bar=baz",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(tt.base, SetEnv(tt.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Config.Env, tt.wantEnv; !slices.Equal(got, want) {
+				t.Errorf("got env %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSetEnvOrdered(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    v1.Image
+		vars    []EnvVar
+		wantEnv []string
+	}{
+		{
+			name: "Update",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			vars: []EnvVar{
+				{Name: "PATH", Value: "/opt/bin"},
+			},
+			wantEnv: []string{
+				"PATH=/opt/bin",
+			},
+		},
+		{
+			name: "Append",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			vars: []EnvVar{
+				{Name: "ZZZ", Value: "1"},
+				{Name: "AAA", Value: "2"},
+				{Name: "PATH", Value: "/opt/bin"},
+				{Name: "MMM", Value: "bar=baz"},
+			},
+			wantEnv: []string{
+				"PATH=/opt/bin",
+				"ZZZ=1",
+				"AAA=2",
+				"MMM=bar=baz",
+			},
+		},
+		{
+			name: "Repeated",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			vars: []EnvVar{
+				{Name: "FOO", Value: "1"},
+				{Name: "BAR", Value: "2"},
+				{Name: "FOO", Value: "3"},
+			},
+			wantEnv: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"FOO=3",
+				"BAR=2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(tt.base, SetEnvOrdered(tt.vars))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Config.Env, tt.wantEnv; !slices.Equal(got, want) {
+				t.Errorf("got env %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	tests := []struct {
+		name           string
+		base           v1.Image
+		vars           map[string]string
+		opts           []ExpandEnvOpt
+		wantEnv        []string
+		wantUnresolved []string
+	}{
+		{
+			name: "ExtendPath",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			vars: map[string]string{
+				"PATH": "$PATH:/opt/bin",
+			},
+			wantEnv: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/opt/bin",
+			},
+		},
+		{
+			name: "ExtendUnsetPath",
+			base: empty.Image,
+			vars: map[string]string{
+				"PATH": "$PATH:/opt/bin",
+			},
+			wantEnv: []string{
+				"PATH=:/opt/bin",
+			},
+			wantUnresolved: []string{"PATH"},
+		},
+		{
+			name: "Braces",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			vars: map[string]string{
+				"APP_BIN": "${APP_HOME}/bin",
+			},
+			opts: []ExpandEnvOpt{
+				OptExpandEnvExtra(map[string]string{"APP_HOME": "/opt/app"}),
+			},
+			wantEnv: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"APP_BIN=/opt/app/bin",
+			},
+		},
+		{
+			name: "OtherVar",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			vars: map[string]string{
+				"APP_HOME": "/opt/app",
+				"APP_BIN":  "${APP_HOME}/bin",
+			},
+			wantEnv: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"APP_BIN=/bin",
+				"APP_HOME=/opt/app",
+			},
+			wantUnresolved: []string{"APP_HOME"},
+		},
+		{
+			name: "Unresolved",
+			base: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			vars: map[string]string{
+				"FOO": "a${MISSING}b",
+			},
+			wantEnv: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"FOO=ab",
+			},
+			wantUnresolved: []string{"MISSING"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var unresolved []string
+
+			opts := append(slices.Clone(tt.opts), OptExpandEnvUnresolved(func(name string) {
+				unresolved = append(unresolved, name)
+			}))
+
+			img, err := Apply(tt.base, ExpandEnv(tt.vars, opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Config.Env, tt.wantEnv; !slices.Equal(got, want) {
+				t.Errorf("got env %v, want %v", got, want)
+			}
+
+			if got, want := unresolved, tt.wantUnresolved; !slices.Equal(got, want) {
+				t.Errorf("got unresolved %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// estargzCompression is an eStargz gzip compression that constructs the footer explicitly, rather
+// than relying on the output of compress/gzip being a particular size.
+type estargzCompression struct {
+	*estargz.GzipCompressor
+	*estargz.GzipDecompressor
+}
+
+// estargzFooter returns the eStargz footer, which is an empty gzip stream with an extra field
+// recording the offset of the TOC. See https://tools.ietf.org/html/rfc1952.
+func estargzFooter(tocOff int64) []byte {
+	subfield := fmt.Sprintf("%016xSTARGZ", tocOff)
+
+	b := make([]byte, 0, estargz.FooterSize)
+	b = append(b, 0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff) // Header: deflate, FEXTRA, OS unknown.
+	b = binary.LittleEndian.AppendUint16(b, uint16(4+len(subfield)))
+	b = append(b, 'S', 'G')
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(subfield)))
+	b = append(b, subfield...)
+	b = append(b, 1, 0, 0, 0xff, 0xff)    // Final, empty, stored block.
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0) // CRC-32 and size of empty data.
+	return b
+}
+
+// WriteTOCAndFooter writes the TOC and footer to w.
+func (c *estargzCompression) WriteTOCAndFooter(
+	w io.Writer, off int64, toc *estargz.JTOC, diffHash hash.Hash,
+) (digest.Digest, error) {
+	tocJSON, err := json.MarshalIndent(toc, "", "\t")
+	if err != nil {
+		return "", err
+	}
+
+	gz, err := c.Writer(w)
+	if err != nil {
+		return "", err
+	}
+
+	gw := io.Writer(gz)
+	if diffHash != nil {
+		gw = io.MultiWriter(gz, diffHash)
+	}
+
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     estargz.TOCTarName,
+		Size:     int64(len(tocJSON)),
+	}); err != nil {
+		return "", err
+	}
+
+	if _, err := tw.Write(tocJSON); err != nil {
+		return "", err
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+
+	if _, err := w.Write(estargzFooter(off)); err != nil {
+		return "", err
+	}
+
+	return digest.FromBytes(tocJSON), nil
+}
+
+// estargzLayer converts the base layer into a layer using the eStargz format. The layer descriptor
+// is annotated with the digest of the eStargz TOC.
+//
+// The content of the base layer is buffered in memory during conversion.
+func estargzLayer(base v1.Layer) (v1.Layer, error) {
+	mt, err := tarLayerMediaType(base)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := base.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := estargz.Build(io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))),
+		estargz.WithCompression(&estargzCompression{
+			estargz.NewGzipCompressorWithLevel(gzip.BestCompression),
+			&estargz.GzipDecompressor{},
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	eb, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(eb)), nil
+	}, tarball.WithMediaType(mt))
+	if err != nil {
+		return nil, err
+	}
+
+	return &annotatedLayer{
+		Layer: l,
+		annotations: map[string]string{
+			estargz.TOCJSONDigestAnnotation: blob.TOCDigest().String(),
+		},
+	}, nil
+}
+
+// ToEstargz converts each layer in the base image to the eStargz format, to support lazy pulling.
+// The descriptor of each layer is annotated with the digest of its eStargz TOC.
+//
+// Note that conversion reorders the entries in each layer TAR stream, so the DiffIDs of the
+// resulting image differ from those of the base image. The content of each layer is buffered in
+// memory during conversion.
+func ToEstargz(base v1.Image) (v1.Image, error) {
+	ls, err := base.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	ms := make([]Mutation, 0, len(ls))
+
+	for i, l := range ls {
+		l, err := estargzLayer(l)
+		if err != nil {
+			return nil, err
+		}
+
+		ms = append(ms, SetLayer(i, l))
+	}
+
+	return Apply(base, ms...)
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestToEstargz(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "DockerManifest", path: "hello-world-docker-v2-manifest"},
+		{name: "ManyLayers", path: "many-layers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := corpus.Image(t, tt.path)
+
+			img, err := ToEstargz(base)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Image(img); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bm, err := base.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := len(m.Layers), len(bm.Layers); got != want {
+				t.Fatalf("got %v layers, want %v", got, want)
+			}
+
+			for i, desc := range m.Layers {
+				if desc.Annotations[estargz.TOCJSONDigestAnnotation] == "" {
+					t.Errorf("layer %v missing TOC digest annotation", i)
+				}
+
+				if got, want := desc.MediaType, bm.Layers[i].MediaType; got != want {
+					t.Errorf("layer %v: got media type %v, want %v", i, got, want)
+				}
+			}
+
+			// Confirm the TOC of each layer can be located via the footer.
+			ls, err := img.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, l := range ls {
+				rc, err := l.Compressed()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				b, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				r, err := estargz.Open(io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))))
+				if err != nil {
+					t.Fatalf("layer %v: %v", i, err)
+				}
+
+				want := m.Layers[i].Annotations[estargz.TOCJSONDigestAnnotation]
+				if got := r.TOCDigest().String(); got != want {
+					t.Errorf("layer %v: got TOC digest %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
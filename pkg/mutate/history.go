@@ -0,0 +1,129 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// History returns the history entries in the config of img. For images returned by this package,
+// the history reflects any mutations applied, including entries added for appended layers.
+func History(img v1.Image) ([]v1.History, error) {
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Clone(cf.History), nil
+}
+
+var (
+	errInvalidHistoryIndex  = errors.New("invalid history index")
+	errHistoryEntryHasLayer = errors.New("history entry corresponds to a layer")
+	errHistoryLayerMismatch = errors.New("history does not correspond to layers")
+)
+
+// RemoveHistoryEntry returns an image based on base, with the history entry at index removed.
+//
+// Entries with EmptyLayer set are removed without further changes. Otherwise, the entry corresponds
+// to a layer, and removing it alone would break the correspondence between history and layers. In
+// this case, if removeLayer is set, the corresponding layer is also removed. If removeLayer is not
+// set, an error is returned.
+func RemoveHistoryEntry(base v1.Image, index int, removeLayer bool) (v1.Image, error) {
+	cf, err := base.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if index < 0 || index >= len(cf.History) {
+		return nil, fmt.Errorf("%w: %v", errInvalidHistoryIndex, index)
+	}
+
+	removeEntry := func(cf *v1.ConfigFile) {
+		cf.History = slices.Delete(slices.Clone(cf.History), index, index+1)
+	}
+
+	if cf.History[index].EmptyLayer {
+		return Apply(base, func(img *image) error {
+			img.configFileMutations = append(img.configFileMutations, removeEntry)
+			return nil
+		})
+	}
+
+	if !removeLayer {
+		return nil, fmt.Errorf("%w: %v", errHistoryEntryHasLayer, index)
+	}
+
+	ls, err := base.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Locate the layer corresponding to the entry.
+	i, n := 0, 0
+	for j, h := range cf.History {
+		if h.EmptyLayer {
+			continue
+		}
+		if j < index {
+			i++
+		}
+		n++
+	}
+
+	if n != len(ls) {
+		return nil, fmt.Errorf("%w: %v non-empty entries, %v layers", errHistoryLayerMismatch, n, len(ls))
+	}
+
+	layers := slices.Delete(slices.Clone(ls), i, i+1)
+
+	return Apply(base, func(img *image) error {
+		img.overrides = layers
+		img.configFileMutations = append(img.configFileMutations, removeEntry)
+		return nil
+	})
+}
+
+var errLayerCountMismatch = errors.New("layer count mismatch")
+
+// AssertLayerHistoryAligned verifies that the number of layers in the manifest of img matches the
+// number of diff IDs in its config and, where the config records history, the number of history
+// entries that do not have EmptyLayer set. Only the manifest and config are read; layers are not.
+func AssertLayerHistoryAligned(img v1.Image) error {
+	m, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return err
+	}
+
+	if got, want := len(cf.RootFS.DiffIDs), len(m.Layers); got != want {
+		return fmt.Errorf("%w: %v diff IDs, %v layers", errLayerCountMismatch, got, want)
+	}
+
+	if len(cf.History) == 0 {
+		return nil
+	}
+
+	var n int
+	for _, h := range cf.History {
+		if !h.EmptyLayer {
+			n++
+		}
+	}
+
+	if n != len(m.Layers) {
+		return fmt.Errorf("%w: %v non-empty entries, %v layers", errHistoryLayerMismatch, n, len(m.Layers))
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestHistory(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	img, err := Apply(base, AppendLayer(static.NewLayer([]byte("foobar"), types.DockerLayer)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		img            v1.Image
+		wantEmptyLayer []bool
+	}{
+		{
+			name:           "Base",
+			img:            base,
+			wantEmptyLayer: []bool{false, true},
+		},
+		{
+			name:           "AppendLayer",
+			img:            img,
+			wantEmptyLayer: []bool{false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := History(tt.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			emptyLayer := make([]bool, 0, len(history))
+			for _, h := range history {
+				emptyLayer = append(emptyLayer, h.EmptyLayer)
+			}
+
+			if got, want := emptyLayer, tt.wantEmptyLayer; !slices.Equal(got, want) {
+				t.Errorf("got empty layer flags %v, want %v", got, want)
+			}
+
+			// Each non-empty entry corresponds to a layer.
+			ls, err := tt.img.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var n int
+			for _, empty := range emptyLayer {
+				if !empty {
+					n++
+				}
+			}
+
+			if got, want := n, len(ls); got != want {
+				t.Errorf("got %v non-empty history entries, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRemoveHistoryEntry(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+	l := static.NewLayer([]byte("foobar"), types.DockerLayer)
+
+	// History entries are [layer, empty, layer].
+	img, err := Apply(base, AppendLayer(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		index          int
+		removeLayer    bool
+		wantErr        error
+		wantEmptyLayer []bool
+		wantLayers     []v1.Hash
+	}{
+		{
+			name:           "EmptyLayerEntry",
+			index:          1,
+			wantEmptyLayer: []bool{false, false},
+			wantLayers:     layerDigests(t, img),
+		},
+		{
+			name:    "LayerEntryRejected",
+			index:   2,
+			wantErr: errHistoryEntryHasLayer,
+		},
+		{
+			name:           "LayerEntryRemoved",
+			index:          2,
+			removeLayer:    true,
+			wantEmptyLayer: []bool{false, true},
+			wantLayers:     layerDigests(t, base),
+		},
+		{
+			name:    "InvalidIndex",
+			index:   3,
+			wantErr: errInvalidHistoryIndex,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := RemoveHistoryEntry(img, tt.index, tt.removeLayer)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			history, err := History(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			emptyLayer := make([]bool, 0, len(history))
+			for _, h := range history {
+				emptyLayer = append(emptyLayer, h.EmptyLayer)
+			}
+
+			if got, want := emptyLayer, tt.wantEmptyLayer; !slices.Equal(got, want) {
+				t.Errorf("got empty layer flags %v, want %v", got, want)
+			}
+
+			if got, want := layerDigests(t, out), tt.wantLayers; !slices.Equal(got, want) {
+				t.Errorf("got layers %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestAssertLayerHistoryAligned(t *testing.T) {
+	base := corpus.Image(t, "many-layers")
+
+	// Replacing the layers without updating history leaves an entry for each of the original layers.
+	collapsed, err := Apply(base, ReplaceLayers(static.NewLayer([]byte("foobar"), types.DockerLayer)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	squashed, err := Squash(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appended, err := Apply(base, AppendLayer(static.NewLayer([]byte("foobar"), types.DockerLayer)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noHistory, err := Apply(base, func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.History = nil
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extraDiffID, err := Apply(noHistory, func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.RootFS.DiffIDs = append(cf.RootFS.DiffIDs, cf.RootFS.DiffIDs[0])
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		img     v1.Image
+		wantErr error
+	}{
+		{
+			name: "Base",
+			img:  base,
+		},
+		{
+			name: "AppendLayer",
+			img:  appended,
+		},
+		{
+			name: "Squash",
+			img:  squashed,
+		},
+		{
+			name: "NoHistory",
+			img:  noHistory,
+		},
+		{
+			name:    "ReplaceLayers",
+			img:     collapsed,
+			wantErr: errHistoryLayerMismatch,
+		},
+		{
+			name:    "ExtraDiffID",
+			img:     extraDiffID,
+			wantErr: errLayerCountMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := AssertLayerHistoryAligned(tt.img), tt.wantErr; !errors.Is(got, want) {
+				t.Errorf("got error %v, want %v", got, want)
+			}
+		})
+	}
+}
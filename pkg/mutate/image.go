@@ -17,11 +17,13 @@ import (
 )
 
 type image struct {
-	base               v1.Image
-	overrides          []v1.Layer
-	history            *v1.History
-	configFileOverride any
-	configTypeOverride types.MediaType
+	base                v1.Image
+	overrides           []v1.Layer
+	createdTime         *v1.Time
+	configFileOverride  any
+	configTypeOverride  types.MediaType
+	configFileMutations []func(*v1.ConfigFile)
+	manifestMutations   []func(*v1.Manifest)
 
 	computed      bool
 	diffIDs       []v1.Hash
@@ -36,7 +38,8 @@ type image struct {
 
 var errUnexpectedConfigFileType = errors.New("unexpected config file type")
 
-// populate populates various fields in img.
+// populate populates various fields in img. The fields are computed once, and are not recomputed
+// on subsequent calls, so img is immutable once populated.
 func (img *image) populate() error {
 	img.Lock()
 	defer img.Unlock()
@@ -71,6 +74,12 @@ func (img *image) populate() error {
 			return err
 		}
 
+		// Some layer implementations return empty annotations rather than nil. Normalize, so that
+		// the manifest is consistent with its serialized form.
+		if len(d.Annotations) == 0 {
+			d.Annotations = nil
+		}
+
 		diffID, err := l.DiffID()
 		if err != nil {
 			return err
@@ -112,9 +121,18 @@ func (img *image) populate() error {
 
 		cf.RootFS.DiffIDs = diffIDs
 
-		// Replace history, if applicable.
-		if img.history != nil {
-			cf.History = []v1.History{*img.history}
+		// Apply config file mutations, in the order they were specified.
+		for _, m := range img.configFileMutations {
+			m(cf)
+		}
+
+		// Set created time last, so that it applies to history entries added by any mutation.
+		if t := img.createdTime; t != nil {
+			cf.Created = *t
+
+			for i := range cf.History {
+				cf.History[i].Created = *t
+			}
 		}
 
 		configFile = cf
@@ -151,6 +169,11 @@ func (img *image) populate() error {
 		manifest.Config.Data = config
 	}
 
+	// Apply manifest mutations, in the order they were specified.
+	for _, m := range img.manifestMutations {
+		m(manifest)
+	}
+
 	img.computed = true
 	img.diffIDs = diffIDs
 	img.byDiffID = byDiffID
@@ -7,10 +7,13 @@ package mutate
 import (
 	"encoding/json"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/static"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
@@ -75,11 +78,15 @@ func Test_image_populate(t *testing.T) { //nolint:gocognit
 			img: &image{
 				base:      img,
 				overrides: make([]v1.Layer, 1),
-				history: &v1.History{
-					Author:    "Author",
-					Created:   v1.Time{Time: time.Date(2023, 5, 2, 2, 25, 50, 0, time.UTC)},
-					CreatedBy: "CreatedBy",
-					Comment:   "Comment",
+				configFileMutations: []func(*v1.ConfigFile){
+					func(cf *v1.ConfigFile) {
+						cf.History = []v1.History{{
+							Author:    "Author",
+							Created:   v1.Time{Time: time.Date(2023, 5, 2, 2, 25, 50, 0, time.UTC)},
+							CreatedBy: "CreatedBy",
+							Comment:   "Comment",
+						}}
+					},
 				},
 			},
 			wantMediaType:   types.DockerManifestSchema2,
@@ -220,3 +227,140 @@ func Test_image_populate(t *testing.T) { //nolint:gocognit
 		})
 	}
 }
+
+// countingImage wraps an image, counting calls to Manifest.
+type countingImage struct {
+	v1.Image
+	manifestCalls atomic.Int32
+}
+
+// Manifest returns the manifest of the underlying image.
+func (img *countingImage) Manifest() (*v1.Manifest, error) {
+	img.manifestCalls.Add(1)
+	return img.Image.Manifest()
+}
+
+func Test_image_populateOnce(t *testing.T) {
+	base := &countingImage{Image: corpus.Image(t, "hello-world-docker-v2-manifest")}
+
+	img, err := Apply(base, SetEnv(map[string]string{"FOO": "bar"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Access the image concurrently, via various methods.
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := img.ConfigFile(); err != nil {
+				t.Error(err)
+			}
+
+			if _, err := img.Layers(); err != nil {
+				t.Error(err)
+			}
+
+			if got, err := img.Digest(); err != nil {
+				t.Error(err)
+			} else if got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if got, want := base.manifestCalls.Load(), int32(1); got != want {
+		t.Errorf("got %v populations, want %v", got, want)
+	}
+}
+
+// descriptorLayer wraps a layer, adding a Descriptor method that returns the specified
+// annotations.
+type descriptorLayer struct {
+	v1.Layer
+	annotations map[string]string
+}
+
+// Descriptor returns a descriptor for the layer.
+func (l *descriptorLayer) Descriptor() (*v1.Descriptor, error) {
+	d, err := partial.Descriptor(l.Layer)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Annotations = l.annotations
+
+	return d, nil
+}
+
+func Test_image_populateLayerAnnotations(t *testing.T) {
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		wantAnnotations map[string]string
+	}{
+		{
+			name:            "Nil",
+			annotations:     nil,
+			wantAnnotations: nil,
+		},
+		{
+			name:            "Empty",
+			annotations:     map[string]string{},
+			wantAnnotations: nil,
+		},
+		{
+			name:            "NonEmpty",
+			annotations:     map[string]string{"key": "value"},
+			wantAnnotations: map[string]string{"key": "value"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &descriptorLayer{
+				Layer:       static.NewLayer([]byte("content"), types.OCILayer),
+				annotations: tt.annotations,
+			}
+
+			img, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"), AppendLayer(l))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := m.Layers[len(m.Layers)-1].Annotations, tt.wantAnnotations; !reflect.DeepEqual(got, want) {
+				t.Errorf("got annotations %v, want %v", got, want)
+			}
+
+			// The manifest must be consistent with its serialized form.
+			b, err := img.RawManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var parsed v1.Manifest
+			if err := json.Unmarshal(b, &parsed); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := &parsed, m; !reflect.DeepEqual(got, want) {
+				t.Errorf("got manifest %+v, want %+v", got, want)
+			}
+		})
+	}
+}
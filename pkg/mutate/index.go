@@ -0,0 +1,63 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	ggcrmutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// refNameAnnotation is the annotation used to record the reference of an index entry.
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// IndexEntry describes an image to be added to an index.
+type IndexEntry struct {
+	// Image is the image to add.
+	Image v1.Image
+
+	// Platform is the platform of the image. If the zero value is specified, the platform is
+	// taken from the image config.
+	Platform v1.Platform
+
+	// Ref is the reference to associate with the image, via the
+	// "org.opencontainers.image.ref.name" annotation. If empty, no reference is associated.
+	Ref string
+}
+
+// IndexFromImages returns an OCI image index containing each of the supplied images, in order.
+// The descriptor of each child records the platform and reference of the corresponding entry.
+func IndexFromImages(entries []IndexEntry) (v1.ImageIndex, error) {
+	adds := make([]ggcrmutate.IndexAddendum, 0, len(entries))
+
+	for _, e := range entries {
+		p := e.Platform
+		if p.Equals(v1.Platform{}) {
+			cf, err := e.Image.ConfigFile()
+			if err != nil {
+				return nil, err
+			}
+
+			if cp := cf.Platform(); cp != nil {
+				p = *cp
+			}
+		}
+
+		desc := v1.Descriptor{
+			Platform: &p,
+		}
+
+		if e.Ref != "" {
+			desc.Annotations = map[string]string{refNameAnnotation: e.Ref}
+		}
+
+		adds = append(adds, ggcrmutate.IndexAddendum{
+			Add:        e.Image,
+			Descriptor: desc,
+		})
+	}
+
+	return ggcrmutate.AppendManifests(empty.Index, adds...), nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestIndexFromImages(t *testing.T) {
+	platforms := []v1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		{OS: "linux", Architecture: "riscv64"},
+	}
+
+	var entries []IndexEntry
+	for _, p := range platforms {
+		img, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"),
+			SetPlatformFromGo(p.OS, p.Architecture, ""),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries = append(entries, IndexEntry{
+			Image:    img,
+			Platform: p,
+			Ref:      "hello-world:" + p.Architecture,
+		})
+	}
+
+	// An entry without an explicit platform takes the platform from the image config.
+	entries = append(entries, IndexEntry{
+		Image: corpus.Image(t, "hello-world-docker-v2-manifest"),
+	})
+
+	ii, err := IndexFromImages(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Fatal(err)
+	}
+
+	if mt, err := ii.MediaType(); err != nil {
+		t.Fatal(err)
+	} else if got, want := mt, types.OCIImageIndex; got != want {
+		t.Errorf("got media type %v, want %v", got, want)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(im.Manifests), len(entries); got != want {
+		t.Fatalf("got %v manifests, want %v", got, want)
+	}
+
+	for i, p := range platforms {
+		desc := im.Manifests[i]
+
+		if got, want := *desc.Platform, p; !got.Equals(want) {
+			t.Errorf("got platform %v, want %v", got, want)
+		}
+
+		if got, want := desc.Annotations[refNameAnnotation], entries[i].Ref; got != want {
+			t.Errorf("got ref %q, want %q", got, want)
+		}
+
+		img, err := ii.Image(desc.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cf, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := cf.Architecture, p.Architecture; got != want {
+			t.Errorf("got config architecture %v, want %v", got, want)
+		}
+	}
+
+	last := im.Manifests[len(platforms)]
+
+	if got, want := *last.Platform, (v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}); !got.Equals(want) {
+		t.Errorf("got platform %v, want %v", got, want)
+	}
+
+	if _, ok := last.Annotations[refNameAnnotation]; ok {
+		t.Error("got ref annotation, want none")
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"maps"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// DropLabelsByPrefix removes all labels with keys beginning with prefix from the image config.
+// This is useful to scrub labels injected by a build system (e.g. "internal.ci.") in bulk.
+func DropLabelsByPrefix(prefix string) Mutation {
+	return func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			maps.DeleteFunc(cf.Config.Labels, func(k, _ string) bool {
+				return strings.HasPrefix(k, prefix)
+			})
+		})
+		return nil
+	}
+}
+
+// ApplyLabelSets merges the label sets in sets into the labels of the image config. Sets are merged
+// in order, so where a key appears in more than one set, the value from the last set is used.
+// Existing labels are retained, unless replaced by a set.
+func ApplyLabelSets(sets ...map[string]string) Mutation {
+	labels := make(map[string]string)
+	for _, s := range sets {
+		maps.Copy(labels, s)
+	}
+
+	return Config(func(c *v1.Config) {
+		if c.Labels == nil && len(labels) > 0 {
+			c.Labels = make(map[string]string, len(labels))
+		}
+		maps.Copy(c.Labels, labels)
+	})
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"maps"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestDropLabelsByPrefix(t *testing.T) {
+	labels := map[string]string{
+		"internal.ci.job":                   "1234",
+		"internal.ci.runner":                "runner-1",
+		"internal.build":                    "true",
+		"org.opencontainers.image.title":    "hello-world",
+		"org.opencontainers.image.revision": "abcdef",
+		"internalize":                       "kept",
+	}
+
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		prefix     string
+		wantLabels map[string]string
+	}{
+		{
+			name:   "Internal",
+			labels: labels,
+			prefix: "internal.",
+			wantLabels: map[string]string{
+				"org.opencontainers.image.title":    "hello-world",
+				"org.opencontainers.image.revision": "abcdef",
+				"internalize":                       "kept",
+			},
+		},
+		{
+			name:   "InternalCI",
+			labels: labels,
+			prefix: "internal.ci.",
+			wantLabels: map[string]string{
+				"internal.build":                    "true",
+				"org.opencontainers.image.title":    "hello-world",
+				"org.opencontainers.image.revision": "abcdef",
+				"internalize":                       "kept",
+			},
+		},
+		{
+			name:       "NoLabels",
+			prefix:     "internal.",
+			wantLabels: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"),
+				Config(func(c *v1.Config) { c.Labels = maps.Clone(tt.labels) }),
+				DropLabelsByPrefix(tt.prefix),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Image(img); err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Config.Labels, tt.wantLabels; !maps.Equal(got, want) {
+				t.Errorf("got labels %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyLabelSets(t *testing.T) {
+	base, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"),
+		Config(func(c *v1.Config) { c.Labels = map[string]string{"base": "kept", "a": "base"} }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		sets       []map[string]string
+		wantLabels map[string]string
+	}{
+		{
+			name: "Precedence",
+			sets: []map[string]string{
+				{"a": "1", "b": "1", "c": "1"},
+				{"b": "2", "c": "2"},
+				{"c": "3"},
+			},
+			wantLabels: map[string]string{
+				"base": "kept",
+				"a":    "1",
+				"b":    "2",
+				"c":    "3",
+			},
+		},
+		{
+			name: "NoSets",
+			wantLabels: map[string]string{
+				"base": "kept",
+				"a":    "base",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(base, ApplyLabelSets(tt.sets...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Image(img); err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Config.Labels, tt.wantLabels; !maps.Equal(got, want) {
+				t.Errorf("got labels %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// headerFunc is called for each entry in a TAR stream. It may modify hdr in place, and returns
+// false if the entry should be dropped from the stream.
+type headerFunc func(hdr *tar.Header) (bool, error)
+
+// mapTAR copies the TAR stream from r to w, transforming each header with fn.
+func mapTAR(r io.Reader, w io.Writer, fn headerFunc) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if keep, err := fn(hdr); err != nil {
+			return err
+		} else if !keep {
+			continue
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		// Disable gosec G110: Potential DoS vulnerability via decompression bomb.
+		// We are just filtering a flow directly from tar reader to tar writer - we aren't reading
+		// into memory beyond the stdlib buffering.
+		//nolint:gosec
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// mapLayer returns a layer containing the TAR stream of base, with each header transformed by fn.
+func mapLayer(base v1.Layer, fn headerFunc) (v1.Layer, error) {
+	opener := func() (io.ReadCloser, error) {
+		rc, err := base.Uncompressed()
+		if err != nil {
+			return nil, err
+		}
+
+		pr, pw := io.Pipe()
+
+		go func() {
+			defer rc.Close()
+			pw.CloseWithError(mapTAR(rc, pw, fn))
+		}()
+
+		return pr, nil
+	}
+
+	return tarball.LayerFromOpener(opener)
+}
+
+// rewritePath applies rewrite to the TAR entry name. If name is a whiteout marker, rewrite is
+// applied to the path that is whited out, and the marker is relocated accordingly.
+func rewritePath(name string, rewrite func(string) (string, bool)) (string, bool) {
+	name = filepath.Clean(name)
+
+	dir, base := filepath.Split(name)
+
+	switch {
+	case base == aufsOpaqueMarker:
+		dir, ok := rewrite(filepath.Clean(dir))
+		return filepath.Join(dir, aufsOpaqueMarker), ok
+
+	case strings.HasPrefix(base, aufsWhiteoutPrefix):
+		target, ok := rewrite(filepath.Join(dir, strings.TrimPrefix(base, aufsWhiteoutPrefix)))
+		return filepath.Join(filepath.Dir(target), aufsWhiteoutPrefix+filepath.Base(target)), ok
+
+	default:
+		return rewrite(name)
+	}
+}
+
+var errLinkTargetDropped = errors.New("hard link target dropped")
+
+// RewriteLayerPaths returns a layer containing the content of base, with the name of each entry
+// rewritten by rewrite. The rewrite function is called with a clean path (per filepath.Clean) and
+// returns the new path, or false if the entry should be dropped from the layer.
+//
+// Whiteout markers are rewritten according to the path they white out. The targets of hard links
+// are rewritten consistently with the entries they refer to. If the target of a hard link is
+// dropped, an error is returned.
+func RewriteLayerPaths(base v1.Layer, rewrite func(path string) (string, bool)) (v1.Layer, error) {
+	return mapLayer(base, func(hdr *tar.Header) (bool, error) {
+		name, ok := rewritePath(hdr.Name, rewrite)
+		if !ok {
+			return false, nil
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			// The directory name in the name field should end with a slash.
+			name += string(filepath.Separator)
+		}
+		hdr.Name = name
+
+		if hdr.Typeflag == tar.TypeLink {
+			target, ok := rewrite(filepath.Clean(hdr.Linkname))
+			if !ok {
+				return false, fmt.Errorf("%w: %v", errLinkTargetDropped, hdr.Linkname)
+			}
+			hdr.Linkname = target
+		}
+
+		return true, nil
+	})
+}
+
+var errNegativeID = errors.New("negative ID")
+
+// ChownLayer returns a layer containing the content of base, with the ownership of every entry set
+// to uid and gid. User and group names are cleared, so that the numeric IDs take effect.
+func ChownLayer(base v1.Layer, uid, gid int) (v1.Layer, error) {
+	if uid < 0 || gid < 0 {
+		return nil, fmt.Errorf("%w: %v:%v", errNegativeID, uid, gid)
+	}
+
+	return mapLayer(base, func(hdr *tar.Header) (bool, error) {
+		hdr.Uid = uid
+		hdr.Gid = gid
+		hdr.Uname = ""
+		hdr.Gname = ""
+
+		// Ownership may also be recorded in PAX records, which would otherwise take precedence.
+		for _, k := range []string{"uid", "gid", "uname", "gname"} {
+			delete(hdr.PAXRecords, k)
+		}
+
+		return true, nil
+	})
+}
+
+// tarLayerMediaType returns the media type to use for a gzip-compressed TAR layer that replaces
+// base. Docker layers are replaced by Docker layers, and all other layers by OCI layers.
+func tarLayerMediaType(base v1.Layer) (types.MediaType, error) {
+	mt, err := base.MediaType()
+	if err != nil {
+		return "", err
+	}
+
+	if mt == types.DockerLayer {
+		return types.DockerLayer, nil
+	}
+	return types.OCILayer, nil
+}
+
+// ReplaceLayerTar replaces the layer at index i in the base image with a layer containing the TAR
+// stream read from r. The remaining layers, and their positions, are unchanged.
+//
+// The TAR stream is buffered in memory, so that the resulting layer can be read more than once.
+func ReplaceLayerTar(base v1.Image, i int, r io.Reader) (v1.Image, error) {
+	ls, err := base.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	if i < 0 || i >= len(ls) {
+		return nil, errInvalidLayerIndex
+	}
+
+	mt, err := tarLayerMediaType(ls[i])
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}, tarball.WithMediaType(mt))
+	if err != nil {
+		return nil, err
+	}
+
+	return Apply(base, SetLayer(i, l))
+}
+
+// LayerDigests returns the digests of the (compressed) layers of img, in order. The digests are
+// read from the image manifest. For images returned by this package, layer descriptors are
+// computed once and cached, so the digests are stable even where a layer is not deterministic.
+func LayerDigests(img v1.Image) ([]v1.Hash, error) {
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	hs := make([]v1.Hash, 0, len(m.Layers))
+	for _, desc := range m.Layers {
+		hs = append(hs, desc.Digest)
+	}
+
+	return hs, nil
+}
+
+// AssertDescriptorSizes verifies that the size and digest of each layer descriptor in the manifest
+// of img match the compressed content of the corresponding layer. This guards against layer
+// implementations that report stale metadata. Each layer is read in full, so this is expensive
+// for large images. Non-distributable layers are not read.
+func AssertDescriptorSizes(img v1.Image) error {
+	m, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+
+	ls, err := img.Layers()
+	if err != nil {
+		return err
+	}
+
+	if got, want := len(ls), len(m.Layers); got != want {
+		return fmt.Errorf("%w: %v layers, %v descriptors", errLayerCountMismatch, got, want)
+	}
+
+	for i, l := range ls {
+		desc := m.Layers[i]
+
+		if !desc.MediaType.IsDistributable() {
+			continue
+		}
+
+		rc, err := l.Compressed()
+		if err != nil {
+			return err
+		}
+
+		h, n, err := v1.SHA256(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+
+		if n != desc.Size {
+			return fmt.Errorf("%w: layer %v: descriptor size %v, content size %v",
+				errSizeMismatch, i, desc.Size, n,
+			)
+		}
+
+		if h != desc.Digest {
+			return fmt.Errorf("%w: layer %v: descriptor digest %v, content digest %v",
+				errDigestMismatch, i, desc.Digest, h,
+			)
+		}
+	}
+
+	return nil
+}
+
+// removeLayerHistory returns history with the entry corresponding to the layer at index i removed.
+// Entries with EmptyLayer set do not correspond to a layer. If history does not describe n layers,
+// it is returned unchanged.
+func removeLayerHistory(history []v1.History, i, n int) []v1.History {
+	var indexes []int
+	for j, h := range history {
+		if !h.EmptyLayer {
+			indexes = append(indexes, j)
+		}
+	}
+
+	if len(indexes) != n {
+		return history
+	}
+
+	return slices.Delete(slices.Clone(history), indexes[i], indexes[i]+1)
+}
+
+// RemoveLayerByDigest returns an image based on base, with the layer whose (compressed) digest is
+// h removed. The corresponding history entry, if present, is also removed. If more than one layer
+// has digest h, only the first is removed.
+func RemoveLayerByDigest(base v1.Image, h v1.Hash) (v1.Image, error) {
+	ls, err := base.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	i := -1
+
+	for j, l := range ls {
+		d, err := l.Digest()
+		if err != nil {
+			return nil, err
+		}
+
+		if d == h {
+			i = j
+			break
+		}
+	}
+
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %v", errLayerNotFound, h)
+	}
+
+	return removeLayer(base, ls, i)
+}
+
+// RemoveLayer returns an image based on base, with the layer at index i removed. Subsequent layers
+// are shifted down, and the corresponding history entry, if present, is also removed. An error is
+// returned if i is out of range.
+func RemoveLayer(base v1.Image, i int) (v1.Image, error) {
+	ls, err := base.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	if i < 0 || i >= len(ls) {
+		return nil, fmt.Errorf("%w: %v (image has %v layers)", errInvalidLayerIndex, i, len(ls))
+	}
+
+	return removeLayer(base, ls, i)
+}
+
+// removeLayer returns an image based on base, with the layer at index i of ls, the layers of base,
+// removed.
+func removeLayer(base v1.Image, ls []v1.Layer, i int) (v1.Image, error) {
+	layers := slices.Delete(slices.Clone(ls), i, i+1)
+
+	return Apply(base, func(img *image) error {
+		img.overrides = layers
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.History = removeLayerHistory(cf.History, i, len(ls))
+		})
+		return nil
+	})
+}
+
+// ReplaceLayerByDiffID returns an image based on base, with the layer whose (uncompressed) diffID
+// is oldDiffID replaced by l. Other layers are unchanged. If more than one layer has diffID
+// oldDiffID, only the first is replaced. The diffIDs in the config, and the layer descriptors in
+// the manifest, reflect the replacement layer.
+func ReplaceLayerByDiffID(base v1.Image, oldDiffID v1.Hash, l v1.Layer) (v1.Image, error) {
+	cf, err := base.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	i := slices.Index(cf.RootFS.DiffIDs, oldDiffID)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %v", errLayerNotFound, oldDiffID)
+	}
+
+	return Apply(base, SetLayer(i, l))
+}
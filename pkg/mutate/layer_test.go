@@ -0,0 +1,725 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+// tarEntry describes an entry in a layer TAR stream.
+type tarEntry struct {
+	typeflag byte
+	name     string
+	linkname string
+	content  string
+}
+
+// tarBytes returns a TAR stream with the supplied entries.
+func tarBytes(tb testing.TB, tes ...tarEntry) []byte {
+	tb.Helper()
+
+	var b bytes.Buffer
+
+	tw := tar.NewWriter(&b)
+	for _, te := range tes {
+		hdr := tar.Header{
+			Typeflag: te.typeflag,
+			Name:     te.name,
+			Linkname: te.linkname,
+			Mode:     0o644,
+			Size:     int64(len(te.content)),
+		}
+
+		if err := tw.WriteHeader(&hdr); err != nil {
+			tb.Fatal(err)
+		}
+
+		if _, err := io.WriteString(tw, te.content); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+// tarLayer returns a layer containing a TAR stream with the supplied entries.
+func tarLayer(tb testing.TB, tes ...tarEntry) v1.Layer {
+	tb.Helper()
+
+	b := tarBytes(tb, tes...)
+
+	l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return l
+}
+
+// readTAR returns the entries of the TAR stream of l.
+func readTAR(l v1.Layer) ([]tarEntry, error) {
+	rc, err := l.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var tes []tarEntry
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return tes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		tes = append(tes, tarEntry{
+			typeflag: hdr.Typeflag,
+			name:     hdr.Name,
+			linkname: hdr.Linkname,
+			content:  string(b),
+		})
+	}
+}
+
+func TestRewriteLayerPaths(t *testing.T) {
+	base := tarLayer(t,
+		tarEntry{typeflag: tar.TypeDir, name: "./"},
+		tarEntry{typeflag: tar.TypeDir, name: "usr/local/bin/"},
+		tarEntry{typeflag: tar.TypeReg, name: "usr/local/bin/foo", content: "foo"},
+		tarEntry{typeflag: tar.TypeLink, name: "usr/local/bin/bar", linkname: "usr/local/bin/foo"},
+		tarEntry{typeflag: tar.TypeReg, name: "usr/local/bin/.wh.baz"},
+		tarEntry{typeflag: tar.TypeReg, name: "usr/local/bin/.wh..wh..opq"},
+		tarEntry{typeflag: tar.TypeSymlink, name: "usr/local/bin/qux", linkname: "foo"},
+		tarEntry{typeflag: tar.TypeReg, name: "etc/passwd", content: "root"},
+	)
+
+	// relocate returns a rewrite function that moves entries under from to under to. Entries
+	// outside of from are dropped.
+	relocate := func(from, to string) func(string) (string, bool) {
+		return func(path string) (string, bool) {
+			if path == "." {
+				return path, true
+			}
+
+			if rel, err := filepath.Rel(from, path); err == nil && !strings.HasPrefix(rel, "..") {
+				return filepath.Join(to, rel), true
+			}
+
+			return "", false
+		}
+	}
+
+	tests := []struct {
+		name        string
+		rewrite     func(string) (string, bool)
+		wantErr     error
+		wantEntries []tarEntry
+	}{
+		{
+			name:    "Relocate",
+			rewrite: relocate("usr/local/bin", "opt/bin"),
+			wantEntries: []tarEntry{
+				{typeflag: tar.TypeDir, name: "./"},
+				{typeflag: tar.TypeDir, name: "opt/bin/"},
+				{typeflag: tar.TypeReg, name: "opt/bin/foo", content: "foo"},
+				{typeflag: tar.TypeLink, name: "opt/bin/bar", linkname: "opt/bin/foo"},
+				{typeflag: tar.TypeReg, name: "opt/bin/.wh.baz"},
+				{typeflag: tar.TypeReg, name: "opt/bin/.wh..wh..opq"},
+				{typeflag: tar.TypeSymlink, name: "opt/bin/qux", linkname: "foo"},
+			},
+		},
+		{
+			name: "LinkTargetDropped",
+			rewrite: func(path string) (string, bool) {
+				return path, path != "usr/local/bin/foo"
+			},
+			wantErr: errLinkTargetDropped,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tes []tarEntry
+
+			l, err := RewriteLayerPaths(base, tt.rewrite)
+			if err == nil {
+				tes, err = readTAR(l)
+			}
+
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if got, want := tes, tt.wantEntries; !slices.Equal(got, want) {
+				t.Errorf("got entries %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReplaceLayerTar(t *testing.T) {
+	base := corpus.Image(t, "many-layers")
+
+	tes := []tarEntry{
+		{typeflag: tar.TypeReg, name: "foo", content: "foo"},
+	}
+
+	tests := []struct {
+		name    string
+		i       int
+		wantErr error
+	}{
+		{name: "First", i: 0},
+		{name: "Second", i: 1},
+		{name: "Negative", i: -1, wantErr: errInvalidLayerIndex},
+		{name: "OutOfRange", i: 50, wantErr: errInvalidLayerIndex},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := ReplaceLayerTar(base, tt.i, bytes.NewReader(tarBytes(t, tes...)))
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err := validate.Image(img); err != nil {
+				t.Fatal(err)
+			}
+
+			want := layerDigests(t, base)
+			got := layerDigests(t, img)
+
+			if len(got) != len(want) {
+				t.Fatalf("got %v layers, want %v", len(got), len(want))
+			}
+
+			for i := range got {
+				if changed := got[i] != want[i]; changed != (i == tt.i) {
+					t.Errorf("layer %v: got changed %v, want %v", i, changed, i == tt.i)
+				}
+			}
+
+			ls, err := img.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotEntries, err := readTAR(ls[tt.i])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(gotEntries, tes) {
+				t.Errorf("got entries %+v, want %+v", gotEntries, tes)
+			}
+		})
+	}
+}
+
+// countingLayer wraps a layer, counting calls to Compressed. The digest is computed from the
+// compressed content each time it is requested.
+type countingLayer struct {
+	v1.Layer
+	compressed int
+}
+
+func (l *countingLayer) Compressed() (io.ReadCloser, error) {
+	l.compressed++
+	return l.Layer.Compressed()
+}
+
+func (l *countingLayer) Digest() (v1.Hash, error) {
+	rc, err := l.Compressed()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	defer rc.Close()
+
+	h, _, err := v1.SHA256(rc)
+	return h, err
+}
+
+// staleLayer wraps a layer, reporting a size and digest that may not match its content.
+type staleLayer struct {
+	v1.Layer
+	size   int64
+	digest v1.Hash
+}
+
+func (l *staleLayer) Size() (int64, error)     { return l.size, nil }
+func (l *staleLayer) Digest() (v1.Hash, error) { return l.digest, nil }
+
+func TestAssertDescriptorSizes(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	l := static.NewLayer([]byte("foobar"), types.DockerLayer)
+
+	size, err := l.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := l.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		layer   v1.Layer
+		wantErr error
+	}{
+		{
+			name:  "Accurate",
+			layer: &staleLayer{Layer: l, size: size, digest: digest},
+		},
+		{
+			name:    "StaleSize",
+			layer:   &staleLayer{Layer: l, size: size + 1, digest: digest},
+			wantErr: errSizeMismatch,
+		},
+		{
+			name: "StaleDigest",
+			layer: &staleLayer{Layer: l, size: size, digest: v1.Hash{
+				Algorithm: "sha256",
+				Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+			}},
+			wantErr: errDigestMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(base, AppendLayer(tt.layer))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := AssertDescriptorSizes(img), tt.wantErr; !errors.Is(got, want) {
+				t.Errorf("got error %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLayerDigests(t *testing.T) {
+	base := corpus.Image(t, "many-layers")
+
+	baseLayers, err := base.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms := make([]Mutation, 0, len(baseLayers))
+	cls := make([]*countingLayer, 0, len(baseLayers))
+
+	for i, l := range baseLayers {
+		cl := &countingLayer{Layer: l}
+		cls = append(cls, cl)
+		ms = append(ms, SetLayer(i, cl))
+	}
+
+	img, err := Apply(base, ms...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d1, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d2, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d1 != d2 {
+		t.Errorf("got digests %v and %v, want equal", d1, d2)
+	}
+
+	got, err := LayerDigests(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := layerDigests(t, base); !slices.Equal(got, want) {
+		t.Errorf("got layer digests %v, want %v", got, want)
+	}
+
+	for i, cl := range cls {
+		if got, want := cl.compressed, 1; got != want {
+			t.Errorf("layer %v: got %v calls to Compressed, want %v", i, got, want)
+		}
+	}
+}
+
+func TestRemoveLayerByDigest(t *testing.T) {
+	base := corpus.Image(t, "many-layers")
+
+	baseDigests := layerDigests(t, base)
+
+	baseCF, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		h       v1.Hash
+		wantErr bool
+	}{
+		{
+			name: "First",
+			h:    baseDigests[0],
+		},
+		{
+			name: "Middle",
+			h:    baseDigests[len(baseDigests)/2],
+		},
+		{
+			name: "Last",
+			h:    baseDigests[len(baseDigests)-1],
+		},
+		{
+			name: "NotFound",
+			h: v1.Hash{
+				Algorithm: "sha256",
+				Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := RemoveLayerByDigest(base, tt.h)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err := validate.Image(img); err != nil {
+				t.Fatal(err)
+			}
+
+			i := slices.Index(baseDigests, tt.h)
+			want := slices.Delete(slices.Clone(baseDigests), i, i+1)
+
+			if got := layerDigests(t, img); !slices.Equal(got, want) {
+				t.Errorf("got digests %v, want %v", got, want)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := len(cf.History), len(baseCF.History)-1; got != want {
+				t.Errorf("got %v history entries, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRemoveLayer(t *testing.T) {
+	base := corpus.Image(t, "many-layers")
+
+	baseDigests := layerDigests(t, base)
+
+	baseCF, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		i       int
+		wantErr bool
+	}{
+		{
+			name: "First",
+			i:    0,
+		},
+		{
+			name: "Middle",
+			i:    len(baseDigests) / 2,
+		},
+		{
+			name: "Last",
+			i:    len(baseDigests) - 1,
+		},
+		{
+			name:    "Negative",
+			i:       -1,
+			wantErr: true,
+		},
+		{
+			name:    "OutOfRange",
+			i:       len(baseDigests),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := RemoveLayer(base, tt.i)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err := validate.Image(img); err != nil {
+				t.Fatal(err)
+			}
+
+			want := slices.Delete(slices.Clone(baseDigests), tt.i, tt.i+1)
+
+			if got := layerDigests(t, img); !slices.Equal(got, want) {
+				t.Errorf("got digests %v, want %v", got, want)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantDiffIDs := slices.Delete(slices.Clone(baseCF.RootFS.DiffIDs), tt.i, tt.i+1)
+
+			if got, want := cf.RootFS.DiffIDs, wantDiffIDs; !slices.Equal(got, want) {
+				t.Errorf("got diffIDs %v, want %v", got, want)
+			}
+
+			if got, want := len(cf.History), len(baseCF.History)-1; got != want {
+				t.Errorf("got %v history entries, want %v", got, want)
+			}
+
+			// The manifest must reference the recomputed config.
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h, err := img.ConfigName()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := m.Config.Digest, h; got != want {
+				t.Errorf("got config digest %v, want %v", got, want)
+			}
+
+			if got, err := base.ConfigName(); err != nil {
+				t.Fatal(err)
+			} else if got == h {
+				t.Errorf("got unchanged config digest %v", got)
+			}
+		})
+	}
+}
+
+func TestReplaceLayerByDiffID(t *testing.T) {
+	base := corpus.Image(t, "many-layers")
+
+	baseDigests := layerDigests(t, base)
+
+	baseCF, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := tarLayer(t, tarEntry{typeflag: tar.TypeReg, name: "patched", content: "patched"})
+
+	newDigest, err := l.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newDiffID, err := l.DiffID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		diffID  v1.Hash
+		wantErr bool
+	}{
+		{
+			name:   "First",
+			diffID: baseCF.RootFS.DiffIDs[0],
+		},
+		{
+			name:   "Last",
+			diffID: baseCF.RootFS.DiffIDs[len(baseCF.RootFS.DiffIDs)-1],
+		},
+		{
+			name: "NotFound",
+			diffID: v1.Hash{
+				Algorithm: "sha256",
+				Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := ReplaceLayerByDiffID(base, tt.diffID, l)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err := validate.Image(img); err != nil {
+				t.Fatal(err)
+			}
+
+			i := slices.Index(baseCF.RootFS.DiffIDs, tt.diffID)
+
+			want := slices.Clone(baseDigests)
+			want[i] = newDigest
+
+			if got := layerDigests(t, img); !slices.Equal(got, want) {
+				t.Errorf("got digests %v, want %v", got, want)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantDiffIDs := slices.Clone(baseCF.RootFS.DiffIDs)
+			wantDiffIDs[i] = newDiffID
+
+			if got, want := cf.RootFS.DiffIDs, wantDiffIDs; !slices.Equal(got, want) {
+				t.Errorf("got diffIDs %v, want %v", got, want)
+			}
+
+			if got, want := len(cf.History), len(baseCF.History); got != want {
+				t.Errorf("got %v history entries, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestChownLayer(t *testing.T) {
+	base := tarLayer(t,
+		tarEntry{typeflag: tar.TypeDir, name: "dir/"},
+		tarEntry{typeflag: tar.TypeReg, name: "dir/file", content: "content"},
+		tarEntry{typeflag: tar.TypeSymlink, name: "link", linkname: "dir/file"},
+	)
+
+	tests := []struct {
+		name    string
+		uid     int
+		gid     int
+		wantErr error
+	}{
+		{name: "User", uid: 1000, gid: 1000},
+		{name: "Root", uid: 0, gid: 0},
+		{name: "NegativeUID", uid: -1, gid: 1000, wantErr: errNegativeID},
+		{name: "NegativeGID", uid: 1000, gid: -1, wantErr: errNegativeID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := ChownLayer(base, tt.uid, tt.gid)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			rc, err := l.Uncompressed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+
+			var n int
+
+			tr := tar.NewReader(rc)
+			for {
+				hdr, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, want := hdr.Uid, tt.uid; got != want {
+					t.Errorf("%v: got uid %v, want %v", hdr.Name, got, want)
+				}
+
+				if got, want := hdr.Gid, tt.gid; got != want {
+					t.Errorf("%v: got gid %v, want %v", hdr.Name, got, want)
+				}
+
+				if hdr.Uname != "" || hdr.Gname != "" {
+					t.Errorf("%v: got names %q:%q, want empty", hdr.Name, hdr.Uname, hdr.Gname)
+				}
+
+				n++
+			}
+
+			if got, want := n, 3; got != want {
+				t.Errorf("got %v entries, want %v", got, want)
+			}
+
+			// Content is unchanged.
+			got, err := readTAR(l)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := readTAR(base)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, want) {
+				t.Errorf("got entries %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// MergePolicy specifies how conflicting values are resolved when merging.
+type MergePolicy int
+
+const (
+	// OverlayWins resolves a conflict in favour of the overlay value.
+	OverlayWins MergePolicy = iota
+
+	// BaseWins resolves a conflict in favour of the base value.
+	BaseWins
+
+	// ErrorOnConflict causes a conflict to be reported as an error.
+	ErrorOnConflict
+)
+
+// ErrEnvConflict is returned when an environment variable is set to different values in the base
+// and overlay, and the merge policy is ErrorOnConflict.
+var ErrEnvConflict = errors.New("environment variable conflict")
+
+var errUnknownMergePolicy = errors.New("unknown merge policy")
+
+// mergeOpts accumulates merge options.
+type mergeOpts struct {
+	mergeEnv  bool
+	envPolicy MergePolicy
+}
+
+// MergeOpt are used to specify merge options.
+type MergeOpt func(*mergeOpts) error
+
+// OptMergeEnv specifies whether environment variables from the lower image are retained in the
+// merged image. Where a variable is set in both images, the value from the upper image is used.
+func OptMergeEnv(b bool) MergeOpt {
+	return func(mo *mergeOpts) error {
+		mo.mergeEnv = b
+		return nil
+	}
+}
+
+// OptMergeEnvPolicy specifies that environment variables from the lower image are retained in the
+// merged image, with conflicts resolved according to p, as described for MergeEnv. The lower
+// image is the base, and the upper image is the overlay.
+func OptMergeEnvPolicy(p MergePolicy) MergeOpt {
+	return func(mo *mergeOpts) error {
+		mo.mergeEnv = true
+		mo.envPolicy = p
+		return nil
+	}
+}
+
+// MergeEnv combines the environment variables in base and overlay. Variables present in base
+// retain their position. Variables only present in overlay are appended in the order they appear
+// in overlay.
+//
+// Where a variable is set to different values in base and overlay, the conflict is resolved
+// according to policy. With OverlayWins, the value from overlay is used. With BaseWins, the value
+// from base is used. With ErrorOnConflict, an error wrapping ErrEnvConflict is returned.
+func MergeEnv(base, overlay []string, policy MergePolicy) ([]string, error) {
+	if policy != OverlayWins && policy != BaseWins && policy != ErrorOnConflict {
+		return nil, fmt.Errorf("%w: %v", errUnknownMergePolicy, policy)
+	}
+
+	env := slices.Clone(base)
+
+	index := make(map[string]int, len(env))
+	for i, e := range env {
+		k, _ := splitEnv(e)
+		index[k] = i
+	}
+
+	for _, e := range overlay {
+		k, _ := splitEnv(e)
+
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(env)
+			env = append(env, e)
+			continue
+		}
+
+		if env[i] == e {
+			continue
+		}
+
+		switch policy {
+		case OverlayWins:
+			env[i] = e
+		case BaseWins:
+			// Retain the value from base.
+		case ErrorOnConflict:
+			return nil, fmt.Errorf("%w: %v", ErrEnvConflict, k)
+		}
+	}
+
+	return env, nil
+}
+
+// Merge returns an image that combines the filesystems of lower and upper. The layers of the
+// returned image are the layers of lower, followed by the layers of upper, so that content
+// (including whiteouts) in upper takes precedence over content in lower. The history of the
+// returned image is the history of lower, followed by the history of upper. Where only one of the
+// images has history, an empty entry is recorded for each layer of the other, so that the history
+// remains consistent with the layers.
+//
+// The runtime configuration (environment, entrypoint, command, etc.) of the returned image is
+// taken from upper. By default, environment variables set only in lower are discarded. To retain
+// them, consider using OptMergeEnv or OptMergeEnvPolicy. Other fields of the config, such as the
+// platform, are taken from lower.
+func Merge(lower, upper v1.Image, opts ...MergeOpt) (v1.Image, error) {
+	mo := mergeOpts{}
+
+	for _, opt := range opts {
+		if err := opt(&mo); err != nil {
+			return nil, err
+		}
+	}
+
+	lowerLayers, err := lower.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	upperLayers, err := upper.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	lowerConfig, err := lower.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	upperConfig, err := upper.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := append(slices.Clone(lowerLayers), upperLayers...)
+
+	var history []v1.History
+	if len(lowerConfig.History) > 0 || len(upperConfig.History) > 0 {
+		history = append(
+			padLayerHistory(lowerConfig.History, len(lowerLayers)),
+			padLayerHistory(upperConfig.History, len(upperLayers))...,
+		)
+	}
+
+	config := *upperConfig.Config.DeepCopy()
+	if mo.mergeEnv {
+		env, err := MergeEnv(lowerConfig.Config.Env, upperConfig.Config.Env, mo.envPolicy)
+		if err != nil {
+			return nil, err
+		}
+		config.Env = env
+	}
+
+	return Apply(lower, func(img *image) error {
+		img.overrides = layers
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.History = history
+			cf.Config = config
+		})
+		return nil
+	})
+}
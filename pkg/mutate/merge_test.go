@@ -0,0 +1,181 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+// layerDigests returns the digests of the layers of img.
+func layerDigests(tb testing.TB, img v1.Image) []v1.Hash {
+	tb.Helper()
+
+	ls, err := img.Layers()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	hs := make([]v1.Hash, 0, len(ls))
+	for _, l := range ls {
+		h, err := l.Digest()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		hs = append(hs, h)
+	}
+
+	return hs
+}
+
+func TestMerge(t *testing.T) {
+	lower := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	upper, err := Apply(corpus.Image(t, "whiteout-explicit-file"),
+		SetConfig(&v1.ConfigFile{
+			Config: v1.Config{
+				Entrypoint: []string{"/bin/upper"},
+				Env:        []string{"FOO=bar", "PATH=/upper"},
+			},
+		}, types.DockerConfigJSON),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noHistory, err := Apply(corpus.Image(t, "whiteout-explicit-file"), func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.History = nil
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		lower          v1.Image
+		upper          v1.Image
+		opts           []MergeOpt
+		wantEntrypoint []string
+		wantEnv        []string
+	}{
+		{
+			name:           "UpperConfig",
+			lower:          lower,
+			upper:          upper,
+			wantEntrypoint: []string{"/bin/upper"},
+			wantEnv:        []string{"FOO=bar", "PATH=/upper"},
+		},
+		{
+			name:           "MergeEnv",
+			lower:          lower,
+			upper:          upper,
+			opts:           []MergeOpt{OptMergeEnv(true)},
+			wantEntrypoint: []string{"/bin/upper"},
+			wantEnv:        []string{"PATH=/upper", "FOO=bar"},
+		},
+		{
+			name:           "MergeEnvBaseWins",
+			lower:          lower,
+			upper:          upper,
+			opts:           []MergeOpt{OptMergeEnvPolicy(BaseWins)},
+			wantEntrypoint: []string{"/bin/upper"},
+			wantEnv: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"FOO=bar",
+			},
+		},
+		{
+			name:    "UpperHistoryOnly",
+			lower:   noHistory,
+			upper:   lower,
+			wantEnv: []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Merge(tt.lower, tt.upper, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Image(img); err != nil {
+				t.Error(err)
+			}
+
+			want := append(layerDigests(t, tt.lower), layerDigests(t, tt.upper)...)
+			if got := layerDigests(t, img); !slices.Equal(got, want) {
+				t.Errorf("got layers %v, want %v", got, want)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Config.Entrypoint, tt.wantEntrypoint; !slices.Equal(got, want) {
+				t.Errorf("got entrypoint %v, want %v", got, want)
+			}
+
+			if got, want := cf.Config.Env, tt.wantEnv; !slices.Equal(got, want) {
+				t.Errorf("got env %v, want %v", got, want)
+			}
+
+			// Where only one image has history, the other is padded so that history and layers
+			// remain consistent.
+			if err := AssertLayerHistoryAligned(img); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	base := []string{"PATH=/base", "FOO=foo", "HOME=/root"}
+	overlay := []string{"BAR=bar", "PATH=/overlay", "HOME=/root"}
+
+	tests := []struct {
+		name    string
+		policy  MergePolicy
+		want    []string
+		wantErr error
+	}{
+		{
+			name:   "OverlayWins",
+			policy: OverlayWins,
+			want:   []string{"PATH=/overlay", "FOO=foo", "HOME=/root", "BAR=bar"},
+		},
+		{
+			name:   "BaseWins",
+			policy: BaseWins,
+			want:   []string{"PATH=/base", "FOO=foo", "HOME=/root", "BAR=bar"},
+		},
+		{
+			name:    "ErrorOnConflict",
+			policy:  ErrorOnConflict,
+			wantErr: ErrEnvConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeEnv(base, overlay, tt.policy)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got env %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -18,7 +18,7 @@ var errInvalidLayerIndex = errors.New("invalid layer index")
 // SetLayer sets the layer at index i to l.
 func SetLayer(i int, l v1.Layer) Mutation {
 	return func(img *image) error {
-		if i >= len(img.overrides) {
+		if i < 0 || i >= len(img.overrides) {
 			return errInvalidLayerIndex
 		}
 
@@ -37,10 +37,36 @@ func ReplaceLayers(l v1.Layer) Mutation {
 	}
 }
 
-// SetHistory replaces the history in an image with the specified entry.
+// SetHistory replaces the history in an image with the specified entry, which describes the last
+// layer of the image. Where the image has more than one layer, an empty entry is recorded for each
+// preceding layer, so that the history remains consistent with the layers. If the EmptyLayer field
+// of history is set, an empty entry is recorded for every layer, followed by history.
+//
+// The layers of the image are counted when the mutation is applied, and the history is replaced in
+// order with other mutations of the history. Layers and history entries added by subsequent
+// mutations (e.g. AppendLayerWithHistory) therefore follow history.
 func SetHistory(history v1.History) Mutation {
 	return func(img *image) error {
-		img.history = &history
+		i := len(img.overrides)
+		if !history.EmptyLayer {
+			i--
+		}
+
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.History = appendLayerHistory(nil, i, history)
+		})
+		return nil
+	}
+}
+
+// SetCreatedTime sets the creation time recorded in the config, and in each history entry, to t.
+// Since the creation times of an image otherwise depend on when (and how) it was built, this allows
+// repeated builds to produce identical images. The time is applied once all other mutations have
+// been applied, so history entries added by any mutation, whether specified before or after
+// SetCreatedTime, are affected.
+func SetCreatedTime(t v1.Time) Mutation {
+	return func(img *image) error {
+		img.createdTime = &t
 		return nil
 	}
 }
@@ -54,7 +80,39 @@ func SetConfig(configFile any, configType types.MediaType) Mutation {
 	}
 }
 
+// Config applies each of fns, in order, to the config of the image. This allows arbitrary fields
+// of the config to be set in a single mutation.
+func Config(fns ...func(*v1.Config)) Mutation {
+	return func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			for _, fn := range fns {
+				fn(&cf.Config)
+			}
+		})
+		return nil
+	}
+}
+
+// SetRuntimeConfig replaces the runtime configuration (environment, entrypoint, command, working
+// directory, user, labels, etc.) of the image with cfg. Other fields of the config file, such as
+// the root filesystem and history, are retained.
+func SetRuntimeConfig(cfg v1.Config) Mutation {
+	cfg = *cfg.DeepCopy()
+
+	return func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.Config = *cfg.DeepCopy()
+		})
+		return nil
+	}
+}
+
 // Apply performs the specified mutation(s) to a base image, returning the resulting image.
+//
+// The resulting image is immutable. Mutations are applied before Apply returns, and the manifest,
+// config and layers of the image are computed from base once, on first use, and cached thereafter.
+// Subsequent changes to the content of base, or of the layers supplied to mutations, are not
+// reflected in the resulting image. To observe such changes, call Apply again.
 func Apply(base v1.Image, ms ...Mutation) (v1.Image, error) {
 	if len(ms) == 0 {
 		return base, nil
@@ -6,12 +6,14 @@ package mutate
 
 import (
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/static"
 	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
 	"github.com/sebdah/goldie/v2"
 	"github.com/sylabs/oci-tools/test"
 )
@@ -100,3 +102,309 @@ func TestApply(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig(t *testing.T) {
+	img, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"),
+		Config(
+			func(c *v1.Config) { c.User = "1000:1000" },
+			func(c *v1.Config) { c.WorkingDir = "/work" },
+			func(c *v1.Config) { c.Env = append(c.Env, "FOO=bar") },
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cf.Config.User, "1000:1000"; got != want {
+		t.Errorf("got user %v, want %v", got, want)
+	}
+
+	if got, want := cf.Config.WorkingDir, "/work"; got != want {
+		t.Errorf("got working dir %v, want %v", got, want)
+	}
+
+	wantEnv := []string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"FOO=bar",
+	}
+	if got, want := cf.Config.Env, wantEnv; !slices.Equal(got, want) {
+		t.Errorf("got env %v, want %v", got, want)
+	}
+
+	// The manifest must reference the mutated config.
+	m, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := m.Config.Digest, h; got != want {
+		t.Errorf("got config digest %v, want %v", got, want)
+	}
+}
+
+func TestApplyConfigMutations(t *testing.T) {
+	p := v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+
+	img, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"),
+		SetPlatform(p),
+		ApplyLabelSets(map[string]string{"org.example.key": "value"}),
+		SetCmdExec([]string{"/hello"}),
+		ExpandEnv(map[string]string{"PATH": "$PATH:/opt/bin"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img, validate.Fast); err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cf.Platform(), &p; !got.Equals(*want) {
+		t.Errorf("got platform %v, want %v", got, want)
+	}
+
+	if got, want := cf.Config.Labels["org.example.key"], "value"; got != want {
+		t.Errorf("got label %v, want %v", got, want)
+	}
+
+	if got, want := cf.Config.Cmd, []string{"/hello"}; !slices.Equal(got, want) {
+		t.Errorf("got cmd %v, want %v", got, want)
+	}
+
+	wantEnv := []string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/opt/bin",
+	}
+	if got, want := cf.Config.Env, wantEnv; !slices.Equal(got, want) {
+		t.Errorf("got env %v, want %v", got, want)
+	}
+}
+
+func TestSetHistory(t *testing.T) {
+	base := corpus.Image(t, "many-layers")
+
+	ls, err := base.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := v1.History{CreatedBy: "CreatedBy"}
+
+	appended := v1.History{CreatedBy: "Appended"}
+
+	tests := []struct {
+		name        string
+		h           v1.History
+		ms          []Mutation
+		wantEntries int
+		wantLast    v1.History
+	}{
+		{
+			name:        "Layer",
+			h:           h,
+			wantEntries: len(ls),
+			wantLast:    h,
+		},
+		{
+			name:        "EmptyLayer",
+			h:           v1.History{CreatedBy: "CreatedBy", EmptyLayer: true},
+			wantEntries: len(ls) + 1,
+			wantLast:    v1.History{CreatedBy: "CreatedBy", EmptyLayer: true},
+		},
+		{
+			name: "AppendLayerWithHistory",
+			h:    h,
+			ms: []Mutation{
+				AppendLayerWithHistory(static.NewLayer([]byte("foobar"), types.DockerLayer), appended),
+			},
+			wantEntries: len(ls) + 1,
+			wantLast:    appended,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(base, append([]Mutation{SetHistory(tt.h)}, tt.ms...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := AssertLayerHistoryAligned(img); err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := len(cf.History), tt.wantEntries; got != want {
+				t.Fatalf("got %v history entries, want %v", got, want)
+			}
+
+			if got, want := cf.History[len(cf.History)-1], tt.wantLast; got != want {
+				t.Errorf("got last entry %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSetCreatedTime(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	created := v1.Time{Time: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	// appendAt appends a layer, recording the time at which it is appended, as a build tool might.
+	appendAt := func(now time.Time) Mutation {
+		return AppendLayerWithHistory(static.NewLayer([]byte("layer"), types.DockerLayer), v1.History{
+			Created:   v1.Time{Time: now},
+			CreatedBy: "append",
+		})
+	}
+
+	tests := []struct {
+		name  string
+		build func(now time.Time) []Mutation
+	}{
+		{
+			name: "After",
+			build: func(now time.Time) []Mutation {
+				return []Mutation{appendAt(now), SetCreatedTime(created)}
+			},
+		},
+		{
+			name: "Before",
+			build: func(now time.Time) []Mutation {
+				return []Mutation{SetCreatedTime(created), appendAt(now)}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := Apply(base, tt.build(time.Now())...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			second, err := Apply(base, tt.build(time.Now().Add(time.Hour))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := first.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Created, created; !got.Equal(want.Time) {
+				t.Errorf("got created %v, want %v", got, want)
+			}
+
+			for i, h := range cf.History {
+				if got, want := h.Created, created; !got.Equal(want.Time) {
+					t.Errorf("history %v: got created %v, want %v", i, got, want)
+				}
+			}
+
+			firstDigest, err := first.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			secondDigest, err := second.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if firstDigest != secondDigest {
+				t.Errorf("got digests %v and %v, want identical", firstDigest, secondDigest)
+			}
+		})
+	}
+}
+
+func TestSetRuntimeConfig(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	cfg := v1.Config{
+		Entrypoint: []string{"/bin/app"},
+		Env:        []string{"FOO=bar"},
+	}
+
+	img, err := Apply(base, SetRuntimeConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img); err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cf.Config.Entrypoint, cfg.Entrypoint; !slices.Equal(got, want) {
+		t.Errorf("got entrypoint %v, want %v", got, want)
+	}
+
+	if got, want := cf.Config.Env, cfg.Env; !slices.Equal(got, want) {
+		t.Errorf("got env %v, want %v", got, want)
+	}
+
+	if got := cf.Config.Cmd; got != nil {
+		t.Errorf("got cmd %v, want nil", got)
+	}
+
+	// The root filesystem and history are retained.
+	bcf, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cf.RootFS.DiffIDs, bcf.RootFS.DiffIDs; !slices.Equal(got, want) {
+		t.Errorf("got diffIDs %v, want %v", got, want)
+	}
+
+	if got, want := len(cf.History), len(bcf.History); got != want {
+		t.Errorf("got %v history entries, want %v", got, want)
+	}
+
+	// The manifest must reference the mutated config.
+	m, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := m.Config.Digest, h; got != want {
+		t.Errorf("got config digest %v, want %v", got, want)
+	}
+
+	bm, err := base.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Config.Digest == bm.Config.Digest {
+		t.Errorf("config digest unchanged")
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+var errInvalidGOARM = errors.New("invalid GOARM value")
+
+// platformFromGo returns the OCI platform corresponding to the supplied Go environment. The goarm
+// value is only considered when goarch is "arm".
+func platformFromGo(goos, goarch, goarm string) (v1.Platform, error) {
+	p := v1.Platform{
+		OS:           goos,
+		Architecture: goarch,
+	}
+
+	switch goarch {
+	case "arm":
+		// GOARM may carry a floating point mode suffix (e.g. "7,softfloat"), which has no
+		// equivalent in the OCI platform.
+		goarm, _, _ = strings.Cut(goarm, ",")
+
+		switch goarm {
+		case "":
+			// The Go toolchain defaults to GOARM=7 when cross-compiling.
+			p.Variant = "v7"
+		case "5", "6", "7":
+			p.Variant = "v" + goarm
+		default:
+			return v1.Platform{}, fmt.Errorf("%w: %q", errInvalidGOARM, goarm)
+		}
+
+	case "arm64":
+		p.Variant = "v8"
+	}
+
+	return p, nil
+}
+
+// SetPlatformFromGo sets the OS, architecture and variant in the image config to the OCI platform
+// corresponding to the supplied GOOS, GOARCH and GOARM values. The goarm value is only considered
+// when goarch is "arm", and may be empty, in which case the Go toolchain default is assumed.
+func SetPlatformFromGo(goos, goarch, goarm string) Mutation {
+	return func(img *image) error {
+		p, err := platformFromGo(goos, goarch, goarm)
+		if err != nil {
+			return err
+		}
+
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.OS = p.OS
+			cf.Architecture = p.Architecture
+			cf.Variant = p.Variant
+		})
+
+		return nil
+	}
+}
+
+// SetPlatform replaces the OS, OS version, architecture and variant in the image config with those
+// of p. Fields of p that are empty clear the corresponding config fields.
+func SetPlatform(p v1.Platform) Mutation {
+	return func(img *image) error {
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.OS = p.OS
+			cf.OSVersion = p.OSVersion
+			cf.Architecture = p.Architecture
+			cf.Variant = p.Variant
+		})
+		return nil
+	}
+}
+
+// normalizeVariant returns the variant for the architecture, substituting the default variant
+// where none is specified.
+func normalizeVariant(arch, variant string) string {
+	if variant != "" {
+		return variant
+	}
+
+	switch arch {
+	case "arm64":
+		return "v8"
+	case "arm":
+		return "v7"
+	case "amd64":
+		return "v1"
+	}
+	return variant
+}
+
+// variantCompatible returns true if an image built for variant can run on a host with the
+// specified hostVariant. For arm, a host supports its own variant and all earlier variants.
+func variantCompatible(arch, variant, hostVariant string) bool {
+	variant = normalizeVariant(arch, variant)
+	hostVariant = normalizeVariant(arch, hostVariant)
+
+	if arch == "arm" {
+		v, vErr := strconv.Atoi(strings.TrimPrefix(variant, "v"))
+		hv, hvErr := strconv.Atoi(strings.TrimPrefix(hostVariant, "v"))
+		if vErr == nil && hvErr == nil {
+			return v <= hv
+		}
+	}
+
+	return variant == hostVariant
+}
+
+// MatchesPlatform returns true if the platform declared in the config of img is compatible with a
+// host of platform p. The OS and architecture must match. If p specifies a variant, the image
+// variant must be compatible with it; for arm, a host supports its own variant and all earlier
+// variants (e.g. an arm/v7 host can run an arm/v6 image).
+func MatchesPlatform(img v1.Image, p v1.Platform) (bool, error) {
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return false, err
+	}
+
+	if cf.OS != p.OS || cf.Architecture != p.Architecture {
+		return false, nil
+	}
+
+	if p.Variant == "" {
+		return true, nil
+	}
+
+	return variantCompatible(p.Architecture, cf.Variant, p.Variant), nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"errors"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestSetPlatformFromGo(t *testing.T) {
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	tests := []struct {
+		name         string
+		goos         string
+		goarch       string
+		goarm        string
+		wantErr      error
+		wantPlatform v1.Platform
+	}{
+		{
+			name:         "LinuxAMD64",
+			goos:         "linux",
+			goarch:       "amd64",
+			wantPlatform: v1.Platform{OS: "linux", Architecture: "amd64"},
+		},
+		{
+			name:         "LinuxARM64",
+			goos:         "linux",
+			goarch:       "arm64",
+			wantPlatform: v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		},
+		{
+			name:         "LinuxARM",
+			goos:         "linux",
+			goarch:       "arm",
+			wantPlatform: v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+		},
+		{
+			name:         "LinuxARMv6",
+			goos:         "linux",
+			goarch:       "arm",
+			goarm:        "6",
+			wantPlatform: v1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"},
+		},
+		{
+			name:         "LinuxARMv7Softfloat",
+			goos:         "linux",
+			goarch:       "arm",
+			goarm:        "7,softfloat",
+			wantPlatform: v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+		},
+		{
+			name:    "LinuxARMInvalid",
+			goos:    "linux",
+			goarch:  "arm",
+			goarm:   "8",
+			wantErr: errInvalidGOARM,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(img, SetPlatformFromGo(tt.goos, tt.goarch, tt.goarm))
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err == nil {
+				cf, err := img.ConfigFile()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, want := cf.Platform(), tt.wantPlatform; !got.Equals(want) {
+					t.Errorf("got platform %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetPlatform(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	tests := []struct {
+		name     string
+		platform v1.Platform
+	}{
+		{
+			name:     "LinuxAMD64",
+			platform: v1.Platform{OS: "linux", Architecture: "amd64"},
+		},
+		{
+			name:     "LinuxARMv6",
+			platform: v1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"},
+		},
+		{
+			name:     "WindowsAMD64",
+			platform: v1.Platform{OS: "windows", OSVersion: "10.0.17763.1040", Architecture: "amd64"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(base, SetPlatform(tt.platform))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Image(img, validate.Fast); err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Platform(), tt.platform; !got.Equals(want) {
+				t.Errorf("got platform %+v, want %+v", got, want)
+			}
+
+			// The manifest must reference the mutated config.
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h, err := img.ConfigName()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := m.Config.Digest, h; got != want {
+				t.Errorf("got config digest %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMatchesPlatform(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	tests := []struct {
+		name      string
+		goos      string
+		goarch    string
+		goarm     string
+		host      v1.Platform
+		wantMatch bool
+	}{
+		{
+			name:      "LinuxAMD64",
+			goos:      "linux",
+			goarch:    "amd64",
+			host:      v1.Platform{OS: "linux", Architecture: "amd64"},
+			wantMatch: true,
+		},
+		{
+			name:   "WindowsAMD64",
+			goos:   "linux",
+			goarch: "amd64",
+			host:   v1.Platform{OS: "windows", Architecture: "amd64"},
+		},
+		{
+			name:   "ArchitectureMismatch",
+			goos:   "linux",
+			goarch: "amd64",
+			host:   v1.Platform{OS: "linux", Architecture: "arm64"},
+		},
+		{
+			name:      "ARM64DefaultVariant",
+			goos:      "linux",
+			goarch:    "arm64",
+			host:      v1.Platform{OS: "linux", Architecture: "arm64"},
+			wantMatch: true,
+		},
+		{
+			name:      "ARMSameVariant",
+			goos:      "linux",
+			goarch:    "arm",
+			goarm:     "7",
+			host:      v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+			wantMatch: true,
+		},
+		{
+			name:      "ARMEarlierVariant",
+			goos:      "linux",
+			goarch:    "arm",
+			goarm:     "6",
+			host:      v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+			wantMatch: true,
+		},
+		{
+			name:   "ARMLaterVariant",
+			goos:   "linux",
+			goarch: "arm",
+			goarm:  "7",
+			host:   v1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Apply(base, SetPlatformFromGo(tt.goos, tt.goarch, tt.goarm))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ok, err := MatchesPlatform(img, tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := ok, tt.wantMatch; got != want {
+				t.Errorf("got match %v, want %v", got, want)
+			}
+		})
+	}
+}
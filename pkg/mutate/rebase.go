@@ -0,0 +1,73 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+var errBaseMismatch = errors.New("image is not based on specified base")
+
+// rebaseHistory returns history with the entries describing the first n layers replaced by m
+// empty entries, one for each replacement layer. If history is empty, or does not describe at
+// least n layers, it is returned unchanged.
+func rebaseHistory(history []v1.History, n, m int) []v1.History {
+	if len(history) == 0 {
+		return history
+	}
+
+	// Locate the position following the entry for layer n-1.
+	i, seen := 0, 0
+	for ; i < len(history) && seen < n; i++ {
+		if !history[i].EmptyLayer {
+			seen++
+		}
+	}
+
+	if seen < n {
+		return history
+	}
+
+	return append(make([]v1.History, m), history[i:]...)
+}
+
+// RebaseByDigests returns an image based on orig, with the layers of the old base image replaced
+// by newBaseLayers. The old base is identified by oldBaseDiffIDs, which must be a prefix of the
+// diffIDs of orig, so the old base image itself is not required. The layers of orig that follow
+// the old base, and the config of orig, are retained.
+//
+// Where orig has history, the entries describing the old base layers are replaced by an empty
+// entry for each new base layer.
+func RebaseByDigests(orig v1.Image, oldBaseDiffIDs []v1.Hash, newBaseLayers []v1.Layer) (v1.Image, error) {
+	cf, err := orig.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(oldBaseDiffIDs)
+
+	if n > len(cf.RootFS.DiffIDs) || !slices.Equal(cf.RootFS.DiffIDs[:n], oldBaseDiffIDs) {
+		return nil, fmt.Errorf("%w: diffIDs %v", errBaseMismatch, oldBaseDiffIDs)
+	}
+
+	ls, err := orig.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := append(slices.Clone(newBaseLayers), ls[n:]...)
+
+	return Apply(orig, func(img *image) error {
+		img.overrides = layers
+		img.configFileMutations = append(img.configFileMutations, func(cf *v1.ConfigFile) {
+			cf.History = rebaseHistory(cf.History, n, len(newBaseLayers))
+		})
+		return nil
+	})
+}
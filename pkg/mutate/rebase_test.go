@@ -0,0 +1,99 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	ggcrmutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestRebaseByDigests(t *testing.T) {
+	oldBase := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	orig, err := Apply(oldBase,
+		AppendLayerWithHistory(static.NewLayer([]byte("foo"), types.DockerLayer), v1.History{CreatedBy: "foo"}),
+		AppendLayerWithHistory(static.NewLayer([]byte("bar"), types.DockerLayer), v1.History{CreatedBy: "bar"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newBaseLayers := []v1.Layer{
+		static.NewLayer([]byte("new base 0"), types.DockerLayer),
+		static.NewLayer([]byte("new base 1"), types.DockerLayer),
+	}
+
+	newBase, err := ggcrmutate.AppendLayers(empty.Image, newBaseLayers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldCF, err := oldBase.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := RebaseByDigests(orig, oldCF.RootFS.DiffIDs, newBaseLayers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img, validate.Fast); err != nil {
+		t.Fatal(err)
+	}
+
+	// The result is consistent with a rebase using the full base images.
+	want, err := ggcrmutate.Rebase(orig, oldBase, newBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := layerDigests(t, img), layerDigests(t, want); !slices.Equal(got, want) {
+		t.Errorf("got layers %v, want %v", got, want)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCF, err := want.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cf.RootFS.DiffIDs, wantCF.RootFS.DiffIDs; !slices.Equal(got, want) {
+		t.Errorf("got diffIDs %v, want %v", got, want)
+	}
+
+	if got, want := cf.Config, wantCF.Config; !reflect.DeepEqual(got, want) {
+		t.Errorf("got config %+v, want %+v", got, want)
+	}
+
+	createdBy := make([]string, 0, len(cf.History))
+	for _, h := range cf.History {
+		createdBy = append(createdBy, h.CreatedBy)
+	}
+
+	wantCreatedBy := []string{"", "", "/bin/sh -c #(nop)  CMD [\"/hello\"]", "foo", "bar"}
+
+	if got, want := createdBy, wantCreatedBy; !slices.Equal(got, want) {
+		t.Errorf("got created by %q, want %q", got, want)
+	}
+
+	// A base that is not a prefix of the image is rejected.
+	if _, err := RebaseByDigests(orig, cf.RootFS.DiffIDs[:1], newBaseLayers); !errors.Is(err, errBaseMismatch) {
+		t.Errorf("got error %v, want %v", err, errBaseMismatch)
+	}
+}
@@ -272,8 +272,68 @@ func squash(img v1.Image, w io.Writer) error {
 	return nil
 }
 
-// Squash replaces the layers in the base image with a single, squashed layer.
-func Squash(base v1.Image) (v1.Image, error) {
+// squashOpts accumulates squash options.
+type squashOpts struct {
+	preserveHistory bool
+}
+
+// SquashOpt are used to specify squash options.
+type SquashOpt func(*squashOpts) error
+
+// OptSquashPreserveHistory specifies that the history of the base image should be summarized in
+// the history entry of the squashed image. The CreatedBy values of the base image history are
+// recorded, in order, in the Comment of the resulting entry.
+func OptSquashPreserveHistory(b bool) SquashOpt {
+	return func(so *squashOpts) error {
+		so.preserveHistory = b
+		return nil
+	}
+}
+
+// squashedHistory returns a history entry for the squashed form of img, with the time of the most
+// recent entry in the history of img. If summarize is set, the CreatedBy values of the history of
+// img are recorded, in order, in the Comment of the entry.
+func squashedHistory(img v1.Image, summarize bool) (v1.History, error) {
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return v1.History{}, err
+	}
+
+	var h v1.History
+
+	lines := make([]string, 0, len(cf.History))
+	for _, e := range cf.History {
+		if e.CreatedBy != "" {
+			lines = append(lines, e.CreatedBy)
+		}
+
+		if e.Created.After(h.Created.Time) {
+			h.Created = e.Created
+		}
+	}
+
+	if summarize {
+		h.Comment = strings.Join(lines, "\n")
+	}
+
+	return h, nil
+}
+
+// Squash replaces the layers in the base image with a single, squashed layer. Layers are applied in
+// order, so content removed by a whiteout in a later layer is not present in the squashed layer.
+//
+// The history of the base image is replaced by a single entry corresponding to the squashed
+// layer. To record a summary of the history of the base image in this entry, consider using
+// OptSquashPreserveHistory.
+func Squash(base v1.Image, opts ...SquashOpt) (v1.Image, error) {
+	so := squashOpts{}
+
+	for _, opt := range opts {
+		if err := opt(&so); err != nil {
+			return nil, err
+		}
+	}
+
 	opener := func() (io.ReadCloser, error) {
 		pr, pw := io.Pipe()
 
@@ -289,5 +349,10 @@ func Squash(base v1.Image) (v1.Image, error) {
 		return nil, err
 	}
 
-	return Apply(base, ReplaceLayers(l))
+	h, err := squashedHistory(base, so.preserveHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	return Apply(base, ReplaceLayers(l), SetHistory(h))
 }
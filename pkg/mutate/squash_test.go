@@ -6,9 +6,13 @@ package mutate
 
 import (
 	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
 	"github.com/sebdah/goldie/v2"
 )
 
@@ -87,3 +91,96 @@ func TestSquash(t *testing.T) {
 		})
 	}
 }
+
+func TestSquashWhiteout(t *testing.T) {
+	// File "a/b/foo" is added in the first layer, and removed by a whiteout in the second.
+	base := corpus.Image(t, "whiteout-explicit-file")
+
+	img, err := Squash(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img); err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(ls), 1; got != want {
+		t.Fatalf("got %v layers, want %v", got, want)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(cf.RootFS.DiffIDs), 1; got != want {
+		t.Errorf("got %v diff IDs, want %v", got, want)
+	}
+
+	if got, want := len(cf.History), 1; got != want {
+		t.Errorf("got %v history entries, want %v", got, want)
+	}
+
+	tes, err := readTAR(ls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(tes))
+	for _, te := range tes {
+		names = append(names, filepath.Clean(te.name))
+	}
+
+	if slices.Contains(names, "a/b/foo") {
+		t.Errorf("got names %v, want no a/b/foo", names)
+	}
+
+	if !slices.Contains(names, "a/b/bar") {
+		t.Errorf("got names %v, want a/b/bar", names)
+	}
+
+	for _, name := range names {
+		if strings.HasPrefix(filepath.Base(name), ".wh.") {
+			t.Errorf("got whiteout %v", name)
+		}
+	}
+}
+
+func TestSquashPreserveHistory(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	img, err := Squash(base, OptSquashPreserveHistory(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(img); err != nil {
+		t.Fatal(err)
+	}
+
+	bcf, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(cf.History), 1; got != want {
+		t.Fatalf("got %v history entries, want %v", got, want)
+	}
+
+	for _, h := range bcf.History {
+		if !strings.Contains(cf.History[0].Comment, h.CreatedBy) {
+			t.Errorf("history comment %q does not mention %q", cf.History[0].Comment, h.CreatedBy)
+		}
+	}
+}
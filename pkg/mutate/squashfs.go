@@ -44,7 +44,10 @@ func OptSquashfsLayerConverter(converter string) SquashfsConverterOpt {
 	}
 }
 
-var errSquashfsConverterNotSupported = errors.New("squashfs converter not supported")
+var (
+	errSquashfsConverterNotSupported = errors.New("squashfs converter not supported")
+	errSquashfsConverterNotFound     = errors.New("squashfs converter not found")
+)
 
 // OptSquashfsSkipWhiteoutConversion is set to skip the default conversion of whiteout /
 // opaque markers from AUFS to OverlayFS format.
@@ -85,7 +88,7 @@ func SquashfsLayer(base v1.Layer, dir string, opts ...SquashfsConverterOpt) (v1.
 		path, err := exec.LookPath("tar2sqfs")
 		if err != nil {
 			if path, err = exec.LookPath("sqfstar"); err != nil {
-				return nil, err
+				return nil, fmt.Errorf("%w (tried tar2sqfs, sqfstar): %w", errSquashfsConverterNotFound, err)
 			}
 		}
 
@@ -300,3 +303,54 @@ func (l *squashfsLayer) Size() (int64, error) {
 func (l *squashfsLayer) MediaType() (types.MediaType, error) {
 	return layerMediaType, nil
 }
+
+// ExportSquashfs writes the filesystem of img to a squashfs file at destPath, suitable for use as
+// a SIF partition. The layers of img are first squashed, so whiteouts are applied, and the result
+// is converted using an external converter program, as described for SquashfsLayer. To specify a
+// converter program, consider using OptSquashfsLayerConverter.
+//
+// If no converter program is specified, and neither 'tar2sqfs' nor 'sqfstar' can be located, an
+// error is returned.
+func ExportSquashfs(img v1.Image, destPath string, opts ...SquashfsConverterOpt) error {
+	squashed, err := Squash(img)
+	if err != nil {
+		return err
+	}
+
+	ls, err := squashed.Layers()
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "oci-tools-squashfs-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// The squashed layer contains no whiteouts, so conversion is not required.
+	opts = append([]SquashfsConverterOpt{OptSquashfsSkipWhiteoutConversion(true)}, opts...)
+
+	l, err := SquashfsLayer(ls[0], dir, opts...)
+	if err != nil {
+		return err
+	}
+
+	rc, err := l.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, rc); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
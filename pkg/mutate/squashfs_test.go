@@ -5,14 +5,18 @@
 package mutate
 
 import (
+	"archive/tar"
+	"bytes"
 	"errors"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/sebdah/goldie/v2"
 )
 
@@ -223,3 +227,90 @@ func Test_SquashfsLayer(t *testing.T) {
 		})
 	}
 }
+
+// squashfsEntries returns the regular files in the squashfs image at path, and their contents.
+func squashfsEntries(tb testing.TB, path string) map[string]string {
+	tb.Helper()
+
+	out, err := exec.Command("sqfs2tar", path).Output()
+	if err != nil {
+		tb.Fatalf("sqfs2tar: %v", err)
+	}
+
+	l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(out)), nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return regularFiles(tb, l)
+}
+
+// regularFiles returns the regular files in the TAR stream of l, and their contents.
+func regularFiles(tb testing.TB, l v1.Layer) map[string]string {
+	tb.Helper()
+
+	tes, err := readTAR(l)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	for _, te := range tes {
+		if te.typeflag == tar.TypeReg {
+			files[filepath.Clean(te.name)] = te.content
+		}
+	}
+	return files
+}
+
+func TestExportSquashfs(t *testing.T) {
+	for _, converter := range []string{"sqfstar", "tar2sqfs"} {
+		t.Run(converter, func(t *testing.T) {
+			for _, prog := range []string{converter, "sqfs2tar"} {
+				if _, err := exec.LookPath(prog); errors.Is(err, exec.ErrNotFound) {
+					t.Skip(err)
+				}
+			}
+
+			// Image with opaque whiteout of directory "a/", implying contents "a/bar" only.
+			img := corpus.Image(t, "whiteout-opaque-end")
+
+			path := filepath.Join(t.TempDir(), "image.sqfs")
+
+			if err := ExportSquashfs(img, path, OptSquashfsLayerConverter(converter)); err != nil {
+				t.Fatal(err)
+			}
+
+			squashed, err := Squash(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ls, err := squashed.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := squashfsEntries(t, path), regularFiles(t, ls[0]); !maps.Equal(got, want) {
+				t.Errorf("got files %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestExportSquashfsNoConverter(t *testing.T) {
+	t.Setenv("PATH", "")
+
+	path := filepath.Join(t.TempDir(), "image.sqfs")
+
+	err := ExportSquashfs(corpus.Image(t, "hello-world-docker-v2-manifest"), path)
+	if got, want := err, errSquashfsConverterNotFound; !errors.Is(got, want) {
+		t.Fatalf("got error %v, want %v", got, want)
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
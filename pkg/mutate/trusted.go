@@ -0,0 +1,156 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+var (
+	errLayerConsumed  = errors.New("trusted layer content already consumed")
+	errDigestMismatch = errors.New("digest mismatch")
+	errSizeMismatch   = errors.New("size mismatch")
+)
+
+// trustedLayerOpts accumulates trusted layer options.
+type trustedLayerOpts struct {
+	verify bool
+}
+
+// TrustedLayerOpt are used to specify trusted layer options.
+type TrustedLayerOpt func(*trustedLayerOpts) error
+
+// OptTrustedLayerVerify specifies whether the compressed content of the layer is verified against
+// the supplied digest and size as it is read. If verification fails, reading the content returns
+// an error once the end of the content is reached.
+func OptTrustedLayerVerify(b bool) TrustedLayerOpt {
+	return func(to *trustedLayerOpts) error {
+		to.verify = b
+		return nil
+	}
+}
+
+// trustedLayer is a compressed layer with known digest, diffID and size.
+type trustedLayer struct {
+	mu         sync.Mutex
+	compressed io.ReadCloser
+	diffID     v1.Hash
+	digest     v1.Hash
+	size       int64
+	mediaType  types.MediaType
+	verify     bool
+}
+
+// Digest returns the supplied digest.
+func (l *trustedLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+// DiffID returns the supplied diffID.
+func (l *trustedLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+// Size returns the supplied size.
+func (l *trustedLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+// MediaType returns the supplied media type.
+func (l *trustedLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// Compressed returns the supplied content. The content can only be read once, so subsequent calls
+// return an error.
+func (l *trustedLayer) Compressed() (io.ReadCloser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rc := l.compressed
+	if rc == nil {
+		return nil, errLayerConsumed
+	}
+	l.compressed = nil
+
+	if !l.verify {
+		return rc, nil
+	}
+
+	h, err := v1.Hasher(l.digest.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifyingReadCloser{rc: rc, h: h, want: l.digest, size: l.size}, nil
+}
+
+// verifyingReadCloser verifies the digest and size of the content read from rc.
+type verifyingReadCloser struct {
+	rc   io.ReadCloser
+	h    hash.Hash
+	want v1.Hash
+	size int64
+	n    int64
+}
+
+func (vr *verifyingReadCloser) Read(p []byte) (int, error) {
+	n, err := vr.rc.Read(p)
+	vr.h.Write(p[:n])
+	vr.n += int64(n)
+
+	if errors.Is(err, io.EOF) {
+		if vr.n != vr.size {
+			return n, fmt.Errorf("%w: got %v, want %v", errSizeMismatch, vr.n, vr.size)
+		}
+
+		if got := hex.EncodeToString(vr.h.Sum(nil)); got != vr.want.Hex {
+			return n, fmt.Errorf("%w: got %v:%v, want %v", errDigestMismatch, vr.want.Algorithm, got, vr.want)
+		}
+	}
+
+	return n, err
+}
+
+func (vr *verifyingReadCloser) Close() error {
+	return vr.rc.Close()
+}
+
+// TrustedLayer returns a layer with the supplied compressed content, diffID, digest, size and
+// media type. The supplied values are trusted, so constructing a manifest that references the
+// layer does not require the content to be read. The content is read at most once, when the
+// layer is written.
+//
+// By default, the content is not verified. To verify the digest and size of the content as it is
+// read, consider using OptTrustedLayerVerify.
+func TrustedLayer(
+	compressed io.ReadCloser, diffID, digest v1.Hash, size int64, mt types.MediaType, opts ...TrustedLayerOpt,
+) (v1.Layer, error) {
+	to := trustedLayerOpts{}
+
+	for _, opt := range opts {
+		if err := opt(&to); err != nil {
+			return nil, err
+		}
+	}
+
+	return partial.CompressedToLayer(&trustedLayer{
+		compressed: compressed,
+		diffID:     diffID,
+		digest:     digest,
+		size:       size,
+		mediaType:  mt,
+		verify:     to.verify,
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// readRecorder wraps a reader, recording whether it has been read.
+type readRecorder struct {
+	io.Reader
+	read bool
+}
+
+func (r *readRecorder) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
+
+func (r *readRecorder) Close() error { return nil }
+
+func TestTrustedLayer(t *testing.T) {
+	src := static.NewLayer([]byte("not really a layer"), types.DockerLayer)
+
+	rc, err := src.Compressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := src.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffID := v1.Hash{Algorithm: "sha256", Hex: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
+
+	tests := []struct {
+		name    string
+		digest  v1.Hash
+		opts    []TrustedLayerOpt
+		wantErr error
+	}{
+		{
+			name:   "Trusted",
+			digest: digest,
+		},
+		{
+			name:   "TrustedMismatch",
+			digest: diffID,
+		},
+		{
+			name:   "Verify",
+			digest: digest,
+			opts:   []TrustedLayerOpt{OptTrustedLayerVerify(true)},
+		},
+		{
+			name:    "VerifyMismatch",
+			digest:  diffID,
+			opts:    []TrustedLayerOpt{OptTrustedLayerVerify(true)},
+			wantErr: errDigestMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := &readRecorder{Reader: bytes.NewReader(b)}
+
+			l, err := TrustedLayer(rr, diffID, tt.digest, int64(len(b)), types.DockerLayer, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"), AppendLayer(l))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := m.Layers[len(m.Layers)-1].Digest, tt.digest; got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.RootFS.DiffIDs[len(cf.RootFS.DiffIDs)-1], diffID; got != want {
+				t.Errorf("got diffID %v, want %v", got, want)
+			}
+
+			if rr.read {
+				t.Error("layer content read during manifest construction")
+			}
+
+			rc, err := l.Compressed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+
+			if _, err := io.ReadAll(rc); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if _, err := l.Compressed(); !errors.Is(err, errLayerConsumed) {
+				t.Errorf("got error %v, want %v", err, errLayerConsumed)
+			}
+		})
+	}
+}
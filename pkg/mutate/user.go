@@ -0,0 +1,74 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+var errUnknownUser = errors.New("unknown user")
+
+// isNumericID returns true if s is a numeric user or group ID.
+func isNumericID(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+// normalizeUser returns the numeric form of user, which may be of the form "user" or
+// "user:group". Only the user portion is resolved; the group portion is retained as-is.
+func normalizeUser(user string, resolve func(string) (int, bool)) (string, error) {
+	name, group, hasGroup := strings.Cut(user, ":")
+
+	if name == "" || isNumericID(name) {
+		return user, nil
+	}
+
+	uid, ok := resolve(name)
+	if !ok {
+		return "", fmt.Errorf("%w: %q", errUnknownUser, name)
+	}
+
+	user = strconv.Itoa(uid)
+	if hasGroup {
+		user += ":" + group
+	}
+
+	return user, nil
+}
+
+// NormalizeUser converts a name-based user in the image config to numeric form. The user in the
+// config may be of the form "name", "uid", "uid:gid" or "name:group". The user name is converted
+// to a UID by calling resolve, which would typically consult the passwd file of the image. If
+// resolve reports that the name is unknown, an error is returned.
+//
+// The user is read from the config of the base image when the mutation is applied. Only the user
+// portion is converted; a group, if present, is retained unchanged. If the user is unset or
+// already numeric, the config is not modified.
+func NormalizeUser(resolve func(name string) (uid int, ok bool)) Mutation {
+	return func(img *image) error {
+		cf, err := img.base.ConfigFile()
+		if err != nil {
+			return err
+		}
+
+		user, err := normalizeUser(cf.Config.User, resolve)
+		if err != nil {
+			return err
+		}
+
+		if user == cf.Config.User {
+			return nil
+		}
+
+		return Config(func(c *v1.Config) {
+			c.User = user
+		})(img)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mutate
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+)
+
+func TestNormalizeUser(t *testing.T) {
+	passwd := map[string]int{
+		"root":   0,
+		"nobody": 65534,
+	}
+
+	resolve := func(name string) (int, bool) {
+		uid, ok := passwd[name]
+		return uid, ok
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		wantUser string
+		wantErr  bool
+	}{
+		{name: "Unset", user: "", wantUser: ""},
+		{name: "Name", user: "nobody", wantUser: "65534"},
+		{name: "NameGroup", user: "nobody:nogroup", wantUser: "65534:nogroup"},
+		{name: "NameGID", user: "root:0", wantUser: "0:0"},
+		{name: "UID", user: "1000", wantUser: "1000"},
+		{name: "UIDGID", user: "1000:1000", wantUser: "1000:1000"},
+		{name: "Unknown", user: "someone", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := Apply(corpus.Image(t, "hello-world-docker-v2-manifest"), Config(func(c *v1.Config) {
+				c.User = tt.user
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := Apply(base, NormalizeUser(resolve))
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err := validate.Image(img); err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := cf.Config.User, tt.wantUser; got != want {
+				t.Errorf("got user %q, want %q", got, want)
+			}
+		})
+	}
+}
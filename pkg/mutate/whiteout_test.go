@@ -5,10 +5,16 @@
 package mutate
 
 import (
+	"archive/tar"
+	"bytes"
+	"io"
 	"maps"
+	"slices"
 	"testing"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
 func Test_scanAUFSOpaque(t *testing.T) {
@@ -66,3 +72,86 @@ func Test_scanAUFSOpaque(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeWhiteouts(t *testing.T) {
+	var b bytes.Buffer
+
+	tw := tar.NewWriter(&b)
+
+	hdrs := []*tar.Header{
+		{
+			Typeflag:   tar.TypeDir,
+			Name:       "dir/",
+			Mode:       0o755,
+			PAXRecords: map[string]string{"SCHILY.xattr.trusted.overlay.opaque": "y"},
+		},
+		{Typeflag: tar.TypeReg, Name: "dir/file", Mode: 0o644},
+		{Typeflag: tar.TypeChar, Name: "file", Mode: 0o644},
+		{Typeflag: tar.TypeChar, Name: "sub/file", Mode: 0o644},
+		{Typeflag: tar.TypeReg, Name: "sub/.wh.other", Mode: 0o644},
+		{Typeflag: tar.TypeChar, Name: "null", Mode: 0o666, Devmajor: 1, Devminor: 3},
+	}
+
+	for _, hdr := range hdrs {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b.Bytes())), nil
+	}, tarball.WithMediaType(types.OCILayer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NormalizeWhiteouts(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mt, err := l.MediaType(); err != nil {
+		t.Fatal(err)
+	} else if got, want := mt, types.OCILayer; got != want {
+		t.Errorf("got media type %v, want %v", got, want)
+	}
+
+	tes, err := readTAR(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []tarEntry{
+		{typeflag: tar.TypeDir, name: "dir/"},
+		{typeflag: tar.TypeReg, name: "dir/.wh..wh..opq"},
+		{typeflag: tar.TypeReg, name: "dir/file"},
+		{typeflag: tar.TypeReg, name: ".wh.file"},
+		{typeflag: tar.TypeReg, name: "sub/.wh.file"},
+		{typeflag: tar.TypeReg, name: "sub/.wh.other"},
+		{typeflag: tar.TypeChar, name: "null"},
+	}
+
+	if got := tes; !slices.Equal(got, want) {
+		t.Errorf("got entries %+v, want %+v", got, want)
+	}
+
+	// The OverlayFS xattr should be removed from the opaque directory.
+	rc, err := l.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	hdr, err := tar.NewReader(rc).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := hdr.PAXRecords["SCHILY.xattr.trusted.overlay.opaque"]; ok {
+		t.Errorf("got opaque xattr on %v", hdr.Name)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// OpenBlobRange returns a ReadCloser that reads up to length bytes of the blob in fi with digest
+// h, starting at offset. Consistent with HTTP range semantics, a range that extends beyond the end
+// of the blob is truncated, and an error is returned if offset does not fall within the blob. If
+// fi does not contain the blob, an error wrapping ErrBlobNotFound is returned.
+func OpenBlobRange(fi *sif.FileImage, h v1.Hash, offset, length int64) (io.ReadCloser, error) {
+	d, err := blobDescriptor(fi, h)
+	if err != nil {
+		return nil, err
+	}
+
+	if size := d.Size(); offset < 0 || offset >= size || length < 0 {
+		return nil, fmt.Errorf("%w: offset %v, length %v, size %v",
+			errRangeNotSatisfiable, offset, length, size,
+		)
+	}
+
+	r := d.GetReader()
+
+	if s, ok := r.(io.Seeker); ok {
+		if _, err := s.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(io.LimitReader(r, length)), nil
+}
+
+// ErrBlobNotFound is returned when a blob with the requested digest is not present in a SIF.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Blob returns a ReadCloser that reads the blob in fi with digest h. The blob is read directly
+// from fi, so it is not held in memory. If fi does not contain the blob, an error wrapping
+// ErrBlobNotFound is returned.
+func Blob(fi *sif.FileImage, h v1.Hash) (io.ReadCloser, error) {
+	d, err := blobDescriptor(fi, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(d.GetReader()), nil
+}
+
+// BlobSize returns the size of the blob in fi with digest h. The size is read from the descriptor
+// of the blob, so the blob itself is not opened.
+func BlobSize(fi *sif.FileImage, h v1.Hash) (int64, error) {
+	d, err := blobDescriptor(fi, h)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.Size(), nil
+}
+
+// blobDescriptor returns the descriptor of the blob in fi with digest h.
+func blobDescriptor(fi *sif.FileImage, h v1.Hash) (sif.Descriptor, error) {
+	d, err := fi.GetDescriptor(sif.WithOCIBlobDigest(h))
+	if errors.Is(err, sif.ErrNoObjects) || errors.Is(err, sif.ErrObjectNotFound) {
+		return sif.Descriptor{}, fmt.Errorf("%w: %v", ErrBlobNotFound, h)
+	}
+	return d, err
+}
+
+// ExportManifest writes the blob in fi with digest h to w. This is intended to allow a stored
+// manifest, config or index to be inspected, but the content of the blob is not interpreted.
+func ExportManifest(fi *sif.FileImage, h v1.Hash, w io.Writer) error {
+	d, err := blobDescriptor(fi, h)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, d.GetReader())
+	return err
+}
+
+// ManifestBytes returns the blob in fi with digest h, verbatim. This is intended to allow the
+// exact bytes of a stored manifest or index to be obtained (e.g. to sign them), but the content
+// of the blob is not interpreted. To write the blob to an io.Writer, consider using
+// ExportManifest.
+func ManifestBytes(fi *sif.FileImage, h v1.Hash) ([]byte, error) {
+	d, err := blobDescriptor(fi, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetData()
+}
+
+// Blobs returns the digests of the OCI blobs in fi for which filter returns true. The descriptor
+// passed to filter always specifies the digest and size of the blob. Where the blob is referenced
+// from the RootIndex, the descriptor is that of the reference, so the media type (and any
+// annotations) are also available. Blobs that are not referenced carry no media type.
+//
+// Digests are returned in the order the blobs are stored in fi.
+func Blobs(fi *sif.FileImage, filter func(v1.Descriptor) bool) ([]v1.Hash, error) {
+	refs := make(map[v1.Hash]v1.Descriptor)
+
+	if ii, err := ImageIndexFromFileImage(fi); err == nil {
+		if err := referencedBlobs(ii, refs); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, sif.ErrNoObjects) && !errors.Is(err, sif.ErrObjectNotFound) {
+		return nil, err
+	}
+
+	ds, err := fi.GetDescriptors(sif.WithDataType(sif.DataOCIBlob))
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return nil, err
+	}
+
+	var hs []v1.Hash
+
+	for _, d := range ds {
+		h, err := d.OCIBlobDigest()
+		if err != nil {
+			return nil, err
+		}
+
+		desc, ok := refs[h]
+		if !ok {
+			desc = v1.Descriptor{Digest: h}
+		}
+		desc.Size = d.Size()
+
+		if filter(desc) {
+			hs = append(hs, h)
+		}
+	}
+
+	return hs, nil
+}
@@ -0,0 +1,385 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+func TestOpenBlobRange(t *testing.T) {
+	fi := fileImageFromPath(t, "hello-world-docker-v2-manifest")
+
+	// The manifest of the image.
+	h := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "432f982638b3aefab73cc58ab28f5c16e96fdb504e8c134fc58dff4bae8bf338",
+	}
+
+	d, err := fi.GetDescriptor(ssif.WithOCIBlobDigest(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := d.GetData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len(blob))
+
+	tests := []struct {
+		name    string
+		offset  int64
+		length  int64
+		wantErr bool
+		want    []byte
+	}{
+		{
+			name:   "Whole",
+			offset: 0,
+			length: size,
+			want:   blob,
+		},
+		{
+			name:   "Middle",
+			offset: 100,
+			length: 200,
+			want:   blob[100:300],
+		},
+		{
+			name:   "Clamped",
+			offset: size - 10,
+			length: 100,
+			want:   blob[size-10:],
+		},
+		{
+			name:   "Empty",
+			offset: 10,
+			length: 0,
+			want:   []byte{},
+		},
+		{
+			name:    "OffsetBeyondEnd",
+			offset:  size,
+			length:  1,
+			wantErr: true,
+		},
+		{
+			name:    "NegativeOffset",
+			offset:  -1,
+			length:  1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := sif.OpenBlobRange(fi, h, tt.offset, tt.length)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err != nil {
+				return
+			}
+			defer func() { _ = rc.Close() }()
+
+			b, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := b, tt.want; !bytes.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestOpenBlobRangeNotFound(t *testing.T) {
+	fi := fileImageFromPath(t, "hello-world-docker-v2-manifest")
+
+	h := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+	}
+
+	if _, err := sif.OpenBlobRange(fi, h, 0, 1); !errors.Is(err, sif.ErrBlobNotFound) {
+		t.Fatalf("got error %v, want %v", err, sif.ErrBlobNotFound)
+	}
+}
+
+func TestBlobSize(t *testing.T) {
+	fi := fileImageFromPath(t, "hello-world-docker-v2-manifest")
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := ii.Image(im.Manifests[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		h        v1.Hash
+		wantSize int64
+		wantErr  bool
+	}{
+		{
+			name:     "Manifest",
+			h:        im.Manifests[0].Digest,
+			wantSize: im.Manifests[0].Size,
+		},
+		{
+			name:     "Config",
+			h:        m.Config.Digest,
+			wantSize: m.Config.Size,
+		},
+		{
+			name:     "Layer",
+			h:        m.Layers[0].Digest,
+			wantSize: m.Layers[0].Size,
+		},
+		{
+			name: "NotFound",
+			h: v1.Hash{
+				Algorithm: "sha256",
+				Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := sif.BlobSize(fi, tt.h)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if got, want := size, tt.wantSize; got != want {
+				t.Errorf("got size %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBlob(t *testing.T) {
+	fi := fileImageFromPath(t, "hello-world-docker-v2-manifest")
+
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := ls[0].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		h       v1.Hash
+		wantErr error
+	}{
+		{
+			name: "Config",
+			h:    config,
+		},
+		{
+			name: "Layer",
+			h:    layer,
+		},
+		{
+			name: "NotFound",
+			h: v1.Hash{
+				Algorithm: "sha256",
+				Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+			},
+			wantErr: sif.ErrBlobNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := sif.Blob(fi, tt.h)
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			if err != nil {
+				return
+			}
+			defer func() { _ = rc.Close() }()
+
+			got, _, err := v1.SHA256(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := tt.h; got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestExportManifest(t *testing.T) {
+	fi := fileImageFromPath(t, "hello-world-docker-v2-manifest")
+
+	// The manifest of the image.
+	h := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "432f982638b3aefab73cc58ab28f5c16e96fdb504e8c134fc58dff4bae8bf338",
+	}
+
+	var b bytes.Buffer
+
+	if err := sif.ExportManifest(fi, h, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !json.Valid(b.Bytes()) {
+		t.Errorf("got invalid JSON %q", b.Bytes())
+	}
+
+	if got, _, err := v1.SHA256(&b); err != nil {
+		t.Fatal(err)
+	} else if got != h {
+		t.Errorf("got digest %v, want %v", got, h)
+	}
+
+	missing := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+	}
+
+	if err := sif.ExportManifest(fi, missing, io.Discard); err == nil {
+		t.Error("got nil error exporting missing blob")
+	}
+}
+
+func TestManifestBytes(t *testing.T) {
+	fi := fileImageFromPath(t, "hello-world-docker-v2-manifest-list")
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A manifest referenced by the RootIndex.
+	h := im.Manifests[0].Digest
+
+	b, err := sif.ManifestBytes(fi, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _, err := v1.SHA256(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	} else if got != h {
+		t.Errorf("got digest %v, want %v", got, h)
+	}
+
+	if got, want := int64(len(b)), im.Manifests[0].Size; got != want {
+		t.Errorf("got size %v, want %v", got, want)
+	}
+
+	missing := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+	}
+
+	if _, err := sif.ManifestBytes(fi, missing); err == nil {
+		t.Error("got nil error for missing blob")
+	}
+}
+
+func TestBlobs(t *testing.T) {
+	layer := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "7050e35b49f5e348c4809f5eff915842962cb813f32062d3bbdd35c750dd7d01",
+	}
+	config := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "46331d942d6350436f64e614d75725f6de3bb5c63e266e236e04389820a234c4",
+	}
+	manifest := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "432f982638b3aefab73cc58ab28f5c16e96fdb504e8c134fc58dff4bae8bf338",
+	}
+
+	tests := []struct {
+		name   string
+		filter func(v1.Descriptor) bool
+		want   []v1.Hash
+	}{
+		{
+			name:   "All",
+			filter: func(v1.Descriptor) bool { return true },
+			want:   []v1.Hash{layer, config, manifest},
+		},
+		{
+			name:   "SizeThreshold",
+			filter: func(d v1.Descriptor) bool { return d.Size > 1024 },
+			want:   []v1.Hash{layer, config},
+		},
+		{
+			name:   "MediaType",
+			filter: func(d v1.Descriptor) bool { return d.MediaType == types.DockerLayer },
+			want:   []v1.Hash{layer},
+		},
+		{
+			name:   "None",
+			filter: func(v1.Descriptor) bool { return false },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := fileImageFromPath(t, "hello-world-docker-v2-manifest")
+
+			got, err := sif.Blobs(fi, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got blobs %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// Clone copies the SIF at srcPath to a new file at dstPath, which must not already exist. The
+// clone is independent of the original, so either may be modified without affecting the other.
+//
+// Where supported by the platform, the copy is performed within the kernel (e.g. via
+// copy_file_range on Linux), which allows filesystems that support copy-on-write to share storage
+// between the files until they are modified. Otherwise, the content is copied via a stream.
+//
+// Once copied, the clone is checked to ensure it can be loaded as a SIF. If the copy or check
+// fails, the partially written clone is removed.
+func Clone(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if err := cloneFile(dst, src); err != nil {
+		return errors.Join(err, os.Remove(dstPath))
+	}
+
+	return nil
+}
+
+// cloneFile copies the content of src to dst, closes dst, and checks that it can be loaded as a
+// SIF.
+func cloneFile(dst, src *os.File) error {
+	// When both arguments are files, io.Copy uses the most efficient mechanism available to
+	// (*os.File).ReadFrom.
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	fi, err := sif.LoadContainerFromPath(dst.Name(), sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		return err
+	}
+
+	return fi.UnloadContainer()
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+func TestClone(t *testing.T) {
+	src := corpus.SIF(t, "many-layers", sif.OptWriteWithSpareDescriptorCapacity(32))
+
+	before, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "clone.sif")
+
+	if err := sif.Clone(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := os.ReadFile(dst); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, before) {
+		t.Error("clone differs from original")
+	}
+
+	// Modify the clone.
+	fi, err := ssif.LoadContainerFromPath(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	if err := sif.Update(fi, corpus.ImageIndex(t, "hello-world-docker-v2-manifest-list")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.Verify(fi); err != nil {
+		t.Fatal(err)
+	}
+
+	// The original is unchanged.
+	after, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(before, after) {
+		t.Error("original modified")
+	}
+
+	// An existing file is not overwritten.
+	if err := sif.Clone(src, dst); !errors.Is(err, os.ErrExist) {
+		t.Errorf("got error %v, want %v", err, os.ErrExist)
+	}
+}
+
+func TestCloneInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "invalid.sif")
+
+	if err := os.WriteFile(src, []byte("not a SIF"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "clone.sif")
+
+	if err := sif.Clone(src, dst); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+
+	// The partially written clone is removed.
+	if _, err := os.Stat(dst); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, os.ErrNotExist)
+	}
+}
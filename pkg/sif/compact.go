@@ -0,0 +1,392 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// isOCIObject returns true if d contains OCI content.
+func isOCIObject(d sif.Descriptor) bool {
+	return d.DataType() == sif.DataOCIBlob || d.DataType() == sif.DataOCIRootIndex
+}
+
+// reachableBlobs returns the set of blob digests reachable from the RootIndex of f.
+func (f *fileImage) reachableBlobs() (map[v1.Hash]bool, error) {
+	d, err := f.GetDescriptor(sif.WithDataType(sif.DataOCIRootIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := d.GetData()
+	if err != nil {
+		return nil, err
+	}
+
+	so := streamingOrder{
+		f:    f,
+		seen: make(map[v1.Hash]bool),
+	}
+
+	if err := so.visitIndex(b); err != nil {
+		return nil, err
+	}
+
+	reachable := make(map[v1.Hash]bool, len(so.meta)+len(so.data))
+	for _, h := range so.meta {
+		reachable[h] = true
+	}
+	for _, h := range so.data {
+		reachable[h] = true
+	}
+
+	return reachable, nil
+}
+
+// removeUnreachableBlobs deletes the OCI blobs in f that are not reachable from the RootIndex, and
+// returns their total size. The space occupied by deleted blobs is not reclaimed.
+func (f *fileImage) removeUnreachableBlobs() (int64, error) {
+	reachable, err := f.reachableBlobs()
+	if err != nil {
+		return 0, err
+	}
+
+	ds, err := f.GetDescriptors(sif.WithDataType(sif.DataOCIBlob))
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return 0, err
+	}
+
+	var removed int64
+
+	for _, d := range ds {
+		h, err := d.OCIBlobDigest()
+		if err != nil {
+			return removed, err
+		}
+
+		if reachable[h] {
+			continue
+		}
+
+		if err := f.DeleteObject(d.ID()); err != nil {
+			return removed, err
+		}
+		removed += d.Size()
+	}
+
+	return removed, nil
+}
+
+// PruneOrphanedBlobs deletes the OCI blobs in fi that are not reachable from the RootIndex, and
+// returns their total size, in bytes. Orphaned blobs may be left behind if an update of fi is
+// interrupted (e.g. by a crash). Duplicate copies of reachable blobs are retained; to remove them,
+// consider using DedupBlobs.
+//
+// Deleted objects are not compacted, so fi does not shrink. To reclaim the space, consider using
+// CompactInPlace.
+//
+// Before fi is modified, PruneOrphanedBlobs checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update.
+func PruneOrphanedBlobs(fi *sif.FileImage) (int64, error) {
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	return f.removeUnreachableBlobs()
+}
+
+// DedupBlobs removes duplicate copies of OCI blobs in fi, where more than one blob with the same
+// digest is stored, and returns the number of copies removed. The first copy of each blob is
+// retained, so every blob referenced from the RootIndex remains available.
+//
+// Removed objects are not compacted, so fi does not shrink.
+//
+// Before fi is modified, DedupBlobs checks that it was opened for writing, and places an exclusive
+// advisory lock on the underlying file, as described for Update.
+func DedupBlobs(fi *sif.FileImage) (int, error) {
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	ds, err := fi.GetDescriptors(sif.WithDataType(sif.DataOCIBlob))
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return 0, err
+	}
+
+	seen := make(map[v1.Hash]bool, len(ds))
+	removed := 0
+
+	for _, d := range ds {
+		h, err := d.OCIBlobDigest()
+		if err != nil {
+			return removed, err
+		}
+
+		if !seen[h] {
+			seen[h] = true
+			continue
+		}
+
+		if err := fi.DeleteObject(d.ID()); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// cacheObject copies the data of d to a file in dir, named by the ID of d.
+func cacheObject(d sif.Descriptor, dir string) error {
+	w, err := os.Create(filepath.Join(dir, fmt.Sprint(d.ID())))
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, d.GetReader()); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// compactInPlaceOpts accumulates CompactInPlace options.
+type compactInPlaceOpts struct {
+	tempDir string
+}
+
+// CompactInPlaceOpt are used to specify CompactInPlace options.
+type CompactInPlaceOpt func(*compactInPlaceOpts) error
+
+// OptCompactInPlaceTempDir specifies the directory in which objects are cached while they are
+// re-written. By default, the directory returned by os.TempDir is used. On systems where that
+// directory has limited space, consider specifying a directory on the same filesystem as the SIF.
+func OptCompactInPlaceTempDir(dir string) CompactInPlaceOpt {
+	return func(co *compactInPlaceOpts) error {
+		co.tempDir = dir
+		return nil
+	}
+}
+
+// CompactInPlace reclaims the space occupied by OCI blobs in fi that are not reachable from the
+// RootIndex, including duplicate copies of reachable blobs, along with space left by objects
+// previously deleted without compaction (e.g. by RemoveImage or PruneOrphanedBlobs). Unlike
+// writing a new SIF, the existing file is modified, so free space equal to the size of the image
+// is not required.
+//
+// A SIF object cannot be moved within the file, so the OCI objects that follow the last object
+// that does not contain OCI content are copied to a temporary directory, removed from fi, and
+// re-written once the file has been truncated. Objects that do not contain OCI content are never
+// moved; reclaimable space that precedes such an object is left in place.
+//
+// Once the file has been truncated, fi is reloaded from the storage backing it. As for
+// sif.LoadContainer, that storage is closed when fi is unloaded.
+//
+// If the operation is interrupted (e.g. by a crash) while objects are being re-written, fi may be
+// left incomplete. Where sufficient space is available, writing a new SIF and renaming it over the
+// original is the safer alternative.
+//
+// By default, objects are cached in the directory returned by os.TempDir. To override this,
+// consider using OptCompactInPlaceTempDir.
+//
+// Before fi is modified, CompactInPlace checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update.
+func CompactInPlace(fi *sif.FileImage, opts ...CompactInPlaceOpt) error {
+	var co compactInPlaceOpts
+
+	for _, opt := range opts {
+		if err := opt(&co); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	r, _ := backingReaderAt(fi)
+
+	rw, ok := r.(sif.ReadWriter)
+	if !ok {
+		return errUnknownStorage
+	}
+
+	f := &fileImage{FileImage: fi}
+
+	reachable, err := f.reachableBlobs()
+	if err != nil {
+		return err
+	}
+
+	ds, err := f.GetDescriptors(func(sif.Descriptor) (bool, error) { return true, nil })
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Offset() < ds[j].Offset() })
+
+	// Determine which objects are reclaimable.
+	seen := make(map[v1.Hash]bool)
+	reclaim := make(map[uint32]bool)
+
+	for _, d := range ds {
+		if d.DataType() != sif.DataOCIBlob {
+			continue
+		}
+
+		h, err := d.OCIBlobDigest()
+		if err != nil {
+			return err
+		}
+
+		if !reachable[h] || seen[h] {
+			reclaim[d.ID()] = true
+		}
+		seen[h] = true
+	}
+
+	// Only objects following the last non-OCI object can be moved.
+	start, tailOffset := 0, fi.DataOffset()
+	for i, d := range ds {
+		if !isOCIObject(d) {
+			start, tailOffset = i+1, d.Offset()+d.Size()
+		}
+	}
+
+	tail := ds[start:]
+
+	// Nothing to do if the tail contains no reclaimable objects, and no unoccupied space.
+	if !needsCompaction(tail, tailOffset, fi.DataOffset()+fi.DataSize(), reclaim) {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp(co.tempDir, "oci-tools-compact-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, d := range tail {
+		if !reclaim[d.ID()] {
+			if err := cacheObject(d, dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Delete the tail in descending order of offset, truncating the file as we go.
+	for i := len(tail) - 1; i >= 0; i-- {
+		if err := f.DeleteObject(tail[i].ID(), sif.OptDeleteCompact(true)); err != nil {
+			return err
+		}
+	}
+
+	// Space left by objects previously deleted without compaction is still accounted for in the
+	// data region, so truncate it explicitly.
+	if err := truncateDataRegion(fi, rw, tailOffset); err != nil {
+		return err
+	}
+
+	// Re-write retained objects, in their original order.
+	for _, d := range tail {
+		if reclaim[d.ID()] {
+			continue
+		}
+
+		if err := f.writeCachedObject(d, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// needsCompaction returns true if any object in tail, which is sorted by offset and occupies the
+// data region from start to end, is reclaimable, or if any part of that region is unoccupied.
+func needsCompaction(tail []sif.Descriptor, start, end int64, reclaim map[uint32]bool) bool {
+	off := start
+
+	for _, d := range tail {
+		if reclaim[d.ID()] || d.Offset() != off {
+			return true
+		}
+		off = d.Offset() + d.Size()
+	}
+
+	return off != end
+}
+
+// Offsets of the data region fields within the SIF global header.
+const (
+	hdrDataOffsetOffset = 112
+	hdrDataSizeOffset   = 120
+)
+
+var errUnexpectedHeader = errors.New("unexpected SIF header")
+
+// truncateDataRegion truncates the data region of fi, which is backed by rw, so that it ends at
+// offset end. Once truncated, fi is reloaded from rw.
+func truncateDataRegion(fi *sif.FileImage, rw sif.ReadWriter, end int64) error {
+	var fields [2]int64
+
+	sr := io.NewSectionReader(rw, hdrDataOffsetOffset, int64(binary.Size(fields)))
+	if err := binary.Read(sr, binary.LittleEndian, &fields); err != nil {
+		return err
+	}
+
+	if fields[0] != fi.DataOffset() || fields[1] != fi.DataSize() {
+		return errUnexpectedHeader
+	}
+
+	if _, err := rw.Seek(hdrDataSizeOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := binary.Write(rw, binary.LittleEndian, end-fi.DataOffset()); err != nil {
+		return err
+	}
+
+	if err := rw.Truncate(end); err != nil {
+		return err
+	}
+
+	reloaded, err := sif.LoadContainer(rw)
+	if err != nil {
+		return err
+	}
+
+	*fi = *reloaded
+
+	return nil
+}
+
+// writeCachedObject writes the data of d, previously cached in dir by cacheObject, to f.
+func (f *fileImage) writeCachedObject(d sif.Descriptor, dir string) error {
+	r, err := os.Open(filepath.Join(dir, fmt.Sprint(d.ID())))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return f.writeBlobToFileImage(r, d.DataType() == sif.DataOCIRootIndex)
+}
@@ -0,0 +1,328 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+func TestCompactInPlace(t *testing.T) {
+	partition := []byte("not really squashfs")
+
+	tests := []struct {
+		name       string
+		partition  bool
+		garbage    bool
+		opts       []sif.CompactInPlaceOpt
+		wantErr    bool
+		wantShrink bool
+	}{
+		{
+			name:       "Garbage",
+			garbage:    true,
+			wantShrink: true,
+		},
+		{
+			name:       "PartitionAndGarbage",
+			partition:  true,
+			garbage:    true,
+			wantShrink: true,
+		},
+		{
+			name:       "TempDir",
+			garbage:    true,
+			opts:       []sif.CompactInPlaceOpt{sif.OptCompactInPlaceTempDir(t.TempDir())},
+			wantShrink: true,
+		},
+		{
+			name:    "TempDirNotExist",
+			garbage: true,
+			opts:    []sif.CompactInPlaceOpt{sif.OptCompactInPlaceTempDir(filepath.Join(t.TempDir(), "missing"))},
+			wantErr: true,
+		},
+		{
+			name:      "NoGarbage",
+			partition: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dis []ssif.DescriptorInput
+
+			if tt.partition {
+				di, err := ssif.NewDescriptorInput(ssif.DataPartition, bytes.NewReader(partition),
+					ssif.OptPartitionMetadata(ssif.FsSquash, ssif.PartPrimSys, "amd64"),
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				dis = append(dis, di)
+			}
+
+			if tt.garbage {
+				// An OCI blob that is not referenced by the RootIndex.
+				di, err := ssif.NewDescriptorInput(ssif.DataOCIBlob, bytes.NewReader(make([]byte, 64<<10)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				dis = append(dis, di)
+			}
+
+			path := filepath.Join(t.TempDir(), "image.sif")
+
+			fi, err := ssif.CreateContainerAtPath(path,
+				ssif.OptCreateDeterministic(),
+				ssif.OptCreateWithDescriptors(dis...),
+				ssif.OptCreateWithDescriptorCapacity(64),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+			if err := sif.EmbedOCI(fi, corpus.ImageIndex(t, "many-layers")); err != nil {
+				t.Fatal(err)
+			}
+
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sif.CompactInPlace(fi, tt.opts...)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := after.Size() < before.Size(), tt.wantShrink; got != want {
+				t.Errorf("got size %v -> %v, want shrink %v", before.Size(), after.Size(), want)
+			}
+
+			ii, err := sif.ImageIndexFromFileImage(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Index(ii); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := sif.VerifyReferences(fi); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.partition {
+				d, err := fi.GetDescriptor(ssif.WithPartitionType(ssif.PartPrimSys))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				b, err := d.GetData()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got, want := b, partition; !bytes.Equal(got, want) {
+					t.Errorf("got partition %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupBlobs(t *testing.T) {
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	fi := fileImageWithRefs(t, taggedImage{"hello-world:latest", img})
+
+	config, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := ls[0].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make(map[v1.Hash][]byte)
+
+	for _, h := range []v1.Hash{config, layer} {
+		d, err := fi.GetDescriptor(ssif.WithOCIBlobDigest(h))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data[h], err = d.GetData(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Store a duplicate of the config, and two duplicates of the layer.
+	for _, h := range []v1.Hash{config, layer, layer} {
+		di, err := ssif.NewDescriptorInput(ssif.DataOCIBlob, bytes.NewReader(data[h]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fi.AddObject(di); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := sif.DedupBlobs(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := removed, 3; got != want {
+		t.Errorf("got %v removed, want %v", got, want)
+	}
+
+	// A single copy of each blob remains, and is referenced from the RootIndex.
+	stats, err := sif.DataTypeBreakdown(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := stats[ssif.DataOCIBlob].Count, 3; got != want {
+		t.Errorf("got %v blobs, want %v", got, want)
+	}
+
+	if err := sif.Verify(fi); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing further to remove.
+	if removed, err := sif.DedupBlobs(fi); err != nil {
+		t.Fatal(err)
+	} else if removed != 0 {
+		t.Errorf("got %v removed, want 0", removed)
+	}
+}
+
+func TestPruneOrphanedBlobs(t *testing.T) {
+	fi := fileImageWithRefs(t, taggedImage{"hello-world:latest", corpus.Image(t, "hello-world-docker-v2-manifest")})
+
+	before, err := sif.DataTypeBreakdown(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Store blobs that are not referenced from the RootIndex, as an interrupted update might.
+	orphans := [][]byte{[]byte("orphan-1"), []byte("orphan-22")}
+
+	for _, b := range orphans {
+		di, err := ssif.NewDescriptorInput(ssif.DataOCIBlob, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fi.AddObject(di); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reclaimed, err := sif.PruneOrphanedBlobs(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := reclaimed, int64(len(orphans[0])+len(orphans[1])); got != want {
+		t.Errorf("got %v bytes reclaimed, want %v", got, want)
+	}
+
+	after, err := sif.DataTypeBreakdown(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := after[ssif.DataOCIBlob], before[ssif.DataOCIBlob]; got != want {
+		t.Errorf("got blobs %+v, want %+v", got, want)
+	}
+
+	if err := sif.Verify(fi); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing further to prune.
+	if reclaimed, err := sif.PruneOrphanedBlobs(fi); err != nil {
+		t.Fatal(err)
+	} else if reclaimed != 0 {
+		t.Errorf("got %v bytes reclaimed, want 0", reclaimed)
+	}
+}
+
+func TestCompactInPlaceAfterRemoveImage(t *testing.T) {
+	fi := fileImageFromPath(t, "hello-world-docker-v2-manifest-list")
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, desc := range im.Manifests[1:] {
+		if err := sif.RemoveImage(fi, desc.Digest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, err := sif.FragmentationStats(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.CompactInPlace(fi); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := sif.FragmentationStats(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := after.ReclaimableBytes, int64(0); got != want {
+		t.Errorf("got %v reclaimable bytes, want %v", got, want)
+	}
+
+	if got, want := after.FileSize, before.FileSize-before.ReclaimableBytes; got != want {
+		t.Errorf("got file size %v, want %v", got, want)
+	}
+
+	ii, err = sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.VerifyReferences(fi); err != nil {
+		t.Fatal(err)
+	}
+}
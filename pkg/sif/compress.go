@@ -0,0 +1,258 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/compression"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+var errUnsupportedCompression = errors.New("unsupported layer compression")
+
+// layerCompression describes the compression applied to layers by recompressIndex.
+type layerCompression struct {
+	algorithm compression.Compression
+	level     int
+}
+
+// uncompressedLayer wraps a layer, exposing its uncompressed content as the layer blob.
+type uncompressedLayer struct {
+	v1.Layer
+	mediaType types.MediaType
+
+	once sync.Once
+	size int64
+	err  error
+}
+
+// Digest returns the Hash of the layer blob, which is the uncompressed layer.
+func (l *uncompressedLayer) Digest() (v1.Hash, error) {
+	return l.Layer.DiffID()
+}
+
+// Compressed returns an io.ReadCloser for the layer blob, which is the uncompressed layer.
+func (l *uncompressedLayer) Compressed() (io.ReadCloser, error) {
+	return l.Layer.Uncompressed()
+}
+
+// Size returns the size of the layer blob, which is the uncompressed layer.
+func (l *uncompressedLayer) Size() (int64, error) {
+	l.once.Do(func() {
+		rc, err := l.Layer.Uncompressed()
+		if err != nil {
+			l.err = err
+			return
+		}
+		defer rc.Close()
+
+		l.size, l.err = io.Copy(io.Discard, rc)
+	})
+
+	return l.size, l.err
+}
+
+// MediaType returns the media type of the layer.
+func (l *uncompressedLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// recompressLayer returns a layer with the uncompressed content of l, compressed according to c.
+// The media type of the returned layer is selected according to whether the layer is to be
+// referenced from a Docker (docker is set) or OCI image manifest.
+func recompressLayer(l v1.Layer, c layerCompression, docker bool) (v1.Layer, error) {
+	switch c.algorithm {
+	case compression.None:
+		mt := types.OCIUncompressedLayer
+		if docker {
+			mt = types.DockerUncompressedLayer
+		}
+
+		return &uncompressedLayer{Layer: l, mediaType: mt}, nil
+
+	case compression.GZip:
+		mt := types.OCILayer
+		if docker {
+			mt = types.DockerLayer
+		}
+
+		return tarball.LayerFromOpener(l.Uncompressed,
+			tarball.WithCompression(compression.GZip),
+			tarball.WithCompressionLevel(c.level),
+			tarball.WithMediaType(mt),
+		)
+
+	case compression.ZStd:
+		if docker {
+			return nil, fmt.Errorf("%w: %v in Docker image", errUnsupportedCompression, c.algorithm)
+		}
+
+		return tarball.LayerFromOpener(l.Uncompressed,
+			tarball.WithCompression(compression.ZStd),
+			tarball.WithCompressionLevel(c.level),
+			tarball.WithMediaType(types.OCILayerZStd),
+		)
+
+	default:
+		return nil, fmt.Errorf("%w: %v", errUnsupportedCompression, c.algorithm)
+	}
+}
+
+// recompressedImage is an image with a replacement manifest, referencing replacement layers. The
+// config is taken from base.
+type recompressedImage struct {
+	base   v1.Image
+	raw    []byte
+	layers map[v1.Hash]v1.Layer
+}
+
+// RawConfigFile returns the serialized bytes of the config of the base image.
+func (img *recompressedImage) RawConfigFile() ([]byte, error) {
+	return img.base.RawConfigFile()
+}
+
+// MediaType of this image's manifest.
+func (img *recompressedImage) MediaType() (types.MediaType, error) {
+	return img.base.MediaType()
+}
+
+// RawManifest returns the serialized bytes of the replacement manifest.
+func (img *recompressedImage) RawManifest() ([]byte, error) {
+	return img.raw, nil
+}
+
+// LayerByDigest returns the replacement layer with digest h.
+func (img *recompressedImage) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	if l, ok := img.layers[h]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("%w: %v", errLayerNotFoundInImage, h)
+}
+
+// recompressImage returns img with each layer recompressed according to c. Layer descriptor
+// annotations are retained.
+func recompressImage(img v1.Image, c layerCompression) (v1.Image, error) {
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	m = m.DeepCopy()
+
+	ls, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make(map[v1.Hash]v1.Layer, len(ls))
+
+	for i, l := range ls {
+		rl, err := recompressLayer(l, c, m.MediaType == types.DockerManifestSchema2)
+		if err != nil {
+			return nil, err
+		}
+
+		d, err := partial.Descriptor(rl)
+		if err != nil {
+			return nil, err
+		}
+
+		m.Layers[i].MediaType = d.MediaType
+		m.Layers[i].Digest = d.Digest
+		m.Layers[i].Size = d.Size
+
+		layers[d.Digest] = rl
+	}
+
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.CompressedToImage(&recompressedImage{
+		base:   img,
+		raw:    raw,
+		layers: layers,
+	})
+}
+
+// recompressIndex returns ii with the layers of each image, including those within child indexes,
+// recompressed according to c.
+func recompressIndex(ii v1.ImageIndex, c layerCompression) (v1.ImageIndex, error) {
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	im = im.DeepCopy()
+
+	children := make(map[v1.Hash]v1.ImageIndex)
+	images := make(map[v1.Hash]v1.Image)
+
+	for i, desc := range im.Manifests {
+		var (
+			h    v1.Hash
+			size int64
+		)
+
+		switch mt := desc.MediaType; {
+		case mt.IsIndex():
+			child, err := ii.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			conv, err := recompressIndex(child, c)
+			if err != nil {
+				return nil, err
+			}
+
+			if h, err = conv.Digest(); err != nil {
+				return nil, err
+			}
+
+			if size, err = conv.Size(); err != nil {
+				return nil, err
+			}
+
+			children[h] = conv
+
+		case mt.IsImage():
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			conv, err := recompressImage(img, c)
+			if err != nil {
+				return nil, err
+			}
+
+			if h, err = conv.Digest(); err != nil {
+				return nil, err
+			}
+
+			if size, err = conv.Size(); err != nil {
+				return nil, err
+			}
+
+			images[h] = conv
+
+		default:
+			continue
+		}
+
+		im.Manifests[i].Digest = h
+		im.Manifests[i].Size = size
+	}
+
+	return rewriteIndex(ii, im, children, images)
+}
@@ -0,0 +1,250 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// deltaIndexName is the name of the delta entry holding the RootIndex.
+const deltaIndexName = "index.json"
+
+var (
+	errDeltaMissingIndex    = errors.New("delta does not begin with index")
+	errUnexpectedDeltaEntry = errors.New("unexpected delta entry")
+)
+
+// deltaBlobName returns the name of the delta entry holding the blob with digest h.
+func deltaBlobName(h v1.Hash) string {
+	return path.Join("blobs", h.Algorithm, h.Hex)
+}
+
+// Delta writes a delta bundle to w, which can be applied to oldFi by ApplyDelta to reproduce the
+// OCI content of newFi. The bundle holds the RootIndex of newFi, and each blob referenced from it
+// that is not present in oldFi.
+//
+// The bundle is a TAR stream. The first entry, "index.json", holds the RootIndex. Each following
+// entry holds a blob, named "blobs/<alg>/<encoded>" as in an OCI Image Layout. Blobs are streamed
+// from newFi, so they are not held in memory.
+func Delta(oldFi, newFi *sif.FileImage, w io.Writer) error {
+	n := &fileImage{FileImage: newFi}
+
+	d, err := n.GetDescriptor(sif.WithDataType(sif.DataOCIRootIndex))
+	if err != nil {
+		return err
+	}
+
+	ii, err := n.ImageIndex()
+	if err != nil {
+		return err
+	}
+
+	refs := make(map[v1.Hash]v1.Descriptor)
+	if err := referencedBlobs(ii, refs); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     deltaIndexName,
+		Mode:     0o644,
+		Size:     d.Size(),
+	}); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tw, d.GetReader()); err != nil {
+		return err
+	}
+
+	o := &fileImage{FileImage: oldFi}
+
+	ds, err := n.GetDescriptors(sif.WithDataType(sif.DataOCIBlob))
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return err
+	}
+
+	for _, d := range ds {
+		h, err := d.OCIBlobDigest()
+		if err != nil {
+			return err
+		}
+
+		if _, ok := refs[h]; !ok {
+			continue
+		}
+
+		if ok, err := o.hasBlob(h); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     deltaBlobName(h),
+			Mode:     0o644,
+			Size:     d.Size(),
+		}); err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(tw, d.GetReader()); err != nil {
+			return err
+		}
+
+		// Each blob is referenced once, but may be stored more than once.
+		delete(refs, h)
+	}
+
+	return tw.Close()
+}
+
+// writeDeltaBlob writes the blob in r to f, verifying that its content matches the digest h. If
+// f already contains a blob with digest h, r is not read.
+func (f *fileImage) writeDeltaBlob(h v1.Hash, r io.Reader) error {
+	if ok, err := f.hasBlob(h); err != nil || ok {
+		return err
+	}
+
+	hasher, err := v1.Hasher(h.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	if err := f.writeBlobToFileImage(io.TeeReader(r, hasher), false); err != nil {
+		return err
+	}
+
+	if got := (v1.Hash{Algorithm: h.Algorithm, Hex: fmt.Sprintf("%x", hasher.Sum(nil))}); got != h {
+		// Remove the blob just written, which is stored under the digest of its actual content.
+		d, err := f.GetDescriptor(sif.WithOCIBlobDigest(got))
+		if err == nil {
+			err = f.DeleteObject(d.ID())
+		}
+		return errors.Join(fmt.Errorf("%w: %v has digest %v", errDigestMismatch, h, got), err)
+	}
+
+	return nil
+}
+
+// ApplyDelta applies the delta bundle read from r, as written by Delta, to fi. Each blob in the
+// bundle is verified against its digest and written to fi, and fi is then updated to hold the
+// content of the RootIndex in the bundle, as described for Update. Blobs already present in fi are
+// not written again.
+//
+// For the result to be complete, fi must hold the content of the SIF the bundle was created
+// against. If the RootIndex in the bundle references a blob that is neither in fi nor in the
+// bundle, an error is returned.
+//
+// Before fi is modified, ApplyDelta checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update.
+func ApplyDelta(fi *sif.FileImage, r io.Reader) error {
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if errors.Is(err, io.EOF) {
+		return errDeltaMissingIndex
+	}
+	if err != nil {
+		return err
+	}
+
+	if hdr.Name != deltaIndexName {
+		return fmt.Errorf("%w: %v", errDeltaMissingIndex, hdr.Name)
+	}
+
+	b, err := io.ReadAll(tr)
+	if err != nil {
+		return err
+	}
+
+	f := &fileImage{FileImage: fi}
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		parts := strings.Split(hdr.Name, "/")
+		if len(parts) != 3 || parts[0] != "blobs" {
+			return fmt.Errorf("%w: %v", errUnexpectedDeltaEntry, hdr.Name)
+		}
+
+		h, err := v1.NewHash(parts[1] + ":" + parts[2])
+		if err != nil {
+			return fmt.Errorf("%w: %v: %w", errUnexpectedDeltaEntry, hdr.Name, err)
+		}
+
+		if err := f.writeDeltaBlob(h, tr); err != nil {
+			return err
+		}
+	}
+
+	im, err := v1.ParseIndexManifest(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	// Retain the media type of the RootIndex in the bundle.
+	mt := im.MediaType
+	if mt == "" {
+		mt = types.OCIImageIndex
+	}
+
+	digest, size, err := v1.SHA256(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	ii := &imageIndex{
+		f: f,
+		desc: &v1.Descriptor{
+			MediaType: mt,
+			Size:      size,
+			Digest:    digest,
+		},
+		rawManifest: b,
+	}
+
+	// Check that the content of the RootIndex is complete before fi is updated, so that fi is not
+	// left with an incomplete RootIndex.
+	refs := make(map[v1.Hash]v1.Descriptor)
+	if err := referencedBlobs(ii, refs); err != nil {
+		return err
+	}
+
+	for h := range refs {
+		if ok, err := f.hasBlob(h); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%w: %v", errDanglingReference, h)
+		}
+	}
+
+	return update(fi, ii, updateOpts{})
+}
@@ -0,0 +1,284 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// tarNames returns the names of the entries in the TAR stream b.
+func tarNames(tb testing.TB, b []byte) []string {
+	tb.Helper()
+
+	var names []string
+
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return names
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestDelta(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+	labeled := labeledImage(t, base, "key", "value")
+
+	oldFi := fileImageWithRefs(t, taggedImage{"hello-world:latest", base})
+	newFi := fileImageWithRefs(t,
+		taggedImage{"hello-world:latest", base},
+		taggedImage{"hello-world:labeled", labeled},
+	)
+
+	var b bytes.Buffer
+
+	if err := sif.Delta(oldFi, newFi, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	// The delta holds the RootIndex, and the manifest and config of the labeled image.
+	if got, want := len(tarNames(t, b.Bytes())), 3; got != want {
+		t.Fatalf("got %v entries, want %v", got, want)
+	}
+
+	if err := sif.ApplyDelta(oldFi, bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.Verify(oldFi); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sif.ImageIndexFromFileImage(oldFi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(got); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := sif.ImageIndexFromFileImage(newFi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotDigest, err := got.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDigest, err := want.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotDigest != wantDigest {
+		t.Errorf("got digest %v, want %v", gotDigest, wantDigest)
+	}
+
+	blobs := func(fi *ssif.FileImage) []v1.Hash {
+		hs, err := sif.Blobs(fi, func(v1.Descriptor) bool { return true })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hs
+	}
+
+	if got, want := len(blobs(oldFi)), len(blobs(newFi)); got != want {
+		t.Errorf("got %v blobs, want %v", got, want)
+	}
+}
+
+func TestApplyDeltaIncomplete(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	oldFi := fileImageWithRefs(t, taggedImage{"hello-world:latest", base})
+	newFi := fileImageWithRefs(t, taggedImage{"many-layers:latest", corpus.Image(t, "many-layers")})
+
+	var b bytes.Buffer
+
+	if err := sif.Delta(oldFi, newFi, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := sif.ImageIndexFromFileImage(oldFi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDigest, err := before.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate the delta after the RootIndex, so the blobs it references are missing.
+	var truncated bytes.Buffer
+
+	tr := tar.NewReader(bytes.NewReader(b.Bytes()))
+	tw := tar.NewWriter(&truncated)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.Copy(tw, tr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.ApplyDelta(oldFi, &truncated); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+
+	// The RootIndex is not modified.
+	after, err := sif.ImageIndexFromFileImage(oldFi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := after.Digest(); err != nil {
+		t.Fatal(err)
+	} else if got != wantDigest {
+		t.Errorf("got digest %v, want %v", got, wantDigest)
+	}
+}
+
+func TestApplyDeltaCorrupt(t *testing.T) {
+	fi := fileImageWithRefs(t, taggedImage{"hello-world:latest", corpus.Image(t, "hello-world-docker-v2-manifest")})
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ii.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, _, err := v1.SHA256(bytes.NewReader([]byte("claimed")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, _, err := v1.SHA256(bytes.NewReader([]byte("actual")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Construct a delta holding a blob whose content does not match its name.
+	var b bytes.Buffer
+
+	tw := tar.NewWriter(&b)
+
+	for _, e := range []struct {
+		name    string
+		content []byte
+	}{
+		{"index.json", index},
+		{"blobs/" + claimed.Algorithm + "/" + claimed.Hex, []byte("actual")},
+	} {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     e.name,
+			Mode:     0o644,
+			Size:     int64(len(e.content)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write(e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.ApplyDelta(fi, &b); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+
+	// The corrupt blob is not retained.
+	for _, h := range []v1.Hash{claimed, actual} {
+		if _, err := fi.GetDescriptor(ssif.WithOCIBlobDigest(h)); err == nil {
+			t.Errorf("blob %v present", h)
+		}
+	}
+}
+
+func TestDeltaDockerManifestList(t *testing.T) {
+	oldFi := emptyFileImage(t, 32)
+	newFi := fileImageFromPath(t, "hello-world-docker-v2-manifest-list")
+
+	var b bytes.Buffer
+
+	if err := sif.Delta(oldFi, newFi, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.ApplyDelta(oldFi, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sif.ImageIndexFromFileImage(oldFi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := got.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := im.MediaType, types.DockerManifestList; got != want {
+		t.Errorf("got media type %v, want %v", got, want)
+	}
+
+	want, err := sif.ImageIndexFromFileImage(newFi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotDigest, err := got.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDigest, err := want.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotDigest != wantDigest {
+		t.Errorf("got digest %v, want %v", gotDigest, wantDigest)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+var (
+	errNoImage               = errors.New("no image found")
+	errUnsupportedDockerType = errors.New("layer media type not supported by docker load")
+)
+
+// firstImage returns the first image reachable from ii, descending into nested indexes.
+func firstImage(ii v1.ImageIndex) (v1.Image, error) {
+	var first v1.Image
+
+	err := walkImages(ii, "", func(img v1.Image, _ ImageEntry) error {
+		first = img
+		return errStopWalk
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, err
+	}
+
+	if first == nil {
+		return nil, errNoImage
+	}
+
+	return first, nil
+}
+
+// ExportDockerTar writes the first image in the RootIndex of fi to w, in the format produced by
+// "docker save", so that it can be loaded with "docker load". The image is tagged with ref. Where
+// the first entry in the RootIndex is an index, the first image within it is written.
+//
+// The tarball holds a manifest.json, the image config, and each layer blob as stored in fi. Layers
+// are not decompressed, so only gzip-compressed and uncompressed layers are supported
+// (types.DockerLayer, types.OCILayer, types.DockerUncompressedLayer and
+// types.OCIUncompressedLayer). If the image contains a layer of any other media type (e.g.
+// types.OCILayerZStd), an error is returned and nothing is written. The legacy "repositories" file
+// is not written, as it is not required by "docker load".
+func ExportDockerTar(fi *sif.FileImage, w io.Writer, ref name.Reference) error {
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return err
+	}
+
+	img, err := firstImage(ii)
+	if err != nil {
+		return err
+	}
+
+	m, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range m.Layers {
+		switch l.MediaType {
+		case types.DockerLayer, types.OCILayer, types.DockerUncompressedLayer, types.OCIUncompressedLayer:
+		default:
+			return fmt.Errorf("%w: %v: %v", errUnsupportedDockerType, l.Digest, l.MediaType)
+		}
+	}
+
+	return tarball.Write(ref, img, w)
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrmutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sylabs/oci-tools/pkg/sif"
+)
+
+func TestExportDockerTar(t *testing.T) {
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	tag, err := name.NewTag("hello-world:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Image", func(t *testing.T) {
+		fi := fileImageWithRefs(t, taggedImage{"hello-world:latest", img})
+
+		var b bytes.Buffer
+
+		if err := sif.ExportDockerTar(fi, &b, tag); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := tarball.Image(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b.Bytes())), nil
+		}, &tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotConfig, err := got.ConfigName()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantConfig, err := img.ConfigName()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if gotConfig != wantConfig {
+			t.Errorf("got config %v, want %v", gotConfig, wantConfig)
+		}
+
+		gotLayers, err := got.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantLayers, err := img.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := len(gotLayers), len(wantLayers); got != want {
+			t.Fatalf("got %v layers, want %v", got, want)
+		}
+
+		for i := range gotLayers {
+			got, err := gotLayers[i].Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := wantLayers[i].Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != want {
+				t.Errorf("layer %v: got digest %v, want %v", i, got, want)
+			}
+		}
+	})
+
+	t.Run("UnsupportedLayer", func(t *testing.T) {
+		zimg, err := ggcrmutate.AppendLayers(img, static.NewLayer([]byte("zstd"), types.OCILayerZStd))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fi := fileImageWithRefs(t, taggedImage{"hello-world:latest", zimg})
+
+		var b bytes.Buffer
+
+		if err := sif.ExportDockerTar(fi, &b, tag); err == nil {
+			t.Fatal("got nil error, want error")
+		}
+
+		if got := b.Len(); got != 0 {
+			t.Errorf("got %v bytes written, want 0", got)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"errors"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// HasOCI returns true if fi contains a RootIndex, and therefore OCI content.
+func HasOCI(fi *sif.FileImage) (bool, error) {
+	_, err := fi.GetDescriptor(sif.WithDataType(sif.DataOCIRootIndex))
+	switch {
+	case err == nil, errors.Is(err, sif.ErrMultipleObjectsFound):
+		return true, nil
+	case errors.Is(err, sif.ErrNoObjects), errors.Is(err, sif.ErrObjectNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+var errOCIPresent = errors.New("SIF already contains OCI content")
+
+// EmbedOCI writes ii, and all of its child indexes, manifests and blobs, to fi as the RootIndex.
+// Existing objects in fi, such as a SquashFS partition, are not modified. This allows a single SIF
+// to serve both Singularity-native and OCI consumers.
+//
+// fi must not already contain OCI content, and must have sufficient spare descriptor capacity to
+// store ii.
+//
+// Before fi is modified, EmbedOCI checks that it was opened for writing, and places an exclusive
+// advisory lock on the underlying file, as described for Update.
+func EmbedOCI(fi *sif.FileImage, ii v1.ImageIndex) error {
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if ok, err := HasOCI(fi); err != nil {
+		return err
+	} else if ok {
+		return errOCIPresent
+	}
+
+	f := &fileImage{FileImage: fi}
+
+	return f.writeIndexToFileImage(ii, true)
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+func TestEmbedOCI(t *testing.T) {
+	partition := []byte("not really squashfs")
+
+	di, err := ssif.NewDescriptorInput(ssif.DataPartition, bytes.NewReader(partition),
+		ssif.OptPartitionMetadata(ssif.FsSquash, ssif.PartPrimSys, "amd64"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := ssif.CreateContainerAtPath(filepath.Join(t.TempDir(), "image.sif"),
+		ssif.OptCreateDeterministic(),
+		ssif.OptCreateWithDescriptors(di),
+		ssif.OptCreateWithDescriptorCapacity(16),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	if ok, err := sif.HasOCI(fi); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("got OCI content before embedding")
+	}
+
+	ii := corpus.ImageIndex(t, "hello-world-docker-v2-manifest")
+
+	if err := sif.EmbedOCI(fi, ii); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := sif.HasOCI(fi); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("got no OCI content after embedding")
+	}
+
+	// Embedding a second time is an error.
+	if err := sif.EmbedOCI(fi, ii); err == nil {
+		t.Error("got nil error embedding twice")
+	}
+
+	// The partition is undisturbed.
+	d, err := fi.GetDescriptor(ssif.WithPartitionType(ssif.PartPrimSys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := d.GetData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := b, partition; !bytes.Equal(got, want) {
+		t.Errorf("got partition %q, want %q", got, want)
+	}
+
+	// The OCI content is readable.
+	got, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(got); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// encryptedBlobNamePrefix is the prefix of the name of an object holding an encrypted blob. The
+// name is completed by the digest of the plaintext blob.
+const encryptedBlobNamePrefix = "oci-tools.encrypted-blob:"
+
+// EncryptedAnnotation is the annotation added by EncryptBlobs to the descriptor of each config and
+// layer blob that is encrypted at rest. Its value identifies the cipher used.
+const EncryptedAnnotation = "io.sylabs.oci-tools.encrypted"
+
+// encryptedAnnotationValue is the value of EncryptedAnnotation for blobs encrypted by EncryptBlobs.
+const encryptedAnnotationValue = "AES-GCM"
+
+var errCiphertextTooShort = errors.New("ciphertext too short")
+
+// newGCM returns an AES-GCM AEAD using key, which must be 16, 24 or 32 bytes in length.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(c)
+}
+
+// withEncryptedBlobDigest selects the object holding the encrypted blob with digest h.
+func withEncryptedBlobDigest(h v1.Hash) sif.DescriptorSelectorFunc {
+	return func(d sif.Descriptor) (bool, error) {
+		return d.DataType() == sif.DataGeneric && d.Name() == encryptedBlobNamePrefix+h.String(), nil
+	}
+}
+
+// dataBlobs adds the digests of the config and layer blobs referenced by ii to refs.
+func dataBlobs(ii v1.ImageIndex, refs map[v1.Hash]bool) error {
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range im.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := ii.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+
+			if err := dataBlobs(child, refs); err != nil {
+				return err
+			}
+
+		case desc.MediaType.IsImage():
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				return err
+			}
+
+			refs[m.Config.Digest] = true
+
+			for _, l := range m.Layers {
+				refs[l.Digest] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// encryptBlob replaces the OCI blob described by d with an object holding the blob encrypted
+// using aead. The object data consists of the nonce, followed by the sealed blob.
+func (f *fileImage) encryptBlob(d sif.Descriptor, aead cipher.AEAD) error {
+	h, err := d.OCIBlobDigest()
+	if err != nil {
+		return err
+	}
+
+	b, err := d.GetData()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	// Bind the ciphertext to the digest, so that encrypted objects cannot be substituted.
+	ct := aead.Seal(nonce, nonce, b, []byte(h.String()))
+
+	di, err := sif.NewDescriptorInput(sif.DataGeneric, bytes.NewReader(ct),
+		sif.OptObjectName(encryptedBlobNamePrefix+h.String()),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Add the ciphertext before deleting the plaintext, so that the blob is not lost on failure.
+	if err := f.AddObject(di); err != nil {
+		return err
+	}
+
+	// Zero the plaintext, so that it does not remain in the file.
+	return f.DeleteObject(d.ID(), sif.OptDeleteZero(true))
+}
+
+// annotateEncrypted adds EncryptedAnnotation to the config and layer descriptors in m that
+// reference encrypted blobs in f. It returns true if m was modified.
+func (f *fileImage) annotateEncrypted(m *v1.Manifest) (bool, error) {
+	changed := false
+
+	annotate := func(desc *v1.Descriptor) error {
+		if desc.Annotations[EncryptedAnnotation] == encryptedAnnotationValue {
+			return nil
+		}
+
+		_, err := f.GetDescriptor(withEncryptedBlobDigest(desc.Digest))
+		if errors.Is(err, sif.ErrObjectNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if desc.Annotations == nil {
+			desc.Annotations = make(map[string]string)
+		}
+		desc.Annotations[EncryptedAnnotation] = encryptedAnnotationValue
+		changed = true
+
+		return nil
+	}
+
+	if err := annotate(&m.Config); err != nil {
+		return false, err
+	}
+
+	for i := range m.Layers {
+		if err := annotate(&m.Layers[i]); err != nil {
+			return false, err
+		}
+	}
+
+	return changed, nil
+}
+
+// EncryptBlobs encrypts the config and layer blobs in fi at rest, using AES-GCM with key, which
+// must be 16, 24 or 32 bytes in length. Each blob is replaced by an object holding a random nonce
+// followed by the ciphertext, and the plaintext is zeroed. Manifests and indexes are not
+// encrypted, so the structure of the image(s) in fi remains navigable.
+//
+// The descriptor of each encrypted blob is annotated with EncryptedAnnotation. As manifest digests
+// change, the indexes that reference them, including the RootIndex, are updated. Each ciphertext
+// object is added before the corresponding plaintext blob is removed, so fi must have at least one
+// spare descriptor, in addition to those required to store the updated manifests and indexes.
+//
+// Once encrypted, config and layer blobs cannot be read via the v1.Image and v1.ImageIndex
+// implementations in this package. To read a blob, use DecryptBlob. Blobs that are already
+// encrypted are skipped. Each blob is encrypted in memory.
+//
+// Before fi is modified, EncryptBlobs checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update.
+func EncryptBlobs(fi *sif.FileImage, key []byte) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	ii, err := f.ImageIndex()
+	if err != nil {
+		return err
+	}
+
+	refs := make(map[v1.Hash]bool)
+	if err := dataBlobs(ii, refs); err != nil {
+		return err
+	}
+
+	ds, err := f.GetDescriptors(sif.WithDataType(sif.DataOCIBlob))
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return err
+	}
+
+	for _, d := range ds {
+		h, err := d.OCIBlobDigest()
+		if err != nil {
+			return err
+		}
+
+		if !refs[h] {
+			continue
+		}
+
+		if err := f.encryptBlob(d, aead); err != nil {
+			return err
+		}
+	}
+
+	return f.rewriteManifests(f.annotateEncrypted)
+}
+
+// DecryptBlob returns a ReadCloser that reads the blob in fi with digest h. If the blob was
+// encrypted by EncryptBlobs, it is decrypted using key. Blobs that are not encrypted are read
+// directly, so callers need not track which blobs are encrypted.
+//
+// Encrypted blobs are authenticated and decrypted in memory before the ReadCloser is returned.
+func DecryptBlob(fi *sif.FileImage, h v1.Hash, key []byte) (io.ReadCloser, error) {
+	if d, err := fi.GetDescriptor(sif.WithOCIBlobDigest(h)); err == nil {
+		return io.NopCloser(d.GetReader()), nil
+	}
+
+	d, err := fi.GetDescriptor(withEncryptedBlobDigest(h))
+	if errors.Is(err, sif.ErrNoObjects) || errors.Is(err, sif.ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: %v", ErrBlobNotFound, h)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := d.GetData()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: %v", errCiphertextTooShort, h)
+	}
+
+	nonce, ct := b[:aead.NonceSize()], b[aead.NonceSize():]
+
+	pt, err := aead.Open(nil, nonce, ct, []byte(h.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(pt)), nil
+}
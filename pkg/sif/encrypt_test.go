@@ -0,0 +1,208 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+func TestEncryptBlobs(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	path := corpus.SIF(t, "hello-world-docker-v2-manifest", sif.OptWriteWithSpareDescriptorCapacity(8))
+
+	fi, err := ssif.LoadContainerFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	m, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := img.RawConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plaintext [][]byte
+
+	plaintext = append(plaintext, cfg)
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range ls {
+		rc, err := l.Compressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plaintext = append(plaintext, b)
+	}
+
+	if err := sif.EncryptBlobs(fi, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// No plaintext remains anywhere in the file.
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, b := range plaintext {
+		if bytes.Contains(raw, b) {
+			t.Errorf("file contains plaintext blob %v", i)
+		}
+	}
+
+	// The config and layer descriptors of the updated manifest are annotated.
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := im.Manifests[0].Digest
+
+	eimg, err := ii.Image(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rm, err := eimg.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	em, err := eimg.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, desc := range append([]v1.Descriptor{em.Config}, em.Layers...) {
+		if got, want := desc.Annotations[sif.EncryptedAnnotation], "AES-GCM"; got != want {
+			t.Errorf("%v: got annotation %q, want %q", desc.Digest, got, want)
+		}
+	}
+
+	// The config and layers are no longer stored in plaintext.
+	for _, desc := range append([]v1.Descriptor{m.Config}, m.Layers...) {
+		if _, err := fi.GetDescriptor(ssif.WithOCIBlobDigest(desc.Digest)); err == nil {
+			t.Errorf("plaintext blob %v present", desc.Digest)
+		}
+	}
+
+	ds, err := fi.GetDescriptors(ssif.WithDataType(ssif.DataGeneric))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(ds), 1+len(m.Layers); got != want {
+		t.Fatalf("got %v encrypted objects, want %v", got, want)
+	}
+
+	for _, d := range ds {
+		b, err := d.GetData()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(b, cfg) {
+			t.Errorf("object %v contains plaintext config", d.ID())
+		}
+	}
+
+	tests := []struct {
+		name    string
+		digest  v1.Hash
+		key     []byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:   "Config",
+			digest: m.Config.Digest,
+			key:    key,
+			want:   cfg,
+		},
+		{
+			name:    "WrongKey",
+			digest:  m.Config.Digest,
+			key:     bytes.Repeat([]byte{0x24}, 32),
+			wantErr: true,
+		},
+		{
+			name:   "Manifest",
+			digest: h,
+			key:    key,
+			want:   rm,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := sif.DecryptBlob(fi, tt.digest, tt.key)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err != nil {
+				return
+			}
+			defer rc.Close()
+
+			b, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := b, tt.want; !bytes.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+
+	// Layers decrypt to content with the expected digest.
+	for _, desc := range m.Layers {
+		rc, err := sif.DecryptBlob(fi, desc.Digest, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+
+		got, _, err := v1.SHA256(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := desc.Digest; got != want {
+			t.Errorf("got digest %v, want %v", got, want)
+		}
+	}
+}
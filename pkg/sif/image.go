@@ -55,13 +55,12 @@ func (im *image) Size() (int64, error) {
 
 // ConfigName returns the hash of the image's config file, also known as the Image ID.
 func (im *image) ConfigName() (v1.Hash, error) {
-	b, err := im.RawConfigFile()
+	m, err := im.Manifest()
 	if err != nil {
 		return v1.Hash{}, err
 	}
 
-	h, _, err := v1.SHA256(bytes.NewReader(b))
-	return h, err
+	return m.Config.Digest, nil
 }
 
 // ConfigFile returns this image's config file.
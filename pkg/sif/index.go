@@ -19,7 +19,7 @@ var _ v1.ImageIndex = (*imageIndex)(nil)
 
 // ImageIndexFromFileImage returns a v1.ImageIndex corresponding to f.
 func ImageIndexFromFileImage(fi *sif.FileImage) (v1.ImageIndex, error) {
-	f := &fileImage{fi}
+	f := &fileImage{FileImage: fi}
 
 	return f.ImageIndex()
 }
@@ -19,12 +19,12 @@ type withDescriptor interface {
 }
 
 // fileImageFromPath returns a temporary FileImage for the test to use, populated from the OCI
-// Image Layout with the specified path in the corpus. The FileImage is automatically unloaded when
-// the test and all its subtests complete.
-func fileImageFromPath(t *testing.T, path string) *ssif.FileImage {
+// Image Layout with the specified path in the corpus, with the supplied write options. The
+// FileImage is automatically unloaded when the test and all its subtests complete.
+func fileImageFromPath(t *testing.T, path string, opts ...sif.WriteOpt) *ssif.FileImage {
 	t.Helper()
 
-	f, err := ssif.LoadContainerFromPath(corpus.SIF(t, path))
+	f, err := ssif.LoadContainerFromPath(corpus.SIF(t, path, opts...))
 	if err != nil {
 		t.Fatal(err)
 	}
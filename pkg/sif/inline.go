@@ -0,0 +1,366 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+var errDescriptorCountChanged = errors.New("number of descriptors changed")
+
+// jsonFields returns the fields of the JSON encoding of v, which must encode as an object.
+func jsonFields(v any) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// patchFields updates the fields of a JSON object in obj whose encodings differ between before and
+// after, and removes those absent from after. Fields that are not represented by before and after,
+// such as those unknown to their types, are retained.
+func patchFields(obj map[string]json.RawMessage, before, after any) error {
+	bf, err := jsonFields(before)
+	if err != nil {
+		return err
+	}
+
+	af, err := jsonFields(after)
+	if err != nil {
+		return err
+	}
+
+	for k := range bf {
+		if _, ok := af[k]; !ok {
+			delete(obj, k)
+		}
+	}
+
+	for k, v := range af {
+		if !bytes.Equal(bf[k], v) {
+			obj[k] = v
+		}
+	}
+
+	return nil
+}
+
+// patchJSON returns the JSON object b, patched as described for patchFields.
+func patchJSON(b []byte, before, after any) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+
+	if err := patchFields(obj, before, after); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(obj)
+}
+
+// patchDescriptors patches the JSON array of descriptors with the specified key in obj, as
+// described for patchFields. If obj does not contain the key, it is not modified.
+func patchDescriptors(obj map[string]json.RawMessage, key string, before, after []v1.Descriptor) error {
+	b, ok := obj[key]
+	if !ok {
+		return nil
+	}
+
+	var ds []json.RawMessage
+	if err := json.Unmarshal(b, &ds); err != nil {
+		return err
+	}
+
+	if len(ds) != len(before) || len(before) != len(after) {
+		return fmt.Errorf("%w: %v", errDescriptorCountChanged, key)
+	}
+
+	for i := range ds {
+		p, err := patchJSON(ds[i], before[i], after[i])
+		if err != nil {
+			return err
+		}
+		ds[i] = p
+	}
+
+	b, err := json.Marshal(ds)
+	if err != nil {
+		return err
+	}
+	obj[key] = b
+
+	return nil
+}
+
+// patchManifest returns the image manifest b, updated with the changes made between before and
+// after. Rather than re-encoding after, the JSON of b is edited, so fields unknown to v1.Manifest
+// and v1.Descriptor are retained.
+func patchManifest(b []byte, before, after *v1.Manifest) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+
+	if c, ok := obj["config"]; ok {
+		p, err := patchJSON(c, before.Config, after.Config)
+		if err != nil {
+			return nil, err
+		}
+		obj["config"] = p
+	}
+
+	if err := patchDescriptors(obj, "layers", before.Layers, after.Layers); err != nil {
+		return nil, err
+	}
+
+	// Patch the remaining fields, excluding the descriptors patched above.
+	bm, am := *before, *after
+	bm.Config, am.Config = v1.Descriptor{}, v1.Descriptor{}
+	bm.Layers, am.Layers = nil, nil
+
+	if err := patchFields(obj, &bm, &am); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(obj)
+}
+
+// patchIndexManifest returns the index manifest b, updated with the changes made between before
+// and after. Rather than re-encoding after, the JSON of b is edited, so fields unknown to
+// v1.IndexManifest and v1.Descriptor are retained.
+func patchIndexManifest(b []byte, before, after *v1.IndexManifest) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+
+	if err := patchDescriptors(obj, "manifests", before.Manifests, after.Manifests); err != nil {
+		return nil, err
+	}
+
+	// Patch the remaining fields, excluding the descriptors patched above.
+	bm, am := *before, *after
+	bm.Manifests, am.Manifests = nil, nil
+
+	if err := patchFields(obj, &bm, &am); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(obj)
+}
+
+// manifestRewriter rewrites the image manifests reachable from the RootIndex of a SIF image. Where
+// a manifest is modified, the descriptors that reference it are updated, cascading to the
+// RootIndex. Manifests and indexes are edited as JSON, so fields unknown to the v1 types are
+// retained.
+type manifestRewriter struct {
+	f  *fileImage
+	fn func(*v1.Manifest) (bool, error)
+}
+
+// rewriteIndexManifest rewrites the manifests referenced by the descriptors in im. It returns true
+// if any descriptor in im was modified.
+func (mr *manifestRewriter) rewriteIndexManifest(im *v1.IndexManifest) (bool, error) {
+	changed := false
+
+	for i, desc := range im.Manifests {
+		var b []byte
+		var err error
+
+		switch {
+		case desc.MediaType.IsIndex():
+			b, err = mr.rewriteChildIndex(desc)
+		case desc.MediaType.IsImage():
+			b, err = mr.rewriteManifest(desc)
+		default:
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if b == nil {
+			continue
+		}
+
+		h, size, err := v1.SHA256(bytes.NewReader(b))
+		if err != nil {
+			return false, err
+		}
+
+		if err := mr.f.writeBlobToFileImageOnce(h, bytesOpener(b)); err != nil {
+			return false, err
+		}
+
+		im.Manifests[i].Digest = h
+		im.Manifests[i].Size = size
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// rewriteIndex rewrites the index manifest b. If the index is modified, the new content is
+// returned. Otherwise, nil is returned.
+func (mr *manifestRewriter) rewriteIndex(b []byte) ([]byte, error) {
+	var im v1.IndexManifest
+	if err := json.Unmarshal(b, &im); err != nil {
+		return nil, err
+	}
+	before := im.DeepCopy()
+
+	if changed, err := mr.rewriteIndexManifest(&im); err != nil || !changed {
+		return nil, err
+	}
+
+	return patchIndexManifest(b, before, &im)
+}
+
+// rewriteChildIndex rewrites the index referenced by desc. If the index is modified, the new
+// content is returned. Otherwise, nil is returned.
+func (mr *manifestRewriter) rewriteChildIndex(desc v1.Descriptor) ([]byte, error) {
+	b, err := mr.f.Bytes(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return mr.rewriteIndex(b)
+}
+
+// rewriteManifest rewrites the image manifest referenced by desc. If the manifest is modified,
+// the new content is returned. Otherwise, nil is returned.
+func (mr *manifestRewriter) rewriteManifest(desc v1.Descriptor) ([]byte, error) {
+	b, err := mr.f.Bytes(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var m v1.Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	before := m.DeepCopy()
+
+	if changed, err := mr.fn(&m); err != nil || !changed {
+		return nil, err
+	}
+
+	return patchManifest(b, before, &m)
+}
+
+// rewriteManifests applies fn to each image manifest reachable from the RootIndex of f. If fn
+// returns true, the modified manifest is stored, and the descriptors that reference it updated.
+// Blobs that are no longer reachable are removed. fn must not add or remove descriptors.
+func (f *fileImage) rewriteManifests(fn func(*v1.Manifest) (bool, error)) error {
+	d, err := f.GetDescriptor(sif.WithDataType(sif.DataOCIRootIndex))
+	if errors.Is(err, sif.ErrNoObjects) || errors.Is(err, sif.ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := d.GetData()
+	if err != nil {
+		return err
+	}
+
+	mr := manifestRewriter{f: f, fn: fn}
+
+	b, err = mr.rewriteIndex(b)
+	if err != nil || b == nil {
+		return err
+	}
+
+	if err := f.replaceRootIndex(b); err != nil {
+		return err
+	}
+
+	_, err = f.removeUnreachableBlobs()
+	return err
+}
+
+// rewriteManifests locks fi for update, and rewrites its manifests using fn, as described for
+// fileImage.rewriteManifests.
+func rewriteManifests(fi *sif.FileImage, fn func(*v1.Manifest) (bool, error)) error {
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	return f.rewriteManifests(fn)
+}
+
+// StripInlineData removes embedded data from the config and layer descriptors of the image
+// manifests in fi, minimizing their size. Blobs are always resolved by digest, so the images are
+// otherwise unchanged. As manifest digests change, the indexes that reference them, including the
+// RootIndex, are updated.
+//
+// Superseded manifests and indexes are removed from fi, but the space they occupy is not
+// reclaimed. To do so, consider using CompactInPlace.
+//
+// Before fi is modified, StripInlineData checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update.
+func StripInlineData(fi *sif.FileImage) error {
+	return rewriteManifests(fi, func(m *v1.Manifest) (bool, error) {
+		changed := m.Config.Data != nil
+		m.Config.Data = nil
+
+		for i := range m.Layers {
+			if m.Layers[i].Data != nil {
+				m.Layers[i].Data = nil
+				changed = true
+			}
+		}
+
+		return changed, nil
+	})
+}
+
+// InlineConfigData embeds the config of each image manifest in fi within its descriptor, where
+// the size of the config does not exceed maxSize bytes. This is the inverse of StripInlineData.
+// As manifest digests change, the indexes that reference them, including the RootIndex, are
+// updated.
+//
+// Superseded manifests and indexes are removed from fi, but the space they occupy is not
+// reclaimed. To do so, consider using CompactInPlace.
+//
+// Before fi is modified, InlineConfigData checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update.
+func InlineConfigData(fi *sif.FileImage, maxSize int64) error {
+	f := &fileImage{FileImage: fi}
+
+	return rewriteManifests(fi, func(m *v1.Manifest) (bool, error) {
+		if m.Config.Data != nil || m.Config.Size > maxSize {
+			return false, nil
+		}
+
+		b, err := f.Bytes(m.Config.Digest)
+		if err != nil {
+			return false, err
+		}
+
+		m.Config.Data = b
+
+		return true, nil
+	})
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// configDataPresent returns whether the config descriptor of each image in fi embeds data.
+func configDataPresent(tb testing.TB, fi *ssif.FileImage) []bool {
+	tb.Helper()
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := sif.VerifyReferences(fi); err != nil {
+		tb.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var present []bool
+
+	for _, desc := range im.Manifests {
+		img, err := ii.Image(desc.Digest)
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		m, err := img.Manifest()
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		// The config resolves by digest.
+		if _, err := img.ConfigFile(); err != nil {
+			tb.Fatal(err)
+		}
+
+		present = append(present, m.Config.Data != nil)
+	}
+
+	return present
+}
+
+func TestInlineData(t *testing.T) {
+	small := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	smallManifest, err := small.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi := fileImageWithRefs(t,
+		taggedImage{"small:latest", small},
+		taggedImage{"large:latest", corpus.Image(t, "many-layers")},
+	)
+
+	if got := configDataPresent(t, fi); got[0] || got[1] {
+		t.Fatalf("got config data present %v before inlining", got)
+	}
+
+	// Only the config of the smaller image is inlined.
+	if err := sif.InlineConfigData(fi, smallManifest.Config.Size); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := configDataPresent(t, fi); !got[0] || got[1] {
+		t.Fatalf("got config data present %v after inlining", got)
+	}
+
+	if err := sif.StripInlineData(fi); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := configDataPresent(t, fi); got[0] || got[1] {
+		t.Fatalf("got config data present %v after stripping", got)
+	}
+}
+
+// rawManifestImage is a v1.Image with a manifest that includes fields unknown to v1.Manifest.
+type rawManifestImage struct {
+	v1.Image
+	raw []byte
+}
+
+func (img rawManifestImage) RawManifest() ([]byte, error) { return img.raw, nil }
+
+func (img rawManifestImage) Manifest() (*v1.Manifest, error) {
+	return v1.ParseManifest(bytes.NewReader(img.raw))
+}
+
+func (img rawManifestImage) Digest() (v1.Hash, error) {
+	h, _, err := v1.SHA256(bytes.NewReader(img.raw))
+	return h, err
+}
+
+func (img rawManifestImage) Size() (int64, error) { return int64(len(img.raw)), nil }
+
+// withUnknownFields returns img with a manifest that includes fields unknown to v1.Manifest and
+// v1.Descriptor.
+func withUnknownFields(tb testing.TB, img v1.Image) v1.Image {
+	tb.Helper()
+
+	b, err := img.RawManifest()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		tb.Fatal(err)
+	}
+
+	m["artifactType"] = "application/vnd.example.test"
+	m["config"].(map[string]any)["example"] = "config"           //nolint:forcetypeassert // Structure known.
+	m["layers"].([]any)[0].(map[string]any)["example"] = "layer" //nolint:forcetypeassert // Structure known.
+
+	if b, err = json.Marshal(m); err != nil {
+		tb.Fatal(err)
+	}
+
+	return rawManifestImage{img, b}
+}
+
+func TestInlineDataUnknownFields(t *testing.T) {
+	fi := fileImageWithRefs(t,
+		taggedImage{"test:latest", withUnknownFields(t, corpus.Image(t, "hello-world-docker-v2-manifest"))},
+	)
+
+	if err := sif.InlineConfigData(fi, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := configDataPresent(t, fi); !got[0] {
+		t.Fatalf("got config data present %v after inlining", got)
+	}
+
+	if err := sif.StripInlineData(fi); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := configDataPresent(t, fi); got[0] {
+		t.Fatalf("got config data present %v after stripping", got)
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := ii.Image(im.Manifests[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := img.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m struct {
+		ArtifactType string `json:"artifactType"`
+		Config       struct {
+			Example string `json:"example"`
+		} `json:"config"`
+		Layers []struct {
+			Example string `json:"example"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := m.ArtifactType, "application/vnd.example.test"; got != want {
+		t.Errorf("got artifact type %q, want %q", got, want)
+	}
+
+	if got, want := m.Config.Example, "config"; got != want {
+		t.Errorf("got config field %q, want %q", got, want)
+	}
+
+	if got, want := m.Layers[0].Example, "layer"; got != want {
+		t.Errorf("got layer field %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// ImageReport describes an image stored in a SIF, as reported by Inspect.
+type ImageReport struct {
+	ImageInfo
+
+	// Created is the creation time recorded in the image config, if any.
+	Created time.Time
+
+	// Entrypoint, Cmd, Env and Labels are taken from the image config.
+	Entrypoint []string
+	Cmd        []string
+	Env        []string
+	Labels     map[string]string
+
+	// LayerCount is the number of layers in the image.
+	LayerCount int
+
+	// LayerSize is the total size of the layers in the image, as recorded in the image manifest.
+	LayerSize int64
+}
+
+// InspectReport describes the content of a SIF, as reported by Inspect. It is suitable for
+// serialization (e.g. as JSON).
+type InspectReport struct {
+	// RootIndex is the digest of the RootIndex.
+	RootIndex v1.Hash
+
+	// Images describes each image in the SIF, in the order returned by ListImages.
+	Images []ImageReport
+
+	// Space describes the use of space within the SIF, as returned by FragmentationStats.
+	Space SpaceStat
+}
+
+// imageReport returns a report describing img, which is described by e.
+func imageReport(img v1.Image, e ImageEntry) (ImageReport, error) {
+	info, err := imageInfo(img, e)
+	if err != nil {
+		return ImageReport{}, err
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return ImageReport{}, err
+	}
+
+	m, err := img.Manifest()
+	if err != nil {
+		return ImageReport{}, err
+	}
+
+	r := ImageReport{
+		ImageInfo:  info,
+		Created:    cf.Created.Time,
+		Entrypoint: cf.Config.Entrypoint,
+		Cmd:        cf.Config.Cmd,
+		Env:        cf.Config.Env,
+		Labels:     cf.Config.Labels,
+		LayerCount: len(m.Layers),
+	}
+
+	for _, l := range m.Layers {
+		r.LayerSize += l.Size
+	}
+
+	return r, nil
+}
+
+// Inspect returns a report describing the content of fi, including the digest of the RootIndex, a
+// summary of the config and layers of each image, and the use of space within fi. Image manifests
+// and configs are read; layers are not.
+func Inspect(fi *sif.FileImage) (*InspectReport, error) {
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := ii.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	space, err := FragmentationStats(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	r := InspectReport{
+		RootIndex: digest,
+		Space:     space,
+	}
+
+	err = walkImages(ii, "", func(img v1.Image, e ImageEntry) error {
+		ir, err := imageReport(img, e)
+		if err != nil {
+			return err
+		}
+
+		r.Images = append(r.Images, ir)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
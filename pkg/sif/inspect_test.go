@@ -0,0 +1,99 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/sylabs/oci-tools/pkg/sif"
+)
+
+func TestInspect(t *testing.T) {
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	fi := fileImageWithRefs(t, taggedImage{"hello-world:latest", img})
+
+	r, err := sif.Inspect(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ii.Digest(); err != nil {
+		t.Fatal(err)
+	} else if want := r.RootIndex; got != want {
+		t.Errorf("got root index %v, want %v", got, want)
+	}
+
+	space, err := sif.FragmentationStats(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := r.Space, space; got != want {
+		t.Errorf("got space %+v, want %+v", got, want)
+	}
+
+	if got, want := len(r.Images), 1; got != want {
+		t.Fatalf("got %v images, want %v", got, want)
+	}
+
+	ir := r.Images[0]
+
+	if got, want := ir.Ref, "hello-world:latest"; got != want {
+		t.Errorf("got ref %v, want %v", got, want)
+	}
+
+	if got, err := img.Digest(); err != nil {
+		t.Fatal(err)
+	} else if want := ir.Digest; got != want {
+		t.Errorf("got digest %v, want %v", got, want)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := ir.Platform, cf.Platform(); got == nil || !got.Equals(*want) {
+		t.Errorf("got platform %v, want %v", got, want)
+	}
+
+	if got, want := ir.Cmd, []string{"/hello"}; !slices.Equal(got, want) {
+		t.Errorf("got cmd %v, want %v", got, want)
+	}
+
+	if got, want := ir.LayerCount, 1; got != want {
+		t.Errorf("got %v layers, want %v", got, want)
+	}
+
+	if got, want := ir.LayerSize, int64(3208); got != want {
+		t.Errorf("got layer size %v, want %v", got, want)
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got sif.InspectReport
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.RootIndex != r.RootIndex {
+		t.Errorf("got root index %v, want %v", got.RootIndex, r.RootIndex)
+	}
+
+	if got, want := len(got.Images), len(r.Images); got != want {
+		t.Errorf("got %v images, want %v", got, want)
+	}
+}
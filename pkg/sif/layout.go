@@ -0,0 +1,301 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// ExportImageToOCILayout writes the image or index in fi referenced by ref to an OCI Image Layout
+// at dir. Only the referenced content is written. The index.json of the layout contains a single
+// entry, carrying the annotations and platform of the corresponding entry in the RootIndex of fi.
+func ExportImageToOCILayout(fi *sif.FileImage, ref, dir string) error {
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return err
+	}
+
+	desc, err := findReference(ii, ref)
+	if err != nil {
+		return err
+	}
+
+	return exportDescriptor(ii, *desc, dir)
+}
+
+// exportDescriptor writes the image or index referenced by desc, an entry in ii, to an OCI Image
+// Layout at dir.
+func exportDescriptor(ii v1.ImageIndex, desc v1.Descriptor, dir string) error {
+	opts := []layout.Option{
+		layout.WithAnnotations(desc.Annotations),
+	}
+
+	if desc.Platform != nil {
+		opts = append(opts, layout.WithPlatform(*desc.Platform))
+	}
+
+	lp, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		return err
+	}
+
+	switch mt := desc.MediaType; {
+	case mt.IsIndex():
+		ii, err := ii.ImageIndex(desc.Digest)
+		if err != nil {
+			return err
+		}
+
+		return lp.AppendIndex(ii, opts...)
+
+	case mt.IsImage():
+		img, err := ii.Image(desc.Digest)
+		if err != nil {
+			return err
+		}
+
+		return lp.AppendImage(img, opts...)
+
+	default:
+		return fmt.Errorf("%w for %v: %v", errUnexpectedMediaType, desc.Digest, mt)
+	}
+}
+
+// ExtractOCILayout writes the entire OCI content of fi to an OCI Image Layout at dir. The
+// index.json of the layout holds the RootIndex of fi verbatim, and every OCI blob stored in fi is
+// written to the blobs directory of the layout, whether or not it is referenced.
+//
+// Blobs are streamed from fi, so they are not held in memory. The RootIndex is not converted, so
+// where it is a Docker manifest list, so is index.json. To store an OCI image index, consider
+// using Update with OptUpdateForceOCIIndex before extracting.
+func ExtractOCILayout(fi *sif.FileImage, dir string) error {
+	d, err := fi.GetDescriptor(sif.WithDataType(sif.DataOCIRootIndex))
+	if err != nil {
+		return err
+	}
+
+	b, err := d.GetData()
+	if err != nil {
+		return err
+	}
+
+	lp, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		return err
+	}
+
+	ds, err := fi.GetDescriptors(sif.WithDataType(sif.DataOCIBlob))
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return err
+	}
+
+	for _, d := range ds {
+		h, err := d.OCIBlobDigest()
+		if err != nil {
+			return err
+		}
+
+		if err := lp.WriteBlob(h, io.NopCloser(d.GetReader())); err != nil {
+			return err
+		}
+	}
+
+	return lp.WriteFile("index.json", b, 0o644)
+}
+
+// FromOCILayout modifies fi so that it holds the content of the OCI Image Layout at dir. The
+// index.json of the layout becomes the RootIndex of fi, so each of its entries, along with their
+// annotations, is retained. The content of fi is otherwise updated as described for Update, and
+// opts are applied accordingly. In particular, blobs already present in fi (e.g. layers shared
+// with the layout) are not written again.
+func FromOCILayout(fi *sif.FileImage, dir string, opts ...UpdateOpt) error {
+	ii, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		return err
+	}
+
+	return Update(fi, ii, opts...)
+}
+
+// exportAllOpts accumulates ExportAll options.
+type exportAllOpts struct {
+	hardlink bool
+	symlink  bool
+}
+
+// ExportAllOpt are used to specify ExportAll options.
+type ExportAllOpt func(*exportAllOpts) error
+
+// OptExportAllHardlink specifies whether blobs shared between exported layouts are hard linked,
+// rather than copied. Hard linked layouts occupy less space, but must reside on the same
+// filesystem, and modifying a blob in one layout modifies it in all of them.
+func OptExportAllHardlink(b bool) ExportAllOpt {
+	return func(eo *exportAllOpts) error {
+		eo.hardlink = b
+		return nil
+	}
+}
+
+// OptExportAllSymlink specifies whether blobs shared between exported layouts are symbolically
+// linked to the copy in the first layout that contains them, rather than copied. Links are
+// relative, so dir may be moved as a whole, but layouts containing links are not portable
+// individually: copying or archiving a single layout, or removing the layout holding the linked
+// blob, leaves dangling links.
+func OptExportAllSymlink(b bool) ExportAllOpt {
+	return func(eo *exportAllOpts) error {
+		eo.symlink = b
+		return nil
+	}
+}
+
+var (
+	errLayoutNameCollision = errors.New("layout name collision")
+	errConflictingLinkOpts = errors.New("hard and symbolic links are mutually exclusive")
+)
+
+// layoutName returns a directory name for the RootIndex entry desc. The reference of the entry is
+// used, with characters other than letters, digits, '.', '-' and '_' replaced by '_'. If the entry
+// does not have a reference, or the reference is empty, the digest is used. A reference consisting
+// only of '.' characters (e.g. "." or "..") would not name a subdirectory, so each '.' is replaced
+// by '_'.
+func layoutName(desc v1.Descriptor) string {
+	name := desc.Annotations[refNameAnnotation]
+	if name == "" {
+		name = desc.Digest.String()
+	}
+
+	if strings.Trim(name, ".") == "" {
+		return strings.Repeat("_", len(name))
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// symlinkRelative creates newname as a symbolic link to oldname, expressed relative to the
+// directory containing newname.
+func symlinkRelative(oldname, newname string) error {
+	target, err := filepath.Rel(filepath.Dir(newname), oldname)
+	if err != nil {
+		return err
+	}
+
+	return os.Symlink(target, newname)
+}
+
+// linkBlobs links the blobs in refs that are present in blobs into the OCI Image Layout at dir,
+// using link.
+func linkBlobs(
+	dir string, refs map[v1.Hash]v1.Descriptor, blobs map[v1.Hash]string, link func(oldname, newname string) error,
+) error {
+	for h := range refs {
+		src, ok := blobs[h]
+		if !ok {
+			continue
+		}
+
+		dst := filepath.Join(dir, "blobs", h.Algorithm, h.Hex)
+
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+
+		if err := link(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExportAll writes each entry in the RootIndex of fi to its own OCI Image Layout, in a
+// subdirectory of dir. Each subdirectory is named according to the reference of the entry, with
+// characters that are not safe for use in a path replaced by '_'. Entries without a reference, or
+// with an empty reference, are named by digest. The content of each layout is as described for ExportImageToOCILayout.
+//
+// By default, each layout is self-contained, so blobs shared between entries are duplicated. To
+// link shared blobs instead, consider using OptExportAllHardlink or OptExportAllSymlink.
+func ExportAll(fi *sif.FileImage, dir string, opts ...ExportAllOpt) error {
+	eo := exportAllOpts{}
+
+	for _, opt := range opts {
+		if err := opt(&eo); err != nil {
+			return err
+		}
+	}
+
+	var link func(oldname, newname string) error
+
+	switch {
+	case eo.hardlink && eo.symlink:
+		return errConflictingLinkOpts
+	case eo.hardlink:
+		link = os.Link
+	case eo.symlink:
+		link = symlinkRelative
+	}
+
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return err
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(im.Manifests))
+	blobs := make(map[v1.Hash]string)
+
+	for _, desc := range im.Manifests {
+		name := layoutName(desc)
+		if names[name] {
+			return fmt.Errorf("%w: %v", errLayoutNameCollision, name)
+		}
+		names[name] = true
+
+		sub := filepath.Join(dir, name)
+
+		refs := make(map[v1.Hash]v1.Descriptor)
+
+		if link != nil {
+			if err := descriptorBlobs(ii, desc, refs); err != nil {
+				return err
+			}
+
+			if err := linkBlobs(sub, refs, blobs, link); err != nil {
+				return err
+			}
+		}
+
+		if err := exportDescriptor(ii, desc, sub); err != nil {
+			return err
+		}
+
+		for h := range refs {
+			if _, ok := blobs[h]; !ok {
+				blobs[h] = filepath.Join(sub, "blobs", h.Algorithm, h.Hex)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,409 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+)
+
+// layoutBlobs returns the number of blobs in the OCI Image Layout at dir.
+func layoutBlobs(tb testing.TB, dir string) int {
+	tb.Helper()
+
+	des, err := os.ReadDir(filepath.Join(dir, "blobs", "sha256"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return len(des)
+}
+
+func TestExportImageToOCILayout(t *testing.T) {
+	fi := fileImageWithRefs(t,
+		taggedImage{"hello-world:latest", corpus.Image(t, "hello-world-docker-v2-manifest")},
+		taggedImage{"many-layers:latest", corpus.Image(t, "many-layers")},
+	)
+
+	tests := []struct {
+		name       string
+		ref        string
+		wantErr    bool
+		wantDigest v1.Hash
+		wantBlobs  int
+	}{
+		{
+			name: "HelloWorld",
+			ref:  "hello-world:latest",
+			wantDigest: v1.Hash{
+				Algorithm: "sha256",
+				Hex:       "432f982638b3aefab73cc58ab28f5c16e96fdb504e8c134fc58dff4bae8bf338",
+			},
+			wantBlobs: 3,
+		},
+		{
+			name: "ManyLayers",
+			ref:  "many-layers:latest",
+			wantDigest: v1.Hash{
+				Algorithm: "sha256",
+				Hex:       "7c000de5bc837f29d1c9a5e76bba79922d860e5c0f448df3b6fc38431a067c9a",
+			},
+			wantBlobs: 52,
+		},
+		{
+			name:    "NotFound",
+			ref:     "hello-world:missing",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			err := sif.ExportImageToOCILayout(fi, tt.ref, dir)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			ii, err := layout.ImageIndexFromPath(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Index(ii); err != nil {
+				t.Error(err)
+			}
+
+			im, err := ii.IndexManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := len(im.Manifests), 1; got != want {
+				t.Fatalf("got %v manifests, want %v", got, want)
+			}
+
+			if got, want := im.Manifests[0].Digest, tt.wantDigest; got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+
+			if got, want := im.Manifests[0].Annotations["org.opencontainers.image.ref.name"], tt.ref; got != want {
+				t.Errorf("got ref %v, want %v", got, want)
+			}
+
+			if got, want := layoutBlobs(t, dir), tt.wantBlobs; got != want {
+				t.Errorf("got %v blobs, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestExtractOCILayout(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		wantBlobs int
+	}{
+		{
+			name:      "DockerManifest",
+			path:      "hello-world-docker-v2-manifest",
+			wantBlobs: 3,
+		},
+		{
+			name:      "DockerManifestList",
+			path:      "hello-world-docker-v2-manifest-list",
+			wantBlobs: 27,
+		},
+		{
+			name:      "ManyLayers",
+			path:      "many-layers",
+			wantBlobs: 52,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := fileImageFromPath(t, tt.path)
+
+			dir := t.TempDir()
+
+			if err := sif.ExtractOCILayout(fi, dir); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "oci-layout")); err != nil {
+				t.Fatal(err)
+			}
+
+			ii, err := layout.ImageIndexFromPath(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Index(ii); err != nil {
+				t.Fatal(err)
+			}
+
+			// The index matches the RootIndex.
+			rootIndex, err := sif.ImageIndexFromFileImage(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ii.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := rootIndex.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+
+			if got, want := layoutBlobs(t, dir), tt.wantBlobs; got != want {
+				t.Errorf("got %v blobs, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFromOCILayout(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	// Write a layout with multiple annotated manifests.
+	dir := t.TempDir()
+
+	lp, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ti := range []taggedImage{
+		{"hello-world:latest", base},
+		{"hello-world:labeled", labeledImage(t, base, "key", "value")},
+	} {
+		if err := lp.AppendImage(ti.img, layout.WithAnnotations(map[string]string{
+			"org.opencontainers.image.ref.name": ti.ref,
+		})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Import the layout to a SIF that already holds the base image.
+	fi := fileImageWithRefs(t, taggedImage{"hello-world:latest", base})
+
+	var written int
+
+	progress := sif.OptUpdateProgress(func(blobsDone, _ int, _, _ int64) { written = blobsDone })
+
+	if err := sif.FromOCILayout(fi, dir, progress); err != nil {
+		t.Fatal(err)
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ii.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDigest, err := want.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The RootIndex holds index.json verbatim, including annotations.
+	if got != wantDigest {
+		t.Errorf("got digest %v, want %v", got, wantDigest)
+	}
+
+	for _, ref := range []string{"hello-world:latest", "hello-world:labeled"} {
+		if _, err := sif.GetImage(fi, ref); err != nil {
+			t.Errorf("got error %v getting %v", err, ref)
+		}
+	}
+
+	// Only the config and manifest of the labeled image were written.
+	if got, want := written, 2; got != want {
+		t.Errorf("got %v blobs written, want %v", got, want)
+	}
+}
+
+func TestExportAll(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	fi := fileImageWithRefs(t,
+		taggedImage{"docker.io/library/hello-world:latest", base},
+		taggedImage{"hello-world:labeled", labeledImage(t, base, "key", "value")},
+	)
+
+	tests := []struct {
+		name        string
+		opts        []sif.ExportAllOpt
+		wantLinks   bool
+		wantSymlink bool
+	}{
+		{
+			name: "Default",
+		},
+		{
+			name:      "Hardlink",
+			opts:      []sif.ExportAllOpt{sif.OptExportAllHardlink(true)},
+			wantLinks: true,
+		},
+		{
+			name:        "Symlink",
+			opts:        []sif.ExportAllOpt{sif.OptExportAllSymlink(true)},
+			wantLinks:   true,
+			wantSymlink: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			if err := sif.ExportAll(fi, dir, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			names := []string{"docker.io_library_hello-world_latest", "hello-world_labeled"}
+
+			des, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := len(des), len(names); got != want {
+				t.Fatalf("got %v layouts, want %v", got, want)
+			}
+
+			for _, name := range names {
+				ii, err := layout.ImageIndexFromPath(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := validate.Index(ii); err != nil {
+					t.Error(err)
+				}
+
+				if got, want := layoutBlobs(t, filepath.Join(dir, name)), 3; got != want {
+					t.Errorf("got %v blobs in %v, want %v", got, name, want)
+				}
+			}
+
+			// The layer blob is shared between both layouts.
+			ls, err := base.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h, err := ls[0].Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fis []os.FileInfo
+
+			for _, name := range names {
+				fi, err := os.Stat(filepath.Join(dir, name, "blobs", h.Algorithm, h.Hex))
+				if err != nil {
+					t.Fatal(err)
+				}
+				fis = append(fis, fi)
+			}
+
+			if got, want := os.SameFile(fis[0], fis[1]), tt.wantLinks; got != want {
+				t.Errorf("got linked %v, want %v", got, want)
+			}
+
+			// The blob in the second layout is a symbolic link to that in the first.
+			lfi, err := os.Lstat(filepath.Join(dir, names[1], "blobs", h.Algorithm, h.Hex))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := lfi.Mode()&os.ModeSymlink != 0, tt.wantSymlink; got != want {
+				t.Errorf("got symlink %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("ConflictingLinks", func(t *testing.T) {
+		err := sif.ExportAll(fi, t.TempDir(), sif.OptExportAllHardlink(true), sif.OptExportAllSymlink(true))
+		if err == nil {
+			t.Error("got nil error, want error")
+		}
+	})
+}
+
+func TestExportAllUnsafeNames(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	fi := fileImageWithRefs(t,
+		taggedImage{"", base},
+		taggedImage{".", labeledImage(t, base, "key", "dot")},
+		taggedImage{"..", labeledImage(t, base, "key", "dotdot")},
+	)
+
+	dir := filepath.Join(t.TempDir(), "layouts")
+
+	if err := sif.ExportAll(fi, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := base.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"sha256_" + d.Hex, "_", "__"}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(des), len(names); got != want {
+		t.Fatalf("got %v layouts, want %v", got, want)
+	}
+
+	for _, name := range names {
+		ii, err := layout.ImageIndexFromPath(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := validate.Index(ii); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !unix
+
+package sif
+
+import "os"
+
+// lockFile is a no-op on platforms that do not support advisory locking.
+func lockFile(*os.File) (func(), error) {
+	return func() {}, nil
+}
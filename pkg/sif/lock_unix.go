@@ -0,0 +1,42 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build unix
+
+package sif
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile places an exclusive advisory lock on f, without blocking. If another handle holds a
+// lock on f, ErrLocked is returned. The returned function releases the lock.
+func lockFile(f *os.File) (func(), error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var lockErr error
+
+	if err := rc.Control(func(fd uintptr) {
+		lockErr = syscall.Flock(int(fd), syscall.LOCK_EX|syscall.LOCK_NB)
+	}); err != nil {
+		return nil, err
+	}
+
+	if errors.Is(lockErr, syscall.EWOULDBLOCK) {
+		return nil, ErrLocked
+	} else if lockErr != nil {
+		return nil, lockErr
+	}
+
+	return func() {
+		_ = rc.Control(func(fd uintptr) {
+			_ = syscall.Flock(int(fd), syscall.LOCK_UN)
+		})
+	}, nil
+}
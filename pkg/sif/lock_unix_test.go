@@ -0,0 +1,52 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build unix
+
+package sif_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+func TestUpdateLocked(t *testing.T) {
+	path := corpus.SIF(t, "many-layers", sif.OptWriteWithSpareDescriptorCapacity(32))
+
+	// Lock the file via another handle.
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := ssif.LoadContainerFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	err = sif.Update(fi, corpus.ImageIndex(t, "hello-world-docker-v2-manifest-list"))
+	if got, want := err, sif.ErrLocked; !errors.Is(got, want) {
+		t.Fatalf("got error %v, want %v", got, want)
+	}
+
+	// Once the lock is released, the update succeeds.
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.Update(fi, corpus.ImageIndex(t, "hello-world-docker-v2-manifest-list")); err != nil {
+		t.Fatal(err)
+	}
+}
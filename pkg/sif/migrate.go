@@ -0,0 +1,60 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// legacyRefNameAnnotation is the annotation used by some tooling (e.g. containerd) to record the
+// reference of an index entry, in place of refNameAnnotation.
+const legacyRefNameAnnotation = "io.containerd.image.name"
+
+// Migrate upgrades OCI content in fi stored using a legacy scheme to the current scheme, in
+// place. It returns true if fi was modified.
+//
+// Currently, RootIndex entries that record their reference using the
+// "io.containerd.image.name" annotation, rather than the "org.opencontainers.image.ref.name"
+// annotation, are migrated. Entries that record both are not modified.
+//
+// Before fi is modified, Migrate checks that it was opened for writing, and places an exclusive
+// advisory lock on the underlying file, as described for Update.
+func Migrate(fi *sif.FileImage) (bool, error) {
+	if ok, err := HasOCI(fi); err != nil || !ok {
+		return false, err
+	}
+
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	im, err := f.rootIndexManifest()
+	if err != nil {
+		return false, err
+	}
+
+	migrated := false
+
+	for i, desc := range im.Manifests {
+		if _, ok := desc.Annotations[refNameAnnotation]; ok {
+			continue
+		}
+
+		if ref, ok := desc.Annotations[legacyRefNameAnnotation]; ok {
+			im.Manifests[i].Annotations[refNameAnnotation] = ref
+			migrated = true
+		}
+	}
+
+	if !migrated {
+		return false, nil
+	}
+
+	return true, f.writeRootIndex(im)
+}
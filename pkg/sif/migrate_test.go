@@ -0,0 +1,99 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	ggcrmutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// legacyFileImage returns a temporary FileImage for the test to use, containing images with
+// references recorded using the legacy "io.containerd.image.name" annotation. The FileImage is
+// automatically unloaded when the test and all its subtests complete.
+func legacyFileImage(tb testing.TB) *ssif.FileImage {
+	tb.Helper()
+
+	ii := ggcrmutate.AppendManifests(empty.Index,
+		ggcrmutate.IndexAddendum{
+			Add: corpus.Image(tb, "hello-world-docker-v2-manifest"),
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{
+					"io.containerd.image.name": "hello-world:latest",
+				},
+			},
+		},
+		ggcrmutate.IndexAddendum{
+			Add: corpus.Image(tb, "many-layers"),
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{
+					"io.containerd.image.name":          "many-layers:legacy",
+					"org.opencontainers.image.ref.name": "many-layers:latest",
+				},
+			},
+		},
+	)
+
+	path := filepath.Join(tb.TempDir(), "image.sif")
+
+	if err := sif.Write(path, ii, sif.OptWriteWithSpareDescriptorCapacity(1)); err != nil {
+		tb.Fatal(err)
+	}
+
+	fi, err := ssif.LoadContainerFromPath(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	return fi
+}
+
+func TestMigrate(t *testing.T) {
+	fi := legacyFileImage(t)
+
+	if _, err := sif.GetImage(fi, "hello-world:latest"); err == nil {
+		t.Fatal("got nil error getting legacy reference before migration")
+	}
+
+	if migrated, err := sif.Migrate(fi); err != nil {
+		t.Fatal(err)
+	} else if !migrated {
+		t.Error("got no migration of legacy SIF")
+	}
+
+	if _, err := sif.GetImage(fi, "hello-world:latest"); err != nil {
+		t.Errorf("got error %v getting migrated reference", err)
+	}
+
+	// Entries with a current reference are not modified.
+	if _, err := sif.GetImage(fi, "many-layers:latest"); err != nil {
+		t.Errorf("got error %v getting current reference", err)
+	}
+
+	if err := sif.VerifyReferences(fi); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migration is idempotent.
+	if migrated, err := sif.Migrate(fi); err != nil {
+		t.Fatal(err)
+	} else if migrated {
+		t.Error("got migration of current SIF")
+	}
+}
+
+func TestMigrateNoOCI(t *testing.T) {
+	if migrated, err := sif.Migrate(emptyFileImage(t, 1)); err != nil {
+		t.Fatal(err)
+	} else if migrated {
+		t.Error("got migration of SIF without OCI content")
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"errors"
+	"os"
+
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// openOpts accumulates open options.
+type openOpts struct {
+	readOnly bool
+	buffer   bool
+}
+
+// OpenOpt are used to specify open options.
+type OpenOpt func(*openOpts) error
+
+// OptOpenReadOnly specifies that the image is opened read-only. Operations that modify the image,
+// such as Update, return ErrReadOnly.
+func OptOpenReadOnly() OpenOpt {
+	return func(oo *openOpts) error {
+		oo.readOnly = true
+		return nil
+	}
+}
+
+// OptOpenBuffer specifies that the content of the image is read into memory, and the image is
+// operated on there. Modifications to the image are not written back to the file.
+func OptOpenBuffer() OpenOpt {
+	return func(oo *openOpts) error {
+		oo.buffer = true
+		return nil
+	}
+}
+
+var errBufferReadOnly = errors.New("buffer is read-only")
+
+// readOnlyBuffer wraps a buffer, rejecting modifications.
+type readOnlyBuffer struct {
+	*sif.Buffer
+}
+
+func (b readOnlyBuffer) Write([]byte) (int, error) {
+	return 0, errBufferReadOnly
+}
+
+func (b readOnlyBuffer) Truncate(int64) error {
+	return errBufferReadOnly
+}
+
+// OpenImage opens the SIF image at path. By default, the image is opened for reading and writing.
+// To open the image read-only, consider using OptOpenReadOnly. To operate on the image in memory,
+// consider using OptOpenBuffer.
+//
+// The caller is responsible for calling UnloadContainer on the returned image.
+func OpenImage(path string, opts ...OpenOpt) (*sif.FileImage, error) {
+	oo := openOpts{}
+
+	for _, opt := range opts {
+		if err := opt(&oo); err != nil {
+			return nil, err
+		}
+	}
+
+	if !oo.buffer {
+		flag := os.O_RDWR
+		if oo.readOnly {
+			flag = os.O_RDONLY
+		}
+
+		return sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(flag))
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rw sif.ReadWriter = sif.NewBuffer(b)
+	if oo.readOnly {
+		rw = readOnlyBuffer{sif.NewBuffer(b)}
+	}
+
+	return sif.LoadContainer(rw)
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/sylabs/oci-tools/pkg/sif"
+)
+
+func TestOpenImage(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []sif.OpenOpt
+		wantErr      error
+		wantModified bool
+	}{
+		{
+			name:         "Default",
+			wantModified: true,
+		},
+		{
+			name:    "ReadOnly",
+			opts:    []sif.OpenOpt{sif.OptOpenReadOnly()},
+			wantErr: sif.ErrReadOnly,
+		},
+		{
+			name: "Buffer",
+			opts: []sif.OpenOpt{sif.OptOpenBuffer()},
+		},
+		{
+			name:    "BufferReadOnly",
+			opts:    []sif.OpenOpt{sif.OptOpenBuffer(), sif.OptOpenReadOnly()},
+			wantErr: sif.ErrReadOnly,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := corpus.SIF(t, "many-layers")
+
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fi, err := sif.OpenImage(path, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+			err = sif.Update(fi, corpus.ImageIndex(t, "many-layers"), sif.OptUpdateForceOCIIndex())
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := !bytes.Equal(before, after), tt.wantModified; got != want {
+				t.Errorf("got modified %v, want %v", got, want)
+			}
+		})
+	}
+}
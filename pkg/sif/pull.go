@@ -0,0 +1,96 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// refNameAnnotation is the annotation used to associate a reference with a RootIndex entry.
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// StreamPull fetches the image or index referenced by ref from a remote registry, and writes it to
+// fi. Blobs are streamed from the registry directly into fi as they are downloaded, and their
+// digests are verified as they are read. Blobs already present in fi, such as layers shared with
+// previously pulled images, are not fetched from the registry.
+//
+// Once all blobs have been written, the RootIndex of fi is updated to reference the pulled content
+// via a descriptor annotated with ref. Any existing RootIndex entry annotated with ref is replaced.
+// If fi does not contain a RootIndex, one is created. However, as described for Update, fi must
+// contain at least one object so that it can be locked; if it contains none, an error is returned.
+// To create a SIF suitable for pulling into, consider using CreateEmpty.
+//
+// The caller must ensure fi has sufficient spare descriptor capacity to hold the pulled blobs. To
+// create a SIF with spare descriptor capacity, consider using OptWriteWithSpareDescriptorCapacity.
+//
+// Before fi is modified, StreamPull checks that it was opened for writing, and places an exclusive
+// advisory lock on the underlying file, as described for Update.
+func StreamPull(fi *sif.FileImage, ref string, opts ...remote.Option) error {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	desc, err := remote.Get(r, opts...)
+	if err != nil {
+		return err
+	}
+
+	f := fileImage{FileImage: fi}
+
+	switch mt := desc.MediaType; {
+	case mt.IsIndex():
+		ii, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+
+		if err := f.writeIndexToFileImage(ii, false); err != nil {
+			return err
+		}
+
+	case mt.IsImage():
+		img, err := desc.Image()
+		if err != nil {
+			return err
+		}
+
+		if err := f.writeImageToFileImage(img); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("%w for %v: %v", errUnexpectedMediaType, ref, mt)
+	}
+
+	im, err := f.rootIndexManifest()
+	if err != nil {
+		return err
+	}
+
+	d := desc.Descriptor
+	d.Annotations = map[string]string{refNameAnnotation: ref}
+
+	manifests := make([]v1.Descriptor, 0, len(im.Manifests)+1)
+	for _, m := range im.Manifests {
+		if m.Annotations[refNameAnnotation] != ref {
+			manifests = append(manifests, m)
+		}
+	}
+	im.Manifests = append(manifests, d)
+
+	return f.writeRootIndex(im)
+}
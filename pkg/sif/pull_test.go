@@ -0,0 +1,315 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	ggcrmutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// newRegistry starts a local registry for the test to use, populated with the
+// "hello-world-docker-v2-manifest" image as "hello-world:image", and the
+// "hello-world-docker-v2-manifest-list" index as "hello-world:index". The registry is
+// automatically shut down when the test and all its subtests complete.
+func newRegistry(tb testing.TB) string {
+	tb.Helper()
+
+	s := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	tb.Cleanup(s.Close)
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	imageRef, err := name.ParseReference(u.Host + "/hello-world:image")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := remote.Write(imageRef, corpus.Image(tb, "hello-world-docker-v2-manifest")); err != nil {
+		tb.Fatal(err)
+	}
+
+	indexRef, err := name.ParseReference(u.Host + "/hello-world:index")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := remote.WriteIndex(indexRef, corpus.ImageIndex(tb, "hello-world-docker-v2-manifest-list")); err != nil {
+		tb.Fatal(err)
+	}
+
+	return u.Host
+}
+
+// emptyFileImage returns a temporary FileImage holding an empty RootIndex, with capacity for n
+// further descriptors. The FileImage is automatically unloaded when the test and all its subtests
+// complete.
+func emptyFileImage(tb testing.TB, n int64) *ssif.FileImage {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "image.sif")
+
+	if err := sif.CreateEmpty(path, sif.OptWriteWithSpareDescriptorCapacity(n)); err != nil {
+		tb.Fatal(err)
+	}
+
+	fi, err := ssif.LoadContainerFromPath(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	return fi
+}
+
+func TestStreamPull(t *testing.T) {
+	host := newRegistry(t)
+
+	tests := []struct {
+		name        string
+		refs        []string
+		wantDigests []v1.Hash
+	}{
+		{
+			name: "Image",
+			refs: []string{host + "/hello-world:image"},
+			wantDigests: []v1.Hash{
+				{Algorithm: "sha256", Hex: "432f982638b3aefab73cc58ab28f5c16e96fdb504e8c134fc58dff4bae8bf338"},
+			},
+		},
+		{
+			name: "Index",
+			refs: []string{host + "/hello-world:index"},
+			wantDigests: []v1.Hash{
+				{Algorithm: "sha256", Hex: "00e1ee7c898a2c393ea2fe7680938f8dcbe55e51fbf08032cf37326a677f92ed"},
+			},
+		},
+		{
+			name: "ImageAndIndex",
+			refs: []string{host + "/hello-world:image", host + "/hello-world:index"},
+			wantDigests: []v1.Hash{
+				{Algorithm: "sha256", Hex: "432f982638b3aefab73cc58ab28f5c16e96fdb504e8c134fc58dff4bae8bf338"},
+				{Algorithm: "sha256", Hex: "00e1ee7c898a2c393ea2fe7680938f8dcbe55e51fbf08032cf37326a677f92ed"},
+			},
+		},
+		{
+			name: "Repeated",
+			refs: []string{host + "/hello-world:image", host + "/hello-world:image"},
+			wantDigests: []v1.Hash{
+				{Algorithm: "sha256", Hex: "432f982638b3aefab73cc58ab28f5c16e96fdb504e8c134fc58dff4bae8bf338"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := emptyFileImage(t, 64)
+
+			for _, ref := range tt.refs {
+				if err := sif.StreamPull(fi, ref); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ii, err := sif.ImageIndexFromFileImage(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Index(ii); err != nil {
+				t.Error(err)
+			}
+
+			im, err := ii.IndexManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := len(im.Manifests), len(tt.wantDigests); got != want {
+				t.Fatalf("got %v manifests, want %v", got, want)
+			}
+
+			for i, d := range im.Manifests {
+				if got, want := d.Digest, tt.wantDigests[i]; got != want {
+					t.Errorf("got digest %v, want %v", got, want)
+				}
+
+				if got, want := d.Annotations["org.opencontainers.image.ref.name"], tt.refs[i]; got != want {
+					t.Errorf("got ref %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+// blobCounter is an http.Handler that counts the blob requests made to a registry.
+type blobCounter struct {
+	h http.Handler
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (bc *blobCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/blobs/") {
+		bc.mu.Lock()
+		bc.counts[path.Base(r.URL.Path)]++
+		bc.mu.Unlock()
+	}
+	bc.h.ServeHTTP(w, r)
+}
+
+func TestStreamPullDedup(t *testing.T) {
+	bc := &blobCounter{
+		h:      registry.New(registry.Logger(log.New(io.Discard, "", 0))),
+		counts: make(map[string]int),
+	}
+
+	s := httptest.NewServer(bc)
+	t.Cleanup(s.Close)
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Image B shares the layers of image A, with an additional layer.
+	a := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	l, err := random.Layer(64, types.DockerLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ggcrmutate.AppendLayers(a, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refA := u.Host + "/test:a"
+	refB := u.Host + "/test:b"
+
+	for ref, img := range map[string]v1.Image{refA: a, refB: b} {
+		r, err := name.ParseReference(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := remote.Write(r, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fi := emptyFileImage(t, 64)
+
+	if err := sif.StreamPull(fi, refA); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.mu.Lock()
+	clear(bc.counts)
+	bc.mu.Unlock()
+
+	if err := sif.StreamPull(fi, refB); err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err := a.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range ls {
+		h, err := l.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := bc.counts[h.String()]; got != 0 {
+			t.Errorf("got %v requests for shared layer %v, want 0", got, h)
+		}
+	}
+
+	h, err := l.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := bc.counts[h.String()], 1; got != want {
+		t.Errorf("got %v requests for new layer %v, want %v", got, h, want)
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkStreamPull(b *testing.B) {
+	ref := newRegistry(b) + "/hello-world:index"
+
+	b.Run("Stream", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			fi := emptyFileImage(b, 64)
+
+			if err := sif.StreamPull(fi, ref); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Cached", func(b *testing.B) {
+		r, err := name.ParseReference(ref)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for i := 0; i < b.N; i++ {
+			ii, err := remote.Index(r)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			lp, err := layout.Write(b.TempDir(), ii)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			ii, err = lp.ImageIndex()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			if err := sif.Write(filepath.Join(b.TempDir(), "image.sif"), ii); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
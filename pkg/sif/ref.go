@@ -0,0 +1,550 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+var (
+	errReferenceNotFound = errors.New("reference not found in index")
+	errPlatformNotFound  = errors.New("platform not found in index")
+
+	// errStopWalk is returned by a walkImages callback to stop the walk early.
+	errStopWalk = errors.New("stop walk")
+)
+
+// findReference returns the descriptor in ii annotated with ref.
+func findReference(ii v1.ImageIndex, ref string) (*v1.Descriptor, error) {
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, desc := range im.Manifests {
+		if desc.Annotations[refNameAnnotation] == ref {
+			return &desc, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %v", errReferenceNotFound, ref)
+}
+
+// GetImage returns the image in fi referenced by ref. The ref must match the
+// "org.opencontainers.image.ref.name" annotation of an image manifest in the RootIndex of fi.
+func GetImage(fi *sif.FileImage, ref string) (v1.Image, error) {
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := findReference(ii, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return ii.Image(desc.Digest)
+}
+
+// GetImageIndex returns the index in fi referenced by ref. The ref must match the
+// "org.opencontainers.image.ref.name" annotation of an index (e.g. a multi-platform manifest list)
+// in the RootIndex of fi. Content is read from fi as required.
+func GetImageIndex(fi *sif.FileImage, ref string) (v1.ImageIndex, error) {
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := findReference(ii, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if !desc.MediaType.IsIndex() {
+		return nil, fmt.Errorf("%w for %v: %v", errUnexpectedMediaType, ref, desc.MediaType)
+	}
+
+	return ii.ImageIndex(desc.Digest)
+}
+
+// ConfigOf returns the config of the image in fi referenced by ref. Layers of the image are not
+// read.
+func ConfigOf(fi *sif.FileImage, ref string) (*v1.ConfigFile, error) {
+	img, err := GetImage(fi, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return img.ConfigFile()
+}
+
+// ImageEntry describes an image stored in a SIF.
+type ImageEntry struct {
+	// Ref is the reference associated with the image via the "org.opencontainers.image.ref.name"
+	// annotation in the RootIndex. For an image within a nested index, Ref is the reference
+	// associated with the top-level index.
+	Ref string
+
+	// Descriptor is the descriptor of the image manifest.
+	Descriptor v1.Descriptor
+}
+
+// walkImages calls fn for each image reachable from ii, descending into nested indexes. Images
+// directly within ii are associated with the reference in their descriptor annotations, unless ref
+// is non-empty.
+func walkImages(ii v1.ImageIndex, ref string, fn func(v1.Image, ImageEntry) error) error {
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range im.Manifests {
+		r := ref
+		if r == "" {
+			r = desc.Annotations[refNameAnnotation]
+		}
+
+		switch mt := desc.MediaType; {
+		case mt.IsIndex():
+			child, err := ii.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+
+			if err := walkImages(child, r, fn); err != nil {
+				return err
+			}
+
+		case mt.IsImage():
+			img, err := ii.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+
+			if err := fn(img, ImageEntry{Ref: r, Descriptor: desc}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// FindImagesByLabel returns the images in fi with a config label key set to value. Only image
+// configs are read; layers are not. Where the label was recorded in the RootIndex entry of an
+// image (see OptUpdatePromoteLabels), the config of that image is not read.
+func FindImagesByLabel(fi *sif.FileImage, key, value string) ([]ImageEntry, error) {
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	var es []ImageEntry
+
+	err = walkImages(ii, "", func(img v1.Image, e ImageEntry) error {
+		if v, ok := e.Descriptor.Annotations[LabelAnnotationPrefix+key]; ok {
+			if v == value {
+				es = append(es, e)
+			}
+			return nil
+		}
+
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return err
+		}
+
+		if v, ok := cf.Config.Labels[key]; ok && v == value {
+			es = append(es, e)
+		}
+
+		return nil
+	})
+
+	return es, err
+}
+
+// ImageInfo summarizes an image stored in a SIF.
+type ImageInfo struct {
+	// Digest is the digest of the image manifest.
+	Digest v1.Hash
+
+	// MediaType is the media type of the image manifest.
+	MediaType types.MediaType
+
+	// Platform is the platform of the image. It is taken from the descriptor of the image where
+	// present, and otherwise from the image config. If neither specify a platform, it is nil.
+	Platform *v1.Platform
+
+	// Ref is the reference associated with the image, as described for ImageEntry.
+	Ref string
+}
+
+// imageInfo returns a summary of img, which is described by e.
+func imageInfo(img v1.Image, e ImageEntry) (ImageInfo, error) {
+	platform := e.Descriptor.Platform
+	if platform == nil {
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return ImageInfo{}, err
+		}
+
+		platform = cf.Platform()
+	}
+
+	return ImageInfo{
+		Digest:    e.Descriptor.Digest,
+		MediaType: e.Descriptor.MediaType,
+		Platform:  platform,
+		Ref:       e.Ref,
+	}, nil
+}
+
+// ListImages returns a summary of each image in fi, descending into nested indexes. Images are
+// listed in the order they appear in the RootIndex. Layers are not read, and image configs are
+// read only where the descriptor of an image does not specify its platform.
+func ListImages(fi *sif.FileImage) ([]ImageInfo, error) {
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ImageInfo
+
+	err = walkImages(ii, "", func(img v1.Image, e ImageEntry) error {
+		info, err := imageInfo(img, e)
+		if err != nil {
+			return err
+		}
+
+		infos = append(infos, info)
+
+		return nil
+	})
+
+	return infos, err
+}
+
+// ExtractPlatform returns the first image in fi for platform p, descending into nested indexes.
+// The platform of each image is determined as described for ImageInfo. Platforms must match
+// exactly, including variant and OS version. If no image matches, the returned error lists the
+// platforms that are available.
+func ExtractPlatform(fi *sif.FileImage, p v1.Platform) (v1.Image, error) {
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		match     v1.Image
+		available []string
+	)
+
+	err = walkImages(ii, "", func(img v1.Image, e ImageEntry) error {
+		info, err := imageInfo(img, e)
+		if err != nil {
+			return err
+		}
+
+		if info.Platform == nil {
+			return nil
+		}
+
+		if info.Platform.Equals(p) {
+			match = img
+			return errStopWalk
+		}
+
+		available = append(available, info.Platform.String())
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, err
+	}
+
+	if match == nil {
+		return nil, fmt.Errorf("%w: %v (available: %v)", errPlatformNotFound, p, strings.Join(available, ", "))
+	}
+
+	return match, nil
+}
+
+// isAttestation returns true if desc describes an attestation manifest, rather than a runnable
+// image. Attestation manifests (e.g. those produced by BuildKit) are recorded with an unknown
+// platform.
+func isAttestation(desc v1.Descriptor) bool {
+	if desc.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+		return true
+	}
+
+	p := desc.Platform
+	return p != nil && p.OS == "unknown" && p.Architecture == "unknown"
+}
+
+// PlatformsForRef returns the platforms of the images in fi referenced by ref. Where ref
+// references an index, the platform of each image within it is returned, descending into nested
+// indexes. Where ref references an image, a single platform is returned. The platform of each
+// image is determined as described for ImageInfo. Attestation manifests, and images without a
+// platform, are excluded.
+func PlatformsForRef(fi *sif.FileImage, ref string) ([]v1.Platform, error) {
+	ii, err := ImageIndexFromFileImage(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := findReference(ii, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var ps []v1.Platform
+
+	add := func(img v1.Image, e ImageEntry) error {
+		if isAttestation(e.Descriptor) {
+			return nil
+		}
+
+		info, err := imageInfo(img, e)
+		if err != nil {
+			return err
+		}
+
+		if info.Platform != nil {
+			ps = append(ps, *info.Platform)
+		}
+
+		return nil
+	}
+
+	switch mt := desc.MediaType; {
+	case mt.IsIndex():
+		child, err := ii.ImageIndex(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := walkImages(child, ref, add); err != nil {
+			return nil, err
+		}
+
+	case mt.IsImage():
+		img, err := ii.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := add(img, ImageEntry{Ref: ref, Descriptor: *desc}); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("%w for %v: %v", errUnexpectedMediaType, ref, mt)
+	}
+
+	return ps, nil
+}
+
+// PromoteToIndex wraps the image in fi referenced by ref in an index containing the image as its
+// only child, and updates ref to reference the index. This allows sibling images (for example,
+// for other platforms) to be added to the index in future. If ref already references an index, fi
+// is not modified.
+//
+// Before fi is modified, PromoteToIndex checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update.
+func PromoteToIndex(fi *sif.FileImage, ref string) error {
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	ii, err := f.ImageIndex()
+	if err != nil {
+		return err
+	}
+
+	desc, err := findReference(ii, ref)
+	if err != nil {
+		return err
+	}
+
+	if desc.MediaType.IsIndex() {
+		return nil
+	}
+
+	img, err := ii.Image(desc.Digest)
+	if err != nil {
+		return err
+	}
+
+	// Record the platform of the image in the child descriptor, so it can be distinguished from
+	// any siblings added later.
+	platform := desc.Platform
+	if platform == nil {
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return err
+		}
+
+		platform = cf.Platform()
+	}
+
+	child := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add: img,
+		Descriptor: v1.Descriptor{
+			Platform: platform,
+		},
+	})
+
+	if err := f.writeIndexToFileImage(child, false); err != nil {
+		return err
+	}
+
+	d, err := partial.Descriptor(child)
+	if err != nil {
+		return err
+	}
+	d.Annotations = desc.Annotations
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for i, m := range im.Manifests {
+		if m.Annotations[refNameAnnotation] == ref {
+			im.Manifests[i] = *d
+		}
+	}
+
+	return f.writeRootIndex(im)
+}
+
+var errNegativeCount = errors.New("count must not be negative")
+
+// TrimTo retains the n most recent references in the RootIndex of fi, and removes the rest. The
+// references are ordered by newer, which reports whether a is more recent than b. The entries
+// passed to newer describe the RootIndex descriptors, which may reference an index rather than an
+// image. RootIndex entries without a reference are retained.
+//
+// Blobs that are no longer reachable from the RootIndex are removed from fi. The space they
+// occupy is not reclaimed; to do so, consider using CompactInPlace.
+//
+// Before fi is modified, TrimTo checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update.
+func TrimTo(fi *sif.FileImage, n int, newer func(a, b ImageEntry) bool) error {
+	if n < 0 {
+		return fmt.Errorf("%w: %v", errNegativeCount, n)
+	}
+
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	im, err := f.rootIndexManifest()
+	if err != nil {
+		return err
+	}
+
+	// Gather the positions of referenced entries, ordered most recent first.
+	var tagged []int
+	for i, desc := range im.Manifests {
+		if _, ok := desc.Annotations[refNameAnnotation]; ok {
+			tagged = append(tagged, i)
+		}
+	}
+
+	if len(tagged) <= n {
+		return nil
+	}
+
+	entry := func(i int) ImageEntry {
+		desc := im.Manifests[i]
+		return ImageEntry{Ref: desc.Annotations[refNameAnnotation], Descriptor: desc}
+	}
+
+	sort.SliceStable(tagged, func(i, j int) bool {
+		return newer(entry(tagged[i]), entry(tagged[j]))
+	})
+
+	removed := make(map[int]bool, len(tagged)-n)
+	for _, i := range tagged[n:] {
+		removed[i] = true
+	}
+
+	manifests := make([]v1.Descriptor, 0, len(im.Manifests)-len(removed))
+	for i, desc := range im.Manifests {
+		if !removed[i] {
+			manifests = append(manifests, desc)
+		}
+	}
+	im.Manifests = manifests
+
+	if err := f.writeRootIndex(im); err != nil {
+		return err
+	}
+
+	_, err = f.removeUnreachableBlobs()
+	return err
+}
+
+var errManifestNotFound = errors.New("manifest not found in index")
+
+// RemoveImage removes the entries in the RootIndex of fi that reference the manifest with digest h.
+// Blobs that are no longer reachable from the RootIndex are then removed from fi. The space they
+// occupy is not reclaimed; to do so, consider using CompactInPlace.
+//
+// If the last entry is removed, fi is left with an empty RootIndex. If the RootIndex does not
+// contain an entry referencing h, an error is returned.
+func RemoveImage(fi *sif.FileImage, h v1.Hash) error {
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	im, err := f.rootIndexManifest()
+	if err != nil {
+		return err
+	}
+
+	manifests := make([]v1.Descriptor, 0, len(im.Manifests))
+	for _, desc := range im.Manifests {
+		if desc.Digest != h {
+			manifests = append(manifests, desc)
+		}
+	}
+
+	if len(manifests) == len(im.Manifests) {
+		return fmt.Errorf("%w: %v", errManifestNotFound, h)
+	}
+	im.Manifests = manifests
+
+	if err := f.writeRootIndex(im); err != nil {
+		return err
+	}
+
+	_, err = f.removeUnreachableBlobs()
+	return err
+}
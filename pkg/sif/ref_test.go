@@ -0,0 +1,733 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	ggcrmutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// taggedImage is an image to be stored in a SIF with the specified reference.
+type taggedImage struct {
+	ref string
+	img v1.Image
+}
+
+// fileImageWithRefs returns a temporary FileImage for the test to use, containing each of the
+// supplied images annotated with its reference. The FileImage is automatically unloaded when the
+// test and all its subtests complete.
+func fileImageWithRefs(tb testing.TB, tis ...taggedImage) *ssif.FileImage {
+	tb.Helper()
+
+	var ii v1.ImageIndex = empty.Index
+	for _, ti := range tis {
+		ii = ggcrmutate.AppendManifests(ii, ggcrmutate.IndexAddendum{
+			Add: ti.img,
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{
+					"org.opencontainers.image.ref.name": ti.ref,
+				},
+			},
+		})
+	}
+
+	path := filepath.Join(tb.TempDir(), "image.sif")
+
+	if err := sif.Write(path, ii, sif.OptWriteWithSpareDescriptorCapacity(16)); err != nil {
+		tb.Fatal(err)
+	}
+
+	fi, err := ssif.LoadContainerFromPath(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	return fi
+}
+
+func TestGetImageIndex(t *testing.T) {
+	list := corpus.ImageIndex(t, "hello-world-docker-v2-manifest-list")
+
+	ii := ggcrmutate.AppendManifests(empty.Index,
+		ggcrmutate.IndexAddendum{
+			Add: list,
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{"org.opencontainers.image.ref.name": "hello-world:list"},
+			},
+		},
+		ggcrmutate.IndexAddendum{
+			Add: corpus.Image(t, "hello-world-docker-v2-manifest"),
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{"org.opencontainers.image.ref.name": "hello-world:image"},
+			},
+		},
+	)
+
+	path := filepath.Join(t.TempDir(), "image.sif")
+
+	if err := sif.Write(path, ii); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := ssif.LoadContainerFromPath(path, ssif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	t.Run("List", func(t *testing.T) {
+		got, err := sif.GetImageIndex(fi, "hello-world:list")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := validate.Index(got); err != nil {
+			t.Fatal(err)
+		}
+
+		gotIM, err := got.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantIM, err := list.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := len(gotIM.Manifests), len(wantIM.Manifests); got != want {
+			t.Fatalf("got %v manifests, want %v", got, want)
+		}
+
+		for i, desc := range gotIM.Manifests {
+			if got, want := desc.Platform, wantIM.Manifests[i].Platform; got == nil || !got.Equals(*want) {
+				t.Errorf("got platform %v, want %v", got, want)
+			}
+		}
+	})
+
+	t.Run("Image", func(t *testing.T) {
+		if _, err := sif.GetImageIndex(fi, "hello-world:image"); err == nil {
+			t.Fatal("got nil error, want error")
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		if _, err := sif.GetImageIndex(fi, "hello-world:missing"); err == nil {
+			t.Fatal("got nil error, want error")
+		}
+	})
+}
+
+func TestConfigOf(t *testing.T) {
+	fi := fileImageWithRefs(t,
+		taggedImage{"hello-world:latest", corpus.Image(t, "hello-world-docker-v2-manifest")},
+		taggedImage{"many-layers:latest", corpus.Image(t, "many-layers")},
+	)
+
+	tests := []struct {
+		name    string
+		ref     string
+		wantErr bool
+		wantEnv []string
+		wantCmd []string
+	}{
+		{
+			name:    "HelloWorld",
+			ref:     "hello-world:latest",
+			wantEnv: []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+			wantCmd: []string{"/hello"},
+		},
+		{
+			name: "ManyLayers",
+			ref:  "many-layers:latest",
+		},
+		{
+			name:    "NotFound",
+			ref:     "hello-world:missing",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, err := sif.ConfigOf(fi, tt.ref)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err == nil {
+				if got, want := cf.Config.Env, tt.wantEnv; !slices.Equal(got, want) {
+					t.Errorf("got env %v, want %v", got, want)
+				}
+
+				if got, want := cf.Config.Cmd, tt.wantCmd; !slices.Equal(got, want) {
+					t.Errorf("got cmd %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+// labeledImage returns img, with the config label key set to value.
+func labeledImage(tb testing.TB, img v1.Image, key, value string) v1.Image {
+	tb.Helper()
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	c := cf.Config.DeepCopy()
+	c.Labels = map[string]string{key: value}
+
+	img, err = ggcrmutate.Config(img, *c)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return img
+}
+
+func TestFindImagesByLabel(t *testing.T) {
+	fi := fileImageWithRefs(t,
+		taggedImage{"a:latest", labeledImage(t, corpus.Image(t, "hello-world-docker-v2-manifest"), "maintainer", "foo")},
+		taggedImage{"b:latest", labeledImage(t, corpus.Image(t, "hello-world-docker-v2-manifest"), "maintainer", "bar")},
+		taggedImage{"c:latest", labeledImage(t, corpus.Image(t, "many-layers"), "maintainer", "foo")},
+		taggedImage{"d:latest", corpus.Image(t, "many-layers")},
+	)
+
+	tests := []struct {
+		name     string
+		key      string
+		value    string
+		wantRefs []string
+	}{
+		{
+			name:     "Foo",
+			key:      "maintainer",
+			value:    "foo",
+			wantRefs: []string{"a:latest", "c:latest"},
+		},
+		{
+			name:     "Bar",
+			key:      "maintainer",
+			value:    "bar",
+			wantRefs: []string{"b:latest"},
+		},
+		{
+			name:  "NoMatch",
+			key:   "maintainer",
+			value: "baz",
+		},
+		{
+			name:  "EmptyValue",
+			key:   "maintainer",
+			value: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es, err := sif.FindImagesByLabel(fi, tt.key, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var refs []string
+			for _, e := range es {
+				refs = append(refs, e.Ref)
+			}
+
+			if got, want := refs, tt.wantRefs; !slices.Equal(got, want) {
+				t.Errorf("got refs %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPromoteToIndex(t *testing.T) {
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	fi := fileImageWithRefs(t,
+		taggedImage{"hello-world:latest", img},
+		taggedImage{"many-layers:latest", corpus.Image(t, "many-layers")},
+	)
+
+	// Promote twice, to confirm the second promotion is a no-op.
+	for i := 0; i < 2; i++ {
+		if err := sif.PromoteToIndex(fi, "hello-world:latest"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(im.Manifests), 2; got != want {
+		t.Fatalf("got %v manifests, want %v", got, want)
+	}
+
+	desc := im.Manifests[0]
+
+	if got, want := desc.Annotations["org.opencontainers.image.ref.name"], "hello-world:latest"; got != want {
+		t.Errorf("got ref %v, want %v", got, want)
+	}
+
+	if !desc.MediaType.IsIndex() {
+		t.Fatalf("got media type %v, want index", desc.MediaType)
+	}
+
+	child, err := ii.ImageIndex(desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cim, err := child.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(cim.Manifests), 1; got != want {
+		t.Fatalf("got %v children, want %v", got, want)
+	}
+
+	wantDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cim.Manifests[0].Digest; got != wantDigest {
+		t.Errorf("got child digest %v, want %v", got, wantDigest)
+	}
+
+	if got, want := cim.Manifests[0].Platform.String(), "linux/arm64/v8"; got != want {
+		t.Errorf("got child platform %v, want %v", got, want)
+	}
+}
+
+func TestTrimTo(t *testing.T) {
+	var tis []taggedImage
+	for _, tag := range []string{"1", "2", "3", "4", "5"} {
+		tis = append(tis, taggedImage{
+			ref: "cache:" + tag,
+			img: labeledImage(t, corpus.Image(t, "hello-world-docker-v2-manifest"), "tag", tag),
+		})
+	}
+
+	fi := fileImageWithRefs(t, tis...)
+
+	// References sort lexically by age.
+	newer := func(a, b sif.ImageEntry) bool { return a.Ref > b.Ref }
+
+	if err := sif.TrimTo(fi, 2, newer); err != nil {
+		t.Fatal(err)
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.VerifyReferences(fi); err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var refs []string
+	for _, desc := range im.Manifests {
+		refs = append(refs, desc.Annotations["org.opencontainers.image.ref.name"])
+	}
+
+	if got, want := refs, []string{"cache:4", "cache:5"}; !slices.Equal(got, want) {
+		t.Errorf("got refs %v, want %v", got, want)
+	}
+
+	// The manifests and configs of trimmed images are reclaimed. Layers are shared, so are not.
+	for i, ti := range tis {
+		m, err := ti.img.Manifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := ti.img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, h := range []v1.Hash{h, m.Config.Digest} {
+			_, err := fi.GetDescriptor(ssif.WithOCIBlobDigest(h))
+			if got, want := err == nil, i >= 3; got != want {
+				t.Errorf("%v: got blob %v present %v, want %v", ti.ref, h, got, want)
+			}
+		}
+
+		if _, err := fi.GetDescriptor(ssif.WithOCIBlobDigest(m.Layers[0].Digest)); err != nil {
+			t.Errorf("%v: got error %v for shared layer", ti.ref, err)
+		}
+	}
+}
+
+func TestRemoveImage(t *testing.T) {
+	hw := corpus.Image(t, "hello-world-docker-v2-manifest")
+	ml := corpus.Image(t, "many-layers")
+
+	fi := fileImageWithRefs(t,
+		taggedImage{"hello-world:latest", hw},
+		taggedImage{"many-layers:latest", ml},
+	)
+
+	digest := func(img v1.Image) v1.Hash {
+		h, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	missing := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+	}
+
+	if err := sif.RemoveImage(fi, missing); err == nil {
+		t.Error("got nil error removing missing image")
+	}
+
+	tests := []struct {
+		name      string
+		h         v1.Hash
+		wantRefs  []string
+		wantBlobs int
+	}{
+		{
+			name:      "HelloWorld",
+			h:         digest(hw),
+			wantRefs:  []string{"many-layers:latest"},
+			wantBlobs: 52,
+		},
+		{
+			name:      "Last",
+			h:         digest(ml),
+			wantBlobs: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := sif.RemoveImage(fi, tt.h); err != nil {
+				t.Fatal(err)
+			}
+
+			ii, err := sif.ImageIndexFromFileImage(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Index(ii); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := sif.VerifyReferences(fi); err != nil {
+				t.Fatal(err)
+			}
+
+			im, err := ii.IndexManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			refs := make([]string, 0, len(im.Manifests))
+			for _, desc := range im.Manifests {
+				refs = append(refs, desc.Annotations["org.opencontainers.image.ref.name"])
+			}
+
+			if got, want := refs, tt.wantRefs; !slices.Equal(got, want) {
+				t.Errorf("got refs %v, want %v", got, want)
+			}
+
+			stats, err := sif.DataTypeBreakdown(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := stats[ssif.DataOCIBlob].Count, tt.wantBlobs; got != want {
+				t.Errorf("got %v blobs, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestListImages(t *testing.T) {
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Image", func(t *testing.T) {
+		fi := fileImageWithRefs(t, taggedImage{"a:latest", img})
+
+		infos, err := sif.ListImages(fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := len(infos), 1; got != want {
+			t.Fatalf("got %v images, want %v", got, want)
+		}
+
+		if got, want := infos[0].Ref, "a:latest"; got != want {
+			t.Errorf("got ref %v, want %v", got, want)
+		}
+
+		if got, want := infos[0].Digest, digest; got != want {
+			t.Errorf("got digest %v, want %v", got, want)
+		}
+
+		if got, want := infos[0].MediaType, types.DockerManifestSchema2; got != want {
+			t.Errorf("got media type %v, want %v", got, want)
+		}
+
+		// The platform is taken from the config.
+		if got, want := infos[0].Platform, cf.Platform(); got == nil || !got.Equals(*want) {
+			t.Errorf("got platform %v, want %v", got, want)
+		}
+	})
+
+	t.Run("NestedIndex", func(t *testing.T) {
+		fi := fileImageWithRefs(t, taggedImage{"a:latest", img})
+
+		if err := sif.PromoteToIndex(fi, "a:latest"); err != nil {
+			t.Fatal(err)
+		}
+
+		infos, err := sif.ListImages(fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := len(infos), 1; got != want {
+			t.Fatalf("got %v images, want %v", got, want)
+		}
+
+		// The image within the nested index is associated with the reference of the index.
+		if got, want := infos[0].Ref, "a:latest"; got != want {
+			t.Errorf("got ref %v, want %v", got, want)
+		}
+
+		if got, want := infos[0].Digest, digest; got != want {
+			t.Errorf("got digest %v, want %v", got, want)
+		}
+
+		if got, want := infos[0].Platform, cf.Platform(); got == nil || !got.Equals(*want) {
+			t.Errorf("got platform %v, want %v", got, want)
+		}
+	})
+
+	t.Run("ManifestList", func(t *testing.T) {
+		fi := fileImageFromPath(t, "hello-world-docker-v2-manifest-list")
+
+		ii, err := sif.ImageIndexFromFileImage(fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		im, err := ii.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		infos, err := sif.ListImages(fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := len(infos), len(im.Manifests); got != want {
+			t.Fatalf("got %v images, want %v", got, want)
+		}
+
+		// The platform is taken from the descriptor.
+		for i, info := range infos {
+			desc := im.Manifests[i]
+
+			if got, want := info.Digest, desc.Digest; got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+
+			if got, want := info.Platform, desc.Platform; got == nil || !got.Equals(*want) {
+				t.Errorf("got platform %v, want %v", got, want)
+			}
+		}
+	})
+}
+
+func TestExtractPlatform(t *testing.T) {
+	fi := fileImageFromPath(t, "hello-world-docker-v2-manifest-list")
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, desc := range im.Manifests {
+		t.Run(desc.Platform.String(), func(t *testing.T) {
+			img, err := sif.ExtractPlatform(fi, *desc.Platform)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := img.Digest(); err != nil {
+				t.Fatal(err)
+			} else if want := desc.Digest; got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := sif.ExtractPlatform(fi, v1.Platform{OS: "plan9", Architecture: "amd64"})
+		if err == nil {
+			t.Fatal("got nil error, want error")
+		}
+
+		if !strings.Contains(err.Error(), im.Manifests[0].Platform.String()) {
+			t.Errorf("got error %v, want available platforms", err)
+		}
+	})
+}
+
+func TestPlatformsForRef(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64"}
+
+	list := ggcrmutate.AppendManifests(empty.Index,
+		ggcrmutate.IndexAddendum{
+			Add:        labeledImage(t, base, "arch", "amd64"),
+			Descriptor: v1.Descriptor{Platform: &amd64},
+		},
+		ggcrmutate.IndexAddendum{
+			Add:        labeledImage(t, base, "arch", "arm64"),
+			Descriptor: v1.Descriptor{Platform: &arm64},
+		},
+		ggcrmutate.IndexAddendum{
+			Add: labeledImage(t, base, "attestation", "true"),
+			Descriptor: v1.Descriptor{
+				Platform:    &v1.Platform{OS: "unknown", Architecture: "unknown"},
+				Annotations: map[string]string{"vnd.docker.reference.type": "attestation-manifest"},
+			},
+		},
+	)
+
+	ii := ggcrmutate.AppendManifests(empty.Index,
+		ggcrmutate.IndexAddendum{
+			Add: list,
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{"org.opencontainers.image.ref.name": "hello-world:multi"},
+			},
+		},
+		ggcrmutate.IndexAddendum{
+			Add: base,
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{"org.opencontainers.image.ref.name": "hello-world:single"},
+			},
+		},
+	)
+
+	path := filepath.Join(t.TempDir(), "image.sif")
+
+	if err := sif.Write(path, ii); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := ssif.LoadContainerFromPath(path, ssif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	cf, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		ref           string
+		wantPlatforms []v1.Platform
+		wantErr       bool
+	}{
+		{
+			name:          "MultiArch",
+			ref:           "hello-world:multi",
+			wantPlatforms: []v1.Platform{amd64, arm64},
+		},
+		{
+			name:          "SingleArch",
+			ref:           "hello-world:single",
+			wantPlatforms: []v1.Platform{*cf.Platform()},
+		},
+		{
+			name:    "NotFound",
+			ref:     "hello-world:missing",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, err := sif.PlatformsForRef(fi, tt.ref)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if got, want := ps, tt.wantPlatforms; !slices.EqualFunc(got, want, func(a, b v1.Platform) bool {
+				return a.Equals(b)
+			}) {
+				t.Errorf("got platforms %v, want %v", got, want)
+			}
+		})
+	}
+}
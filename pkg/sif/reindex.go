@@ -0,0 +1,253 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+var (
+	errNoTopLevelManifests       = errors.New("no top-level manifests found")
+	errAmbiguousManifest         = errors.New("ambiguous manifest")
+	errMultipleTopLevelManifests = errors.New("multiple top-level manifests found")
+)
+
+// manifestProbe holds the fields used to identify an index or image manifest.
+type manifestProbe struct {
+	MediaType types.MediaType `json:"mediaType"`
+	Manifests []v1.Descriptor `json:"manifests"`
+	Config    json.RawMessage `json:"config"`
+	Layers    json.RawMessage `json:"layers"`
+}
+
+// probeManifest returns the media type of b, if it is an index or image manifest, along with the
+// descriptors it contains if it is an index. If b is not a manifest, an empty media type is
+// returned. Where the "mediaType" field is absent, the media type is inferred from the fields
+// present.
+func probeManifest(b []byte) (types.MediaType, []v1.Descriptor, error) {
+	var p manifestProbe
+	if err := json.Unmarshal(b, &p); err != nil {
+		// Content that is not a JSON object is not a manifest.
+		return "", nil, nil
+	}
+
+	isIndex := p.Manifests != nil
+	isImage := p.Config != nil && p.Layers != nil
+
+	if isIndex && isImage {
+		return "", nil, errAmbiguousManifest
+	}
+
+	switch mt := p.MediaType; {
+	case mt.IsIndex():
+		return mt, p.Manifests, nil
+	case mt.IsImage():
+		return mt, nil, nil
+	case mt != "":
+		return "", nil, nil
+	case isIndex:
+		return types.OCIImageIndex, p.Manifests, nil
+	case isImage:
+		return types.OCIManifestSchema1, nil, nil
+	default:
+		return "", nil, nil
+	}
+}
+
+// readManifest returns the content of d if it appears to hold JSON, and nil otherwise. Only the
+// first byte of other blobs, such as layers, is read.
+func readManifest(d sif.Descriptor) ([]byte, error) {
+	br := bufio.NewReader(d.GetReader())
+
+	// Empty blobs, and blobs that do not begin with a JSON object, are not manifests.
+	if b, err := br.Peek(1); err != nil || b[0] != '{' {
+		return nil, nil
+	}
+
+	return d.GetData()
+}
+
+// platformOf returns the platform specified by the config of the image manifest in b, if any.
+func (f *fileImage) platformOf(b []byte) *v1.Platform {
+	var m v1.Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil
+	}
+
+	cb, err := f.Bytes(m.Config.Digest)
+	if err != nil {
+		return nil
+	}
+
+	var cf v1.ConfigFile
+	if err := json.Unmarshal(cb, &cf); err != nil {
+		return nil
+	}
+
+	return cf.Platform()
+}
+
+// reindexOpts accumulates Reindex options.
+type reindexOpts struct {
+	multipleEntries bool
+}
+
+// ReindexOpt are used to specify Reindex options.
+type ReindexOpt func(*reindexOpts) error
+
+// OptReindexMultipleEntries specifies whether the reconstructed RootIndex may hold more than one
+// entry. Where fi is known to hold more than one image (or index), this allows each to be
+// recovered. Note that orphaned manifests, such as those left behind by an interrupted update, are
+// indistinguishable from intended entries, and are also given an entry.
+func OptReindexMultipleEntries(b bool) ReindexOpt {
+	return func(ro *reindexOpts) error {
+		ro.multipleEntries = b
+		return nil
+	}
+}
+
+// Reindex replaces the RootIndex of fi with one reconstructed from the OCI blobs it contains. This
+// is intended to recover a SIF whose RootIndex is missing or inconsistent, for example after blobs
+// have been added or removed using the low-level sif package.
+//
+// Each stored index and image manifest that is not referenced from another stored index is
+// considered top-level. The top-level manifest is given an entry in the new RootIndex. Where the
+// config of an image specifies a platform, it is recorded in the entry. The annotations of the
+// original RootIndex, including references, cannot be recovered.
+//
+// By default, the root cannot be identified unambiguously where more than one top-level manifest
+// is found, as an orphaned manifest cannot be distinguished from an intended entry. To give each
+// top-level manifest an entry, in the order the blobs are stored, consider using
+// OptReindexMultipleEntries.
+//
+// An error is returned, and fi is not modified, if the root cannot be identified unambiguously, if
+// a blob cannot be identified unambiguously as either an index or image manifest, or if any blob
+// referenced from the reconstructed RootIndex is missing.
+//
+// Before fi is modified, Reindex checks that it was opened for writing, and places an exclusive
+// advisory lock on the underlying file, as described for Update.
+func Reindex(fi *sif.FileImage, opts ...ReindexOpt) error {
+	ro := reindexOpts{}
+
+	for _, opt := range opts {
+		if err := opt(&ro); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	ds, err := f.GetDescriptors(sif.WithDataType(sif.DataOCIBlob))
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return err
+	}
+
+	var candidates []v1.Descriptor
+
+	seen := make(map[v1.Hash]bool)
+	referenced := make(map[v1.Hash]bool)
+	platforms := make(map[v1.Hash]*v1.Platform)
+
+	for _, d := range ds {
+		h, err := d.OCIBlobDigest()
+		if err != nil {
+			return err
+		}
+
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+
+		b, err := readManifest(d)
+		if err != nil {
+			return err
+		}
+
+		if b == nil {
+			continue
+		}
+
+		mt, children, err := probeManifest(b)
+		if err != nil {
+			return fmt.Errorf("%w: %v", err, h)
+		}
+
+		if mt == "" {
+			continue
+		}
+
+		for _, desc := range children {
+			referenced[desc.Digest] = true
+		}
+
+		if mt.IsImage() {
+			platforms[h] = f.platformOf(b)
+		}
+
+		candidates = append(candidates, v1.Descriptor{
+			MediaType: mt,
+			Size:      d.Size(),
+			Digest:    h,
+		})
+	}
+
+	im := v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+	}
+
+	for _, desc := range candidates {
+		if referenced[desc.Digest] {
+			continue
+		}
+
+		desc.Platform = platforms[desc.Digest]
+
+		im.Manifests = append(im.Manifests, desc)
+	}
+
+	if len(im.Manifests) == 0 {
+		return errNoTopLevelManifests
+	}
+
+	if n := len(im.Manifests); n > 1 && !ro.multipleEntries {
+		return fmt.Errorf("%w: %v", errMultipleTopLevelManifests, n)
+	}
+
+	// Check that the content of the reconstructed RootIndex is complete before it is written.
+	b, err := json.Marshal(im)
+	if err != nil {
+		return err
+	}
+
+	rv := referenceVerifier{
+		f:    f,
+		seen: make(map[v1.Hash]bool),
+	}
+
+	if err := rv.verifyIndex(b); err != nil {
+		return err
+	}
+
+	if err := errors.Join(rv.errs...); err != nil {
+		return err
+	}
+
+	return f.writeRootIndex(&im)
+}
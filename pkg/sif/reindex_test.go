@@ -0,0 +1,218 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// deleteRootIndex deletes the RootIndex object from fi.
+func deleteRootIndex(tb testing.TB, fi *ssif.FileImage) {
+	tb.Helper()
+
+	d, err := fi.GetDescriptor(ssif.WithDataType(ssif.DataOCIRootIndex))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := fi.DeleteObject(d.ID()); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+	labeled := labeledImage(t, base, "key", "value")
+
+	t.Run("Images", func(t *testing.T) {
+		fi := fileImageWithRefs(t,
+			taggedImage{"hello-world:latest", base},
+			taggedImage{"hello-world:labeled", labeled},
+		)
+
+		deleteRootIndex(t, fi)
+
+		if err := sif.Reindex(fi, sif.OptReindexMultipleEntries(true)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := sif.Verify(fi); err != nil {
+			t.Fatal(err)
+		}
+
+		ii, err := sif.ImageIndexFromFileImage(fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := validate.Index(ii); err != nil {
+			t.Fatal(err)
+		}
+
+		im, err := ii.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := len(im.Manifests), 2; got != want {
+			t.Fatalf("got %v manifests, want %v", got, want)
+		}
+
+		cf, err := base.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, img := range []v1.Image{base, labeled} {
+			h, err := img.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := im.Manifests[i].Digest, h; got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+
+			if got, want := im.Manifests[i].Platform, cf.Platform(); got == nil || !got.Equals(*want) {
+				t.Errorf("got platform %v, want %v", got, want)
+			}
+		}
+	})
+
+	t.Run("NestedIndex", func(t *testing.T) {
+		fi := fileImageWithRefs(t, taggedImage{"hello-world:latest", base})
+
+		if err := sif.PromoteToIndex(fi, "hello-world:latest"); err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := sif.ImageIndexFromFileImage(fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantIM, err := want.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deleteRootIndex(t, fi)
+
+		if err := sif.Reindex(fi); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := sif.ImageIndexFromFileImage(fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotIM, err := got.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The image is referenced from the nested index, so only the nested index is top-level.
+		if got, want := len(gotIM.Manifests), 1; got != want {
+			t.Fatalf("got %v manifests, want %v", got, want)
+		}
+
+		if got, want := gotIM.Manifests[0].Digest, wantIM.Manifests[0].Digest; got != want {
+			t.Errorf("got digest %v, want %v", got, want)
+		}
+	})
+
+	t.Run("MissingLayer", func(t *testing.T) {
+		fi := fileImageWithRefs(t, taggedImage{"hello-world:latest", base})
+
+		ls, err := base.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := ls[0].Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deleteRootIndex(t, fi)
+		deleteBlob(t, fi, h)
+
+		if err := sif.Reindex(fi); err == nil {
+			t.Fatal("got nil error, want error")
+		}
+
+		// The RootIndex is not written.
+		if _, err := fi.GetDescriptor(ssif.WithDataType(ssif.DataOCIRootIndex)); !errors.Is(err, ssif.ErrObjectNotFound) {
+			t.Errorf("got error %v, want %v", err, ssif.ErrObjectNotFound)
+		}
+	})
+
+	t.Run("MultipleEntries", func(t *testing.T) {
+		fi := fileImageWithRefs(t,
+			taggedImage{"hello-world:latest", base},
+			taggedImage{"hello-world:labeled", labeled},
+		)
+
+		deleteRootIndex(t, fi)
+
+		if err := sif.Reindex(fi); err == nil {
+			t.Fatal("got nil error, want error")
+		}
+
+		// The RootIndex is not written.
+		if _, err := fi.GetDescriptor(ssif.WithDataType(ssif.DataOCIRootIndex)); !errors.Is(err, ssif.ErrObjectNotFound) {
+			t.Errorf("got error %v, want %v", err, ssif.ErrObjectNotFound)
+		}
+	})
+
+	t.Run("OrphanManifest", func(t *testing.T) {
+		fi := fileImageWithRefs(t, taggedImage{"hello-world:labeled", labeled})
+
+		// Leave the manifest and config of base behind, as an interrupted update might. Its layers
+		// are shared with labeled.
+		for _, get := range []func() ([]byte, error){base.RawConfigFile, base.RawManifest} {
+			b, err := get()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			di, err := ssif.NewDescriptorInput(ssif.DataOCIBlob, bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := fi.AddObject(di); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		deleteRootIndex(t, fi)
+
+		if err := sif.Reindex(fi); err == nil {
+			t.Fatal("got nil error, want error")
+		}
+
+		// The RootIndex is not written.
+		if _, err := fi.GetDescriptor(ssif.WithDataType(ssif.DataOCIRootIndex)); !errors.Is(err, ssif.ErrObjectNotFound) {
+			t.Errorf("got error %v, want %v", err, ssif.ErrObjectNotFound)
+		}
+	})
+
+	t.Run("NoManifests", func(t *testing.T) {
+		fi := emptyFileImage(t, 1)
+
+		if err := sif.Reindex(fi); err == nil {
+			t.Fatal("got nil error, want error")
+		}
+	})
+}
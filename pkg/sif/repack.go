@@ -0,0 +1,273 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+var errNonOCIObjectFollows = errors.New("non-OCI object follows OCI content")
+
+// streamingOrder determines the order in which blobs reachable from a RootIndex are written to
+// support streaming. Metadata (indexes, manifests and configs) is ordered before bulk data
+// (layers). Within each group, blobs are ordered as a client pulling the content would request
+// them.
+type streamingOrder struct {
+	f    *fileImage
+	seen map[v1.Hash]bool
+	meta []v1.Hash
+	data []v1.Hash
+}
+
+// add records the blob referenced by desc in the metadata or bulk data group. Blobs that have been
+// seen before, or that are not present in f, are skipped. It returns true if the blob was added.
+func (so *streamingOrder) add(desc v1.Descriptor, meta bool) (bool, error) {
+	if so.seen[desc.Digest] {
+		return false, nil
+	}
+	so.seen[desc.Digest] = true
+
+	if ok, err := so.f.hasBlob(desc.Digest); err != nil || !ok {
+		return false, err
+	}
+
+	if meta {
+		so.meta = append(so.meta, desc.Digest)
+	} else {
+		so.data = append(so.data, desc.Digest)
+	}
+
+	return true, nil
+}
+
+// visitIndex visits the descriptors in the index manifest with the supplied content.
+func (so *streamingOrder) visitIndex(b []byte) error {
+	var im v1.IndexManifest
+	if err := json.Unmarshal(b, &im); err != nil {
+		return err
+	}
+
+	for _, desc := range im.Manifests {
+		if err := so.visit(desc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visitManifest visits the config and layer descriptors in the image manifest with the supplied
+// content.
+func (so *streamingOrder) visitManifest(b []byte) error {
+	var m v1.Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	if _, err := so.add(m.Config, true); err != nil {
+		return err
+	}
+
+	for _, desc := range m.Layers {
+		if _, err := so.add(desc, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visit visits the blob referenced by desc. If desc references an index or image manifest, the
+// descriptors it contains are visited recursively.
+func (so *streamingOrder) visit(desc v1.Descriptor) error {
+	mt := desc.MediaType
+
+	if !mt.IsIndex() && !mt.IsImage() {
+		_, err := so.add(desc, false)
+		return err
+	}
+
+	if ok, err := so.add(desc, true); err != nil || !ok {
+		return err
+	}
+
+	b, err := so.f.Bytes(desc.Digest)
+	if err != nil {
+		return err
+	}
+
+	if mt.IsIndex() {
+		return so.visitIndex(b)
+	}
+	return so.visitManifest(b)
+}
+
+// checkOCIObjectsLast returns an error if an object in f that does not contain OCI content
+// follows an object that does. Objects cannot be moved within f, so OCI objects can only be
+// rewritten when they occupy the end of f.
+func (f *fileImage) checkOCIObjectsLast() error {
+	ds, err := f.GetDescriptors(func(sif.Descriptor) (bool, error) { return true, nil })
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Offset() < ds[j].Offset() })
+
+	seenOCI := false
+
+	for _, d := range ds {
+		if isOCIObject(d) {
+			seenOCI = true
+		} else if seenOCI {
+			return fmt.Errorf("%w: object %v", errNonOCIObjectFollows, d.ID())
+		}
+	}
+
+	return nil
+}
+
+// deleteOCIObjects deletes all OCI blobs and RootIndex objects from f. Objects are deleted in
+// descending order of offset, so that the space they occupy at the end of f is reclaimed.
+func (f *fileImage) deleteOCIObjects() error {
+	ds, err := f.GetDescriptors(func(d sif.Descriptor) (bool, error) {
+		return d.DataType() == sif.DataOCIBlob || d.DataType() == sif.DataOCIRootIndex, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Offset() > ds[j].Offset() })
+
+	for _, d := range ds {
+		if err := f.DeleteObject(d.ID(), sif.OptDeleteCompact(true)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// repackOpts accumulates RepackForStreaming options.
+type repackOpts struct {
+	tempDir string
+}
+
+// RepackOpt are used to specify RepackForStreaming options.
+type RepackOpt func(*repackOpts) error
+
+// OptRepackTempDir specifies the directory in which objects are cached while they are re-written.
+// By default, the directory returned by os.TempDir is used. On systems where that directory has
+// limited space, consider specifying a directory on the same filesystem as the SIF.
+func OptRepackTempDir(dir string) RepackOpt {
+	return func(ro *repackOpts) error {
+		ro.tempDir = dir
+		return nil
+	}
+}
+
+// RepackForStreaming rewrites the OCI content of fi so that it can be consumed with a single
+// sequential read. The RootIndex is written first, followed by indexes, manifests and configs,
+// followed by layers. Within each group, blobs are written in the order a client pulling the
+// content would request them. Blobs that are not reachable from the RootIndex are removed.
+//
+// Objects in fi that do not contain OCI content are not modified. As objects cannot be moved
+// within fi, an error is returned before fi is modified if such an object follows any OCI content.
+//
+// The OCI content of fi is copied to a temporary directory, removed from fi, and re-written once
+// the file has been truncated. If the operation is interrupted (e.g. by a crash, or because the
+// filesystem is full) while objects are being re-written, fi may be left incomplete. Where
+// sufficient space is available, writing a new SIF and renaming it over the original is the safer
+// alternative.
+//
+// By default, objects are cached in the directory returned by os.TempDir. To override this,
+// consider using OptRepackTempDir.
+//
+// Before fi is modified, RepackForStreaming checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update.
+func RepackForStreaming(fi *sif.FileImage, opts ...RepackOpt) error {
+	var ro repackOpts
+
+	for _, opt := range opts {
+		if err := opt(&ro); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	if err := f.checkOCIObjectsLast(); err != nil {
+		return err
+	}
+
+	d, err := f.GetDescriptor(sif.WithDataType(sif.DataOCIRootIndex))
+	if err != nil {
+		return err
+	}
+
+	rootIndex, err := d.GetData()
+	if err != nil {
+		return err
+	}
+
+	so := streamingOrder{
+		f:    f,
+		seen: make(map[v1.Hash]bool),
+	}
+
+	if err := so.visitIndex(rootIndex); err != nil {
+		return err
+	}
+
+	// Select the objects to retain, in the order they are to be written. Where a blob is stored
+	// more than once, only the first copy is retained.
+	retain := []sif.Descriptor{d}
+
+	for _, h := range append(slices.Clone(so.meta), so.data...) {
+		ds, err := f.GetDescriptors(sif.WithOCIBlobDigest(h))
+		if err != nil {
+			return err
+		}
+
+		retain = append(retain, ds[0])
+	}
+
+	dir, err := os.MkdirTemp(ro.tempDir, "oci-tools-repack-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, d := range retain {
+		if err := cacheObject(d, dir); err != nil {
+			return err
+		}
+	}
+
+	if err := f.deleteOCIObjects(); err != nil {
+		return err
+	}
+
+	for _, d := range retain {
+		if err := f.writeCachedObject(d, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
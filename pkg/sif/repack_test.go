@@ -0,0 +1,191 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// blobOffset returns the offset of the blob with digest h in fi.
+func blobOffset(tb testing.TB, fi *ssif.FileImage, h v1.Hash) int64 {
+	tb.Helper()
+
+	d, err := fi.GetDescriptor(ssif.WithOCIBlobDigest(h))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return d.Offset()
+}
+
+func TestRepackForStreaming(t *testing.T) {
+	fi := fileImageWithRefs(t,
+		taggedImage{"hello-world:latest", corpus.Image(t, "hello-world-docker-v2-manifest")},
+		taggedImage{"many-layers:latest", corpus.Image(t, "many-layers")},
+	)
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDigest, err := ii.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.RepackForStreaming(fi); err != nil {
+		t.Fatal(err)
+	}
+
+	ii, err = sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ii.Digest(); err != nil {
+		t.Fatal(err)
+	} else if got != wantDigest {
+		t.Errorf("got digest %v, want %v", got, wantDigest)
+	}
+
+	ri, err := fi.GetDescriptor(ssif.WithDataType(ssif.DataOCIRootIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := fi.GetDescriptors(ssif.WithDataType(ssif.DataOCIBlob))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range blobs {
+		if d.Offset() < ri.Offset() {
+			t.Errorf("blob at offset %v precedes RootIndex at offset %v", d.Offset(), ri.Offset())
+		}
+	}
+
+	// All metadata must precede all layers.
+	var lastMeta, firstLayer int64 = 0, fi.DataSize() + fi.DataOffset()
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, desc := range im.Manifests {
+		img, err := ii.Image(desc.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := img.ConfigName()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lastMeta = max(lastMeta, blobOffset(t, fi, desc.Digest), blobOffset(t, fi, h))
+
+		ls, err := img.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, l := range ls {
+			h, err := l.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			firstLayer = min(firstLayer, blobOffset(t, fi, h))
+		}
+	}
+
+	if lastMeta > firstLayer {
+		t.Errorf("metadata at offset %v follows layer at offset %v", lastMeta, firstLayer)
+	}
+}
+
+func TestRepackForStreamingNonOCIObjectFollows(t *testing.T) {
+	fi := fileImageWithGeneric(t, "hello-world-docker-v2-manifest", "content")
+
+	before, err := fi.GetDescriptors(ssif.WithDataType(ssif.DataOCIBlob))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sif.RepackForStreaming(fi); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+
+	// The OCI content is not modified.
+	after, err := fi.GetDescriptors(ssif.WithDataType(ssif.DataOCIBlob))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(after), len(before); got != want {
+		t.Errorf("got %v blobs, want %v", got, want)
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepackForStreamingTempDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr bool
+	}{
+		{
+			name: "Exists",
+			dir:  t.TempDir(),
+		},
+		{
+			name:    "NotExist",
+			dir:     filepath.Join(t.TempDir(), "missing"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := fileImageWithRefs(t,
+				taggedImage{"hello-world:latest", corpus.Image(t, "hello-world-docker-v2-manifest")},
+			)
+
+			err := sif.RepackForStreaming(fi, sif.OptRepackTempDir(tt.dir))
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			// Whether or not the repack succeeded, the content of fi is intact.
+			if err := sif.Verify(fi); err != nil {
+				t.Fatal(err)
+			}
+
+			if entries, err := os.ReadDir(tt.dir); err == nil && len(entries) != 0 {
+				t.Errorf("got %v entries in temporary directory, want 0", len(entries))
+			}
+		})
+	}
+}
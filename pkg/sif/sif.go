@@ -5,6 +5,7 @@
 package sif
 
 import (
+	"errors"
 	"io"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -14,6 +15,10 @@ import (
 // fileImage represents a Singularity Image Format (SIF) file containing OCI artifacts.
 type fileImage struct {
 	*sif.FileImage
+
+	// written, if non-nil, is called with the digest of each blob added by
+	// writeBlobToFileImageOnce.
+	written func(v1.Hash)
 }
 
 // Blob returns a ReadCloser that reads the blob with the supplied digest.
@@ -45,3 +50,16 @@ func (f *fileImage) Offset(h v1.Hash) (int64, error) {
 
 	return d.Offset(), nil
 }
+
+// hasBlob returns true if f contains a blob with the supplied digest.
+func (f *fileImage) hasBlob(h v1.Hash) (bool, error) {
+	_, err := f.GetDescriptor(sif.WithOCIBlobDigest(h))
+	switch {
+	case err == nil, errors.Is(err, sif.ErrMultipleObjectsFound):
+		return true, nil
+	case errors.Is(err, sif.ErrNoObjects), errors.Is(err, sif.ErrObjectNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
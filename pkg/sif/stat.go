@@ -0,0 +1,69 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"errors"
+
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// TypeStat describes the data objects of a particular type within a SIF.
+type TypeStat struct {
+	Count int   // Number of data objects.
+	Size  int64 // Total size of data objects, in bytes.
+}
+
+// DataTypeBreakdown returns the number and total size of the data objects in fi, keyed by data
+// type. Data objects of all types are included, not only those holding OCI content.
+func DataTypeBreakdown(fi *sif.FileImage) (map[sif.DataType]TypeStat, error) {
+	ds, err := fi.GetDescriptors()
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return nil, err
+	}
+
+	stats := make(map[sif.DataType]TypeStat)
+
+	for _, d := range ds {
+		s := stats[d.DataType()]
+		s.Count++
+		s.Size += d.Size()
+		stats[d.DataType()] = s
+	}
+
+	return stats, nil
+}
+
+// SpaceStat describes the use of space within a SIF.
+type SpaceStat struct {
+	FileSize         int64 // Size of the SIF, in bytes.
+	UsedBytes        int64 // Total size of data objects, in bytes.
+	ReclaimableBytes int64 // Size of the data region not occupied by data objects, in bytes.
+}
+
+// FragmentationStats returns the size of fi, the space occupied by its data objects, and the space
+// within the data region of fi that is not occupied by any data object. Unoccupied space results
+// from deleting objects, and from padding objects to satisfy alignment requirements. A large amount
+// of unoccupied space indicates that compaction (e.g. CompactInPlace) may be worthwhile.
+//
+// Space occupied by OCI blobs that are not reachable from the RootIndex is counted as used. To
+// remove such blobs, consider using CompactInPlace.
+func FragmentationStats(fi *sif.FileImage) (SpaceStat, error) {
+	ds, err := fi.GetDescriptors()
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return SpaceStat{}, err
+	}
+
+	var used int64
+	for _, d := range ds {
+		used += d.Size()
+	}
+
+	return SpaceStat{
+		FileSize:         fi.DataOffset() + fi.DataSize(),
+		UsedBytes:        used,
+		ReclaimableBytes: fi.DataSize() - used,
+	}, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// fileImageWithGeneric returns a temporary FileImage for the test to use, populated from the OCI
+// Image Layout with the specified path in the corpus, plus a generic data object with the
+// specified content. The FileImage is automatically unloaded when the test and all its subtests
+// complete.
+func fileImageWithGeneric(t *testing.T, path, content string) *ssif.FileImage {
+	t.Helper()
+
+	sifPath := filepath.Join(t.TempDir(), "image.sif")
+
+	if err := sif.Write(sifPath, corpus.ImageIndex(t, path), sif.OptWriteWithSpareDescriptorCapacity(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ssif.LoadContainerFromPath(sifPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.UnloadContainer() })
+
+	di, err := ssif.NewDescriptorInput(ssif.DataGeneric, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.AddObject(di); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestDataTypeBreakdown(t *testing.T) {
+	tests := []struct {
+		name      string
+		f         *ssif.FileImage
+		wantStats map[ssif.DataType]sif.TypeStat
+	}{
+		{
+			name: "DockerManifest",
+			f:    fileImageFromPath(t, "hello-world-docker-v2-manifest"),
+			wantStats: map[ssif.DataType]sif.TypeStat{
+				ssif.DataOCIBlob:      {Count: 3, Size: 3208 + 1485 + 525},
+				ssif.DataOCIRootIndex: {Count: 1, Size: 314},
+			},
+		},
+		{
+			name: "Generic",
+			f:    fileImageWithGeneric(t, "hello-world-docker-v2-manifest", "generic"),
+			wantStats: map[ssif.DataType]sif.TypeStat{
+				ssif.DataOCIBlob:      {Count: 3, Size: 3208 + 1485 + 525},
+				ssif.DataOCIRootIndex: {Count: 1, Size: 314},
+				ssif.DataGeneric:      {Count: 1, Size: 7},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := sif.DataTypeBreakdown(tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := stats, tt.wantStats; !maps.Equal(got, want) {
+				t.Errorf("got stats %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestFragmentationStats(t *testing.T) {
+	path := corpus.SIF(t, "many-layers")
+
+	fi, err := ssif.LoadContainerFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	before, err := sif.FragmentationStats(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := before.FileSize, info.Size(); got != want {
+		t.Errorf("got file size %v, want %v", got, want)
+	}
+
+	// Delete a layer, leaving a hole in the data region.
+	img := corpus.Image(t, "many-layers")
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ls[len(ls)/2].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := sif.BlobSize(fi, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleteBlob(t, fi, h)
+
+	after, err := sif.FragmentationStats(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := after.FileSize, before.FileSize; got != want {
+		t.Errorf("got file size %v, want %v", got, want)
+	}
+
+	if got, want := after.UsedBytes, before.UsedBytes-size; got != want {
+		t.Errorf("got used bytes %v, want %v", got, want)
+	}
+
+	if got, want := after.ReclaimableBytes, before.ReclaimableBytes+size; got != want {
+		t.Errorf("got reclaimable bytes %v, want %v", got, want)
+	}
+
+	if after.ReclaimableBytes == 0 {
+		t.Error("got no reclaimable bytes")
+	}
+}
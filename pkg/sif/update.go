@@ -0,0 +1,706 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/compression"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// rewrittenIndex wraps an index, replacing its manifest. Child indexes and images may also be
+// replaced. Other blobs are not modified.
+type rewrittenIndex struct {
+	base      v1.ImageIndex
+	children  map[v1.Hash]v1.ImageIndex
+	images    map[v1.Hash]v1.Image
+	mediaType types.MediaType
+	raw       []byte
+	digest    v1.Hash
+}
+
+// rewriteIndex returns an index based on base, with manifest im. Child indexes present in
+// children, and images present in images, are substituted for those in base.
+func rewriteIndex(
+	base v1.ImageIndex, im *v1.IndexManifest, children map[v1.Hash]v1.ImageIndex, images map[v1.Hash]v1.Image,
+) (v1.ImageIndex, error) {
+	b, err := json.Marshal(im)
+	if err != nil {
+		return nil, err
+	}
+
+	h, _, err := v1.SHA256(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return &rewrittenIndex{
+		base:      base,
+		children:  children,
+		images:    images,
+		mediaType: im.MediaType,
+		raw:       b,
+		digest:    h,
+	}, nil
+}
+
+// toOCIIndex returns ii with the media type of the index, and all child indexes, converted to
+// types.OCIImageIndex. If no conversion is required, ii is returned.
+func toOCIIndex(ii v1.ImageIndex) (v1.ImageIndex, error) {
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	im = im.DeepCopy()
+
+	changed := im.MediaType != types.OCIImageIndex
+	children := make(map[v1.Hash]v1.ImageIndex)
+
+	for i, desc := range im.Manifests {
+		if !desc.MediaType.IsIndex() {
+			continue
+		}
+
+		child, err := ii.ImageIndex(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		conv, err := toOCIIndex(child)
+		if err != nil {
+			return nil, err
+		}
+
+		if conv == child {
+			continue
+		}
+
+		h, err := conv.Digest()
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := conv.Size()
+		if err != nil {
+			return nil, err
+		}
+
+		im.Manifests[i].MediaType = types.OCIImageIndex
+		im.Manifests[i].Digest = h
+		im.Manifests[i].Size = size
+
+		children[h] = conv
+		changed = true
+	}
+
+	if !changed {
+		return ii, nil
+	}
+
+	im.MediaType = types.OCIImageIndex
+
+	return rewriteIndex(ii, im, children, nil)
+}
+
+// AddedAnnotation is the annotation that records the time an entry was added to the RootIndex, in
+// RFC 3339 format. This is distinct from the "org.opencontainers.image.created" annotation, which
+// records when the image was built.
+const AddedAnnotation = "io.sylabs.oci-tools.added"
+
+// stampCreated returns ii with AddedAnnotation set to t on each entry that does not already
+// have it. If all entries are annotated, ii is returned.
+func stampCreated(ii v1.ImageIndex, t time.Time) (v1.ImageIndex, error) {
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	im = im.DeepCopy()
+
+	created := t.UTC().Format(time.RFC3339)
+	changed := false
+
+	for i, desc := range im.Manifests {
+		if _, ok := desc.Annotations[AddedAnnotation]; ok {
+			continue
+		}
+
+		if desc.Annotations == nil {
+			im.Manifests[i].Annotations = make(map[string]string)
+		}
+		im.Manifests[i].Annotations[AddedAnnotation] = created
+		changed = true
+	}
+
+	if !changed {
+		return ii, nil
+	}
+
+	return rewriteIndex(ii, im, nil, nil)
+}
+
+// LabelAnnotationPrefix is the prefix of the annotations that record config labels of an image in
+// its RootIndex entry. The annotation key is completed by the label key.
+const LabelAnnotationPrefix = "io.sylabs.oci-tools.label."
+
+// promoteLabels returns ii with the config labels in keys recorded on each image entry, using
+// annotations with LabelAnnotationPrefix. Entries that reference an index are not modified. If no
+// annotations are added or changed, ii is returned.
+func promoteLabels(ii v1.ImageIndex, keys []string) (v1.ImageIndex, error) {
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	im = im.DeepCopy()
+
+	changed := false
+
+	for i, desc := range im.Manifests {
+		if !desc.MediaType.IsImage() {
+			continue
+		}
+
+		img, err := ii.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			v, ok := cf.Config.Labels[key]
+			if !ok {
+				continue
+			}
+
+			if cur, ok := desc.Annotations[LabelAnnotationPrefix+key]; ok && cur == v {
+				continue
+			}
+
+			if im.Manifests[i].Annotations == nil {
+				im.Manifests[i].Annotations = make(map[string]string)
+			}
+			im.Manifests[i].Annotations[LabelAnnotationPrefix+key] = v
+			changed = true
+		}
+	}
+
+	if !changed {
+		return ii, nil
+	}
+
+	return rewriteIndex(ii, im, nil, nil)
+}
+
+// MediaType of this index's manifest.
+func (ix *rewrittenIndex) MediaType() (types.MediaType, error) {
+	return ix.mediaType, nil
+}
+
+// Digest returns the sha256 of this index's manifest.
+func (ix *rewrittenIndex) Digest() (v1.Hash, error) {
+	return ix.digest, nil
+}
+
+// Size returns the size of the manifest.
+func (ix *rewrittenIndex) Size() (int64, error) {
+	return int64(len(ix.raw)), nil
+}
+
+// IndexManifest returns this image index's manifest object.
+func (ix *rewrittenIndex) IndexManifest() (*v1.IndexManifest, error) {
+	var im v1.IndexManifest
+	err := json.Unmarshal(ix.raw, &im)
+	return &im, err
+}
+
+// RawManifest returns the serialized bytes of IndexManifest().
+func (ix *rewrittenIndex) RawManifest() ([]byte, error) {
+	return ix.raw, nil
+}
+
+// Image returns a v1.Image that this ImageIndex references.
+func (ix *rewrittenIndex) Image(h v1.Hash) (v1.Image, error) {
+	if img, ok := ix.images[h]; ok {
+		return img, nil
+	}
+	return ix.base.Image(h)
+}
+
+// ImageIndex returns a v1.ImageIndex that this ImageIndex references.
+func (ix *rewrittenIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) {
+	if child, ok := ix.children[h]; ok {
+		return child, nil
+	}
+	return ix.base.ImageIndex(h)
+}
+
+// Blob returns a ReadCloser that reads the blob with the supplied digest.
+func (ix *rewrittenIndex) Blob(h v1.Hash) (io.ReadCloser, error) {
+	return blobFromIndex(ix.base, h)
+}
+
+// referencedBlobs adds the digests of the blobs referenced by ii, including the manifests of child
+// indexes and images, to refs. Each digest is mapped to a descriptor of the blob.
+func referencedBlobs(ii v1.ImageIndex, refs map[v1.Hash]v1.Descriptor) error {
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range im.Manifests {
+		if err := descriptorBlobs(ii, desc, refs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// descriptorBlobs adds the digests of the blobs referenced by desc, an entry in ii, to refs. This
+// includes the manifest referenced by desc, and the blobs it references. Each digest is mapped to
+// a descriptor of the blob.
+func descriptorBlobs(ii v1.ImageIndex, desc v1.Descriptor, refs map[v1.Hash]v1.Descriptor) error {
+	refs[desc.Digest] = desc
+
+	switch {
+	case desc.MediaType.IsIndex():
+		child, err := ii.ImageIndex(desc.Digest)
+		if err != nil {
+			return err
+		}
+
+		return referencedBlobs(child, refs)
+
+	case desc.MediaType.IsImage():
+		img, err := ii.Image(desc.Digest)
+		if err != nil {
+			return err
+		}
+
+		m, err := img.Manifest()
+		if err != nil {
+			return err
+		}
+
+		refs[m.Config.Digest] = m.Config
+
+		for _, l := range m.Layers {
+			refs[l.Digest] = l
+		}
+	}
+
+	return nil
+}
+
+var (
+	// ErrReadOnly is returned when attempting to modify a SIF that was opened read-only.
+	ErrReadOnly = errors.New("SIF opened read-only")
+
+	// ErrLocked is returned when attempting to modify a SIF that is locked by another handle.
+	ErrLocked = errors.New("SIF locked by another handle")
+)
+
+// backingReaderAt returns the storage backing fi, if it can be determined.
+func backingReaderAt(fi *sif.FileImage) (io.ReaderAt, bool) {
+	ds, err := fi.GetDescriptors()
+	if err != nil || len(ds) == 0 {
+		return nil, false
+	}
+
+	// The sif package does not expose the storage backing fi directly, but object readers are
+	// sections of it.
+	sr, ok := ds[0].GetReader().(interface {
+		Outer() (io.ReaderAt, int64, int64)
+	})
+	if !ok {
+		return nil, false
+	}
+
+	r, _, _ := sr.Outer()
+	return r, true
+}
+
+var errUnknownStorage = errors.New("unable to determine storage backing SIF")
+
+// lockForUpdate checks that fi is writable, and locks it for modification. The returned function
+// releases the lock. The storage backing fi is located via its objects, so if fi contains no
+// objects, it cannot be checked and an error is returned.
+func lockForUpdate(fi *sif.FileImage) (func(), error) {
+	r, ok := backingReaderAt(fi)
+	if !ok {
+		return nil, errUnknownStorage
+	}
+
+	if w, ok := r.(io.Writer); ok {
+		// A zero-length write fails if the storage was opened read-only, without modifying it.
+		if _, err := w.Write(nil); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadOnly, err)
+		}
+	}
+
+	if f, ok := r.(*os.File); ok {
+		return lockFile(f)
+	}
+
+	return func() {}, nil
+}
+
+// updateOpts accumulates update options.
+type updateOpts struct {
+	compression   *layerCompression
+	forceOCIIndex bool
+	created       time.Time
+	progress      func(blobsDone, blobsTotal int, bytesDone, bytesTotal int64)
+	labels        []string
+}
+
+// UpdateOpt are used to specify update options.
+type UpdateOpt func(*updateOpts) error
+
+// OptUpdateRecompressLayers specifies that each layer is decompressed, and recompressed using
+// algorithm c at the specified level, before being stored. Manifests and indexes are updated to
+// reference the recompressed layers, so their digests change. Layer diffIDs, and therefore image
+// configs, are unchanged.
+//
+// If c is compression.None, layers are stored uncompressed, and level is ignored. Otherwise, level
+// is interpreted as described for tarball.WithCompressionLevel. Since zstd-compressed layers are
+// not supported in Docker images, an error is returned if c is compression.ZStd and ii contains a
+// Docker image.
+func OptUpdateRecompressLayers(c compression.Compression, level int) UpdateOpt {
+	return func(uo *updateOpts) error {
+		if c != compression.None && c != compression.GZip && c != compression.ZStd {
+			return fmt.Errorf("%w: %v", errUnsupportedCompression, c)
+		}
+
+		uo.compression = &layerCompression{algorithm: c, level: level}
+		return nil
+	}
+}
+
+// OptUpdateForceOCIIndex specifies that the RootIndex, and any child indexes, are converted to OCI
+// media types before being stored. Image manifests and layer blobs are not modified.
+func OptUpdateForceOCIIndex() UpdateOpt {
+	return func(uo *updateOpts) error {
+		uo.forceOCIIndex = true
+		return nil
+	}
+}
+
+// OptUpdateStampCreated specifies that each RootIndex entry without an AddedAnnotation is
+// annotated with t. This records when each entry was added, providing an ordering key for
+// retention (e.g. TrimTo).
+func OptUpdateStampCreated(t time.Time) UpdateOpt {
+	return func(uo *updateOpts) error {
+		uo.created = t
+		return nil
+	}
+}
+
+// OptUpdatePromoteLabels specifies that the config labels with the supplied keys are recorded on
+// each RootIndex entry that references an image, using annotations with LabelAnnotationPrefix.
+// This allows labels to be queried from the RootIndex alone (e.g. by FindImagesByLabel), without
+// reading each config. Labels that are not set in the config of an image are not recorded.
+func OptUpdatePromoteLabels(keys ...string) UpdateOpt {
+	return func(uo *updateOpts) error {
+		uo.labels = append(uo.labels, keys...)
+		return nil
+	}
+}
+
+// trackProgress arranges for fn to be called as each blob in refs not already present in f is
+// written.
+func (f *fileImage) trackProgress(
+	refs map[v1.Hash]v1.Descriptor, fn func(blobsDone, blobsTotal int, bytesDone, bytesTotal int64),
+) error {
+	pending := make(map[v1.Hash]int64)
+
+	var bytesTotal int64
+
+	for h, desc := range refs {
+		ok, err := f.hasBlob(h)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			pending[h] = desc.Size
+			bytesTotal += desc.Size
+		}
+	}
+
+	var blobsDone int
+
+	var bytesDone int64
+
+	f.written = func(h v1.Hash) {
+		size, ok := pending[h]
+		if !ok {
+			return
+		}
+
+		blobsDone++
+		bytesDone += size
+
+		fn(blobsDone, len(pending), bytesDone, bytesTotal)
+	}
+
+	return nil
+}
+
+// OptUpdateProgress specifies that fn is called each time a blob is written to the SIF. The totals
+// reflect the blobs referenced by the image index that are not already present in the SIF, with
+// sizes taken from the descriptors in the image index and manifests. If no blobs are to be
+// written, fn is not called.
+func OptUpdateProgress(fn func(blobsDone, blobsTotal int, bytesDone, bytesTotal int64)) UpdateOpt {
+	return func(uo *updateOpts) error {
+		uo.progress = fn
+		return nil
+	}
+}
+
+// Update modifies fi so that it holds the content of ii. Blobs in fi that are not referenced by ii
+// are removed, and blobs referenced by ii that are not present in fi are added. The RootIndex of
+// fi is replaced with the manifest of ii.
+//
+// By default, the RootIndex is stored with the media type of ii, which may be a Docker manifest
+// list. To convert the stored indexes to OCI media types, consider using OptUpdateForceOCIIndex.
+// To record when RootIndex entries were added, consider using OptUpdateStampCreated. To record
+// config labels in the RootIndex, consider using OptUpdatePromoteLabels. To recompress layers
+// before they are stored, consider using OptUpdateRecompressLayers.
+//
+// New blobs, and the new RootIndex, are written before the existing RootIndex and unreferenced
+// blobs are removed, so that a failure leaves the existing content of fi intact. As a result, fi
+// must have a free descriptor for each blob to be written. If it does not, an error is returned
+// before fi is modified. A further free descriptor allows the RootIndex to be replaced safely;
+// without one, the existing RootIndex is removed immediately before the new one is written.
+//
+// Removed objects are not compacted, so fi does not shrink. To reclaim space, consider using
+// CompactInPlace.
+//
+// Before fi is modified, Update checks that it was opened for writing, and places an exclusive
+// advisory lock on the underlying file for the duration of the update. If fi was opened read-only,
+// ErrReadOnly is returned. If another handle holds a lock on the file, ErrLocked is returned. The
+// underlying file is located via the objects in fi, so if fi contains no objects, these checks
+// cannot be performed, and an error is returned. To create a SIF that can be updated, consider
+// using CreateEmpty.
+func Update(fi *sif.FileImage, ii v1.ImageIndex, opts ...UpdateOpt) error {
+	uo := updateOpts{}
+
+	for _, opt := range opts {
+		if err := opt(&uo); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return update(fi, ii, uo)
+}
+
+var errInsufficientCapacity = errors.New("insufficient descriptor capacity")
+
+// update modifies fi so that it holds the content of ii, as described for Update. The caller is
+// responsible for locking fi.
+func update(fi *sif.FileImage, ii v1.ImageIndex, uo updateOpts) error {
+	if uo.compression != nil {
+		conv, err := recompressIndex(ii, *uo.compression)
+		if err != nil {
+			return err
+		}
+		ii = conv
+	}
+
+	if uo.forceOCIIndex {
+		conv, err := toOCIIndex(ii)
+		if err != nil {
+			return err
+		}
+		ii = conv
+	}
+
+	if !uo.created.IsZero() {
+		stamped, err := stampCreated(ii, uo.created)
+		if err != nil {
+			return err
+		}
+		ii = stamped
+	}
+
+	if len(uo.labels) > 0 {
+		promoted, err := promoteLabels(ii, uo.labels)
+		if err != nil {
+			return err
+		}
+		ii = promoted
+	}
+
+	refs := make(map[v1.Hash]v1.Descriptor)
+	if err := referencedBlobs(ii, refs); err != nil {
+		return err
+	}
+
+	f := &fileImage{FileImage: fi}
+
+	if uo.progress != nil {
+		if err := f.trackProgress(refs, uo.progress); err != nil {
+			return err
+		}
+	}
+
+	// Check that there is sufficient descriptor capacity to write the blobs not present in fi
+	// before any content is written.
+	var missing int64
+
+	for h := range refs {
+		ok, err := f.hasBlob(h)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			missing++
+		}
+	}
+
+	if free := f.DescriptorsFree(); missing > free {
+		return fmt.Errorf("%w: %v blobs to write, %v descriptors free", errInsufficientCapacity, missing, free)
+	}
+
+	// Identify blobs not referenced by ii before any content is written.
+	ds, err := f.GetDescriptors(func(d sif.Descriptor) (bool, error) {
+		if d.DataType() != sif.DataOCIBlob {
+			return false, nil
+		}
+
+		h, err := d.OCIBlobDigest()
+		if err != nil {
+			return false, err
+		}
+
+		_, ok := refs[h]
+		return !ok, nil
+	})
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return err
+	}
+
+	// Write the new content, then replace the RootIndex, before removing anything else. A failure
+	// therefore leaves the existing RootIndex, and the blobs it references, intact. The content of
+	// ii may also be read from blobs that are about to be removed.
+	if err := f.writeIndexContentToFileImage(ii); err != nil {
+		return err
+	}
+
+	m, err := ii.RawManifest()
+	if err != nil {
+		return err
+	}
+
+	if err := f.replaceRootIndex(m); err != nil {
+		return err
+	}
+
+	for _, d := range ds {
+		if err := f.DeleteObject(d.ID()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var errIncompatibleMediaType = errors.New("incompatible media type")
+
+// AppendImage modifies fi so that its RootIndex includes img, in addition to the existing entries.
+// Where the config of img specifies a platform, it is recorded in the new RootIndex entry. If fi
+// does not contain a RootIndex, one is created. However, as described for Update, fi must contain
+// at least one object so that it can be locked; if it contains none, an error is returned.
+//
+// The media type of the RootIndex is retained. As a Docker manifest list may only reference Docker
+// image manifests, an error is returned if the RootIndex is a Docker manifest list and img is not
+// a Docker image.
+//
+// Only blobs of img that are not already present in fi are written; existing blobs, including
+// layers shared with img, are not rewritten. The RootIndex is written as described for Update, and
+// opts are applied accordingly.
+//
+// Before fi is modified, AppendImage checks that it was opened for writing, and places an
+// exclusive advisory lock on the underlying file, as described for Update. The lock is held while
+// the existing RootIndex is read, so that concurrent appends are not lost.
+func AppendImage(fi *sif.FileImage, img v1.Image, opts ...UpdateOpt) error {
+	uo := updateOpts{}
+
+	for _, opt := range opts {
+		if err := opt(&uo); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockForUpdate(fi)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f := &fileImage{FileImage: fi}
+
+	im, err := f.rootIndexManifest()
+	if err != nil {
+		return err
+	}
+
+	desc, err := partial.Descriptor(img)
+	if err != nil {
+		return err
+	}
+
+	if im.MediaType == types.DockerManifestList && desc.MediaType != types.DockerManifestSchema2 {
+		return fmt.Errorf("%w: cannot add %v to %v", errIncompatibleMediaType, desc.MediaType, im.MediaType)
+	}
+
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return err
+	}
+
+	if p := cf.Platform(); p != nil {
+		desc.Platform = p
+	}
+
+	// Retain the media type of an empty RootIndex.
+	ii := mutate.IndexMediaType(empty.Index, im.MediaType)
+
+	if len(im.Manifests) > 0 {
+		if ii, err = f.ImageIndex(); err != nil {
+			return err
+		}
+	}
+
+	ii = mutate.AppendManifests(ii, mutate.IndexAddendum{
+		Add:        img,
+		Descriptor: *desc,
+	})
+
+	return update(fi, ii, uo)
+}
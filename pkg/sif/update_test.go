@@ -0,0 +1,955 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/compression"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	ggcrmutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// indexMediaTypes returns the media types of ii and all child indexes, in depth-first order.
+func indexMediaTypes(tb testing.TB, ii v1.ImageIndex) []types.MediaType {
+	tb.Helper()
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	mts := []types.MediaType{im.MediaType}
+
+	for _, desc := range im.Manifests {
+		if !desc.MediaType.IsIndex() {
+			continue
+		}
+
+		child, err := ii.ImageIndex(desc.Digest)
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		childMTs := indexMediaTypes(tb, child)
+
+		if got, want := desc.MediaType, childMTs[0]; got != want {
+			tb.Errorf("got descriptor media type %v, want %v", got, want)
+		}
+
+		mts = append(mts, childMTs...)
+	}
+
+	return mts
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           []sif.UpdateOpt
+		wantMediaTypes []types.MediaType
+	}{
+		{
+			name:           "Default",
+			wantMediaTypes: []types.MediaType{types.DockerManifestList},
+		},
+		{
+			name:           "ForceOCIIndex",
+			opts:           []sif.UpdateOpt{sif.OptUpdateForceOCIIndex()},
+			wantMediaTypes: []types.MediaType{types.OCIImageIndex},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := fileImageFromPath(t, "many-layers", sif.OptWriteWithSpareDescriptorCapacity(32))
+
+			if err := sif.Update(fi, corpus.ImageIndex(t, "hello-world-docker-v2-manifest-list"), tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			ii, err := sif.ImageIndexFromFileImage(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Index(ii); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := sif.VerifyReferences(fi); err != nil {
+				t.Fatal(err)
+			}
+
+			got := indexMediaTypes(t, ii)
+			if len(got) != len(tt.wantMediaTypes) {
+				t.Fatalf("got media types %v, want %v", got, tt.wantMediaTypes)
+			}
+
+			for i := range got {
+				if got[i] != tt.wantMediaTypes[i] {
+					t.Errorf("got media types %v, want %v", got, tt.wantMediaTypes)
+				}
+			}
+
+			// Blobs of the previous image are removed, leaving a manifest, config and layer for each
+			// of the 9 images in the index.
+			stats, err := sif.DataTypeBreakdown(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := stats[ssif.DataOCIBlob].Count, 27; got != want {
+				t.Errorf("got %v blobs, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestUpdateReadOnly(t *testing.T) {
+	path := corpus.SIF(t, "hello-world-docker-v2-manifest")
+
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := ssif.LoadContainerFromPath(path, ssif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	err = sif.Update(fi, corpus.ImageIndex(t, "hello-world-docker-v2-manifest-list"))
+	if got, want := err, sif.ErrReadOnly; !errors.Is(got, want) {
+		t.Fatalf("got error %v, want %v", got, want)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(before, after) {
+		t.Error("file modified")
+	}
+}
+
+func TestModifyReadOnly(t *testing.T) {
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	tests := []struct {
+		name   string
+		modify func(*ssif.FileImage) error
+	}{
+		{
+			name:   "AppendImage",
+			modify: func(fi *ssif.FileImage) error { return sif.AppendImage(fi, img) },
+		},
+		{
+			name:   "CompactInPlace",
+			modify: func(fi *ssif.FileImage) error { return sif.CompactInPlace(fi) },
+		},
+		{
+			name:   "EmbedOCI",
+			modify: func(fi *ssif.FileImage) error { return sif.EmbedOCI(fi, empty.Index) },
+		},
+		{
+			name:   "InlineConfigData",
+			modify: func(fi *ssif.FileImage) error { return sif.InlineConfigData(fi, 1<<20) },
+		},
+		{
+			name: "Migrate",
+			modify: func(fi *ssif.FileImage) error {
+				_, err := sif.Migrate(fi)
+				return err
+			},
+		},
+		{
+			name:   "PromoteToIndex",
+			modify: func(fi *ssif.FileImage) error { return sif.PromoteToIndex(fi, "hello-world:latest") },
+		},
+		{
+			name:   "RepackForStreaming",
+			modify: func(fi *ssif.FileImage) error { return sif.RepackForStreaming(fi) },
+		},
+		{
+			name:   "StreamPull",
+			modify: func(fi *ssif.FileImage) error { return sif.StreamPull(fi, "localhost/hello-world:latest") },
+		},
+		{
+			name:   "StripInlineData",
+			modify: func(fi *ssif.FileImage) error { return sif.StripInlineData(fi) },
+		},
+		{
+			name: "TrimTo",
+			modify: func(fi *ssif.FileImage) error {
+				return sif.TrimTo(fi, 0, func(sif.ImageEntry, sif.ImageEntry) bool { return false })
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := corpus.SIF(t, "hello-world-docker-v2-manifest")
+
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fi, err := ssif.LoadContainerFromPath(path, ssif.OptLoadWithFlag(os.O_RDONLY))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+			if got, want := tt.modify(fi), sif.ErrReadOnly; !errors.Is(got, want) {
+				t.Fatalf("got error %v, want %v", got, want)
+			}
+
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(before, after) {
+				t.Error("file modified")
+			}
+		})
+	}
+}
+
+func TestUpdateNoObjects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.sif")
+
+	fi, err := ssif.CreateContainerAtPath(path, ssif.OptCreateDeterministic())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fi.UnloadContainer(); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err = ssif.LoadContainerFromPath(path, ssif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	// The storage backing fi cannot be checked, so the update is rejected.
+	if err := sif.Update(fi, corpus.ImageIndex(t, "hello-world-docker-v2-manifest")); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(before, after) {
+		t.Error("file modified")
+	}
+}
+
+var errUnreadableLayer = errors.New("unreadable layer")
+
+// unreadableLayer wraps a layer, failing attempts to read its content.
+type unreadableLayer struct {
+	v1.Layer
+}
+
+// Compressed returns an error.
+func (unreadableLayer) Compressed() (io.ReadCloser, error) {
+	return nil, errUnreadableLayer
+}
+
+func TestUpdateFailure(t *testing.T) {
+	l := unreadableLayer{static.NewLayer([]byte("content"), types.OCILayer)}
+
+	img, err := ggcrmutate.AppendLayers(corpus.Image(t, "hello-world-docker-v2-manifest"), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		fi      *ssif.FileImage
+		ii      v1.ImageIndex
+		wantErr error
+	}{
+		{
+			name: "InsufficientCapacity",
+			fi:   fileImageFromPath(t, "many-layers"),
+			ii:   corpus.ImageIndex(t, "hello-world-docker-v2-manifest-list"),
+		},
+		{
+			name: "UnreadableLayer",
+			fi:   fileImageFromPath(t, "many-layers", sif.OptWriteWithSpareDescriptorCapacity(8)),
+			ii: ggcrmutate.AppendManifests(empty.Index, ggcrmutate.IndexAddendum{
+				Add: img,
+			}),
+			wantErr: errUnreadableLayer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := sif.ImageIndexFromFileImage(tt.fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := before.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sif.Update(tt.fi, tt.ii)
+			if err == nil {
+				t.Fatal("got nil error, want error")
+			}
+
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			// The existing RootIndex, and the blobs it references, are intact.
+			after, err := sif.ImageIndexFromFileImage(tt.fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := after.Digest(); err != nil {
+				t.Fatal(err)
+			} else if got != want {
+				t.Errorf("got digest %v, want %v", got, want)
+			}
+
+			if err := sif.VerifyReferences(tt.fi); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+var errUnreadableConfig = errors.New("unreadable config")
+
+// unreadableConfigImage wraps an image, failing attempts to read its config.
+type unreadableConfigImage struct {
+	v1.Image
+}
+
+// ConfigFile returns an error.
+func (unreadableConfigImage) ConfigFile() (*v1.ConfigFile, error) {
+	return nil, errUnreadableConfig
+}
+
+func TestAppendImageFailure(t *testing.T) {
+	fi := fileImageFromPath(t, "many-layers", sif.OptWriteWithSpareDescriptorCapacity(8))
+
+	before, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := before.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := unreadableConfigImage{corpus.Image(t, "hello-world-docker-v2-manifest")}
+
+	if err := sif.AppendImage(fi, img); !errors.Is(err, errUnreadableConfig) {
+		t.Fatalf("got error %v, want %v", err, errUnreadableConfig)
+	}
+
+	// The existing RootIndex is intact.
+	after, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := after.Digest(); err != nil {
+		t.Fatal(err)
+	} else if got != want {
+		t.Errorf("got digest %v, want %v", got, want)
+	}
+}
+
+func TestUpdateStampCreated(t *testing.T) {
+	fi := emptyFileImage(t, 128)
+
+	older := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	// Add an image, then add a second image an hour later.
+	var ii v1.ImageIndex = ggcrmutate.AppendManifests(empty.Index, ggcrmutate.IndexAddendum{
+		Add: corpus.Image(t, "hello-world-docker-v2-manifest"),
+		Descriptor: v1.Descriptor{
+			Annotations: map[string]string{"org.opencontainers.image.ref.name": "old:latest"},
+		},
+	})
+
+	if err := sif.Update(fi, ii, sif.OptUpdateStampCreated(older)); err != nil {
+		t.Fatal(err)
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ii = ggcrmutate.AppendManifests(ii, ggcrmutate.IndexAddendum{
+		Add: corpus.Image(t, "many-layers"),
+		Descriptor: v1.Descriptor{
+			Annotations: map[string]string{"org.opencontainers.image.ref.name": "new:latest"},
+		},
+	})
+
+	if err := sif.Update(fi, ii, sif.OptUpdateStampCreated(newer)); err != nil {
+		t.Fatal(err)
+	}
+
+	ii, err = sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCreated := map[string]string{
+		"old:latest": "2023-01-02T03:04:05Z",
+		"new:latest": "2023-01-02T04:04:05Z",
+	}
+
+	for _, desc := range im.Manifests {
+		ref := desc.Annotations["org.opencontainers.image.ref.name"]
+
+		if got, want := desc.Annotations[sif.AddedAnnotation], wantCreated[ref]; got != want {
+			t.Errorf("%v: got created %q, want %q", ref, got, want)
+		}
+
+		// The OCI annotation records when the image was built, so is not set.
+		if v, ok := desc.Annotations["org.opencontainers.image.created"]; ok {
+			t.Errorf("%v: got unexpected created annotation %q", ref, v)
+		}
+	}
+
+	// The added annotation orders entries for retention.
+	if err := sif.TrimTo(fi, 1, func(a, b sif.ImageEntry) bool {
+		return a.Descriptor.Annotations[sif.AddedAnnotation] > b.Descriptor.Annotations[sif.AddedAnnotation]
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sif.GetImage(fi, "new:latest"); err != nil {
+		t.Errorf("got error %v getting newer image", err)
+	}
+
+	if _, err := sif.GetImage(fi, "old:latest"); err == nil {
+		t.Error("got nil error getting older image")
+	}
+}
+
+func TestAppendImage(t *testing.T) {
+	fi := emptyFileImage(t, 128)
+
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	// Append an image to an empty SIF.
+	if err := sif.AppendImage(fi, base); err != nil {
+		t.Fatal(err)
+	}
+
+	blobIDs := func() map[v1.Hash]uint32 {
+		ds, err := fi.GetDescriptors(ssif.WithDataType(ssif.DataOCIBlob))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make(map[v1.Hash]uint32, len(ds))
+		for _, d := range ds {
+			h, err := d.OCIBlobDigest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids[h] = d.ID()
+		}
+		return ids
+	}
+
+	before := blobIDs()
+
+	// Append a second image, which shares a layer with the first.
+	img := labeledImage(t, base, "key", "value")
+
+	if err := sif.AppendImage(fi, img); err != nil {
+		t.Fatal(err)
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(im.Manifests), 2; got != want {
+		t.Fatalf("got %v manifests, want %v", got, want)
+	}
+
+	for i, want := range []v1.Image{base, img} {
+		h, err := want.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := im.Manifests[i].Digest; got != h {
+			t.Errorf("got digest %v, want %v", got, h)
+		}
+
+		if p := im.Manifests[i].Platform; p == nil || p.Architecture != "arm64" {
+			t.Errorf("got platform %v, want arm64", p)
+		}
+	}
+
+	// Blobs already present were not rewritten.
+	after := blobIDs()
+
+	for h, id := range before {
+		if got, want := after[h], id; got != want {
+			t.Errorf("blob %v: got ID %v, want %v", h, got, want)
+		}
+	}
+
+	// The config and manifest of the second image were added.
+	if got, want := len(after), len(before)+2; got != want {
+		t.Errorf("got %v blobs, want %v", got, want)
+	}
+}
+
+// configCountingImage is a v1.Image that counts calls to RawConfigFile. As is common, its config
+// name is derived from the config itself.
+type configCountingImage struct {
+	v1.Image
+	n *int
+}
+
+func (img configCountingImage) ConfigName() (v1.Hash, error) {
+	return partial.ConfigName(img)
+}
+
+func (img configCountingImage) RawConfigFile() ([]byte, error) {
+	*img.n++
+	return img.Image.RawConfigFile()
+}
+
+func TestUpdateConfigPresent(t *testing.T) {
+	fi := emptyFileImage(t, 128)
+
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	if err := sif.AppendImage(fi, base); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add the same image again, from a source that counts config reads.
+	var n int
+
+	ii := ggcrmutate.AppendManifests(empty.Index,
+		ggcrmutate.IndexAddendum{Add: base},
+		ggcrmutate.IndexAddendum{Add: configCountingImage{base, &n}},
+	)
+
+	if err := sif.Update(fi, ii); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config is already present, so it is not read.
+	if got, want := n, 0; got != want {
+		t.Errorf("got %v config reads, want %v", got, want)
+	}
+}
+
+func TestUpdateProgress(t *testing.T) {
+	fi := emptyFileImage(t, 128)
+
+	ii := corpus.ImageIndex(t, "hello-world-docker-v2-manifest-list")
+
+	type call struct {
+		blobsDone, blobsTotal int
+		bytesDone, bytesTotal int64
+	}
+
+	var calls []call
+
+	progress := sif.OptUpdateProgress(func(blobsDone, blobsTotal int, bytesDone, bytesTotal int64) {
+		calls = append(calls, call{blobsDone, blobsTotal, bytesDone, bytesTotal})
+	})
+
+	if err := sif.Update(fi, ii, progress); err != nil {
+		t.Fatal(err)
+	}
+
+	// A manifest, config and layer is written for each of the 9 images in the index.
+	if got, want := len(calls), 27; got != want {
+		t.Fatalf("got %v calls, want %v", got, want)
+	}
+
+	for i, c := range calls {
+		if got, want := c.blobsDone, i+1; got != want {
+			t.Errorf("got blobs done %v, want %v", got, want)
+		}
+
+		if i > 0 && c.bytesDone <= calls[i-1].bytesDone {
+			t.Errorf("got bytes done %v, want more than %v", c.bytesDone, calls[i-1].bytesDone)
+		}
+	}
+
+	last := calls[len(calls)-1]
+
+	if got, want := last.blobsDone, last.blobsTotal; got != want {
+		t.Errorf("got blobs done %v, want %v", got, want)
+	}
+
+	if got, want := last.bytesDone, last.bytesTotal; got != want {
+		t.Errorf("got bytes done %v, want %v", got, want)
+	}
+
+	// Updating again writes no blobs, so the callback is not called.
+	calls = nil
+
+	if err := sif.Update(fi, ii, progress); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(calls); got != 0 {
+		t.Errorf("got %v calls, want 0", got)
+	}
+}
+
+func TestUpdatePromoteLabels(t *testing.T) {
+	fi := emptyFileImage(t, 128)
+
+	img := labeledImage(t, corpus.Image(t, "hello-world-docker-v2-manifest"), "version", "1.2.3")
+
+	if err := sif.AppendImage(fi, img, sif.OptUpdatePromoteLabels("version", "maintainer")); err != nil {
+		t.Fatal(err)
+	}
+
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Index(ii); err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annotations := im.Manifests[0].Annotations
+
+	if got, want := annotations[sif.LabelAnnotationPrefix+"version"], "1.2.3"; got != want {
+		t.Errorf("got version annotation %q, want %q", got, want)
+	}
+
+	// Labels not set in the config are not recorded.
+	if v, ok := annotations[sif.LabelAnnotationPrefix+"maintainer"]; ok {
+		t.Errorf("got maintainer annotation %q", v)
+	}
+
+	// The promoted label is answered from the RootIndex, so the config is not required.
+	h, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteBlob(t, fi, h)
+
+	es, err := sif.FindImagesByLabel(fi, "version", "1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(es), 1; got != want {
+		t.Fatalf("got %v images, want %v", got, want)
+	}
+
+	if got, want := es[0].Descriptor.Digest, im.Manifests[0].Digest; got != want {
+		t.Errorf("got digest %v, want %v", got, want)
+	}
+
+	if es, err := sif.FindImagesByLabel(fi, "version", "4.5.6"); err != nil {
+		t.Fatal(err)
+	} else if len(es) != 0 {
+		t.Errorf("got %v images, want 0", len(es))
+	}
+}
+
+func TestUpdateRecompressLayers(t *testing.T) {
+	docker := corpus.Image(t, "many-layers")
+	oci := ggcrmutate.ConfigMediaType(ggcrmutate.MediaType(docker, types.OCIManifestSchema1), types.OCIConfigJSON)
+
+	tests := []struct {
+		name          string
+		img           v1.Image
+		c             compression.Compression
+		level         int
+		wantMediaType types.MediaType
+		wantErr       bool
+	}{
+		{
+			name:          "DockerGzip",
+			img:           docker,
+			c:             compression.GZip,
+			level:         gzip.BestCompression,
+			wantMediaType: types.DockerLayer,
+		},
+		{
+			name:          "DockerNone",
+			img:           docker,
+			c:             compression.None,
+			wantMediaType: types.DockerUncompressedLayer,
+		},
+		{
+			name:    "DockerZstd",
+			img:     docker,
+			c:       compression.ZStd,
+			wantErr: true,
+		},
+		{
+			name:          "OCIZstd",
+			img:           oci,
+			c:             compression.ZStd,
+			level:         3,
+			wantMediaType: types.OCILayerZStd,
+		},
+		{
+			name:          "OCINone",
+			img:           oci,
+			c:             compression.None,
+			wantMediaType: types.OCIUncompressedLayer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := emptyFileImage(t, 128)
+
+			ii := ggcrmutate.AppendManifests(empty.Index, ggcrmutate.IndexAddendum{Add: tt.img})
+
+			err := sif.Update(fi, ii, sif.OptUpdateRecompressLayers(tt.c, tt.level))
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err := sif.Verify(fi); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := sif.ImageIndexFromFileImage(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Layer content is checked below, as validate only supports gzip-compressed layers.
+			if err := validate.Index(got, validate.Fast); err != nil {
+				t.Fatal(err)
+			}
+
+			im, err := got.IndexManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := got.Image(im.Manifests[0].Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, l := range m.Layers {
+				if got, want := l.MediaType, tt.wantMediaType; got != want {
+					t.Errorf("layer %v: got media type %v, want %v", i, got, want)
+				}
+			}
+
+			// The uncompressed content of each layer is unchanged.
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ls, err := img.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, l := range ls {
+				if got, err := l.DiffID(); err != nil {
+					t.Fatal(err)
+				} else if want := cf.RootFS.DiffIDs[i]; got != want {
+					t.Errorf("layer %v: got diffID %v, want %v", i, got, want)
+				}
+			}
+
+			// The config is unchanged.
+			gotConfig, err := img.ConfigName()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantConfig, err := tt.img.ConfigName()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if gotConfig != wantConfig {
+				t.Errorf("got config %v, want %v", gotConfig, wantConfig)
+			}
+		})
+	}
+}
+
+func TestUpdateRecompressLayersInPlace(t *testing.T) {
+	tests := []struct {
+		name      string
+		update    func(*ssif.FileImage, v1.ImageIndex, ...sif.UpdateOpt) error
+		wantCount int
+	}{
+		{
+			name:      "Update",
+			update:    sif.Update,
+			wantCount: 1,
+		},
+		{
+			name: "AppendImage",
+			update: func(fi *ssif.FileImage, ii v1.ImageIndex, opts ...sif.UpdateOpt) error {
+				im, err := ii.IndexManifest()
+				if err != nil {
+					return err
+				}
+
+				img, err := ii.Image(im.Manifests[0].Digest)
+				if err != nil {
+					return err
+				}
+
+				return sif.AppendImage(fi, img, opts...)
+			},
+			wantCount: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := fileImageFromPath(t, "many-layers", sif.OptWriteWithSpareDescriptorCapacity(64))
+
+			ii, err := sif.ImageIndexFromFileImage(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The content of fi is read lazily, so it is read while fi is updated.
+			if err := tt.update(fi, ii, sif.OptUpdateRecompressLayers(compression.None, 0)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := sif.Verify(fi); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := sif.ImageIndexFromFileImage(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Index(got, validate.Fast); err != nil {
+				t.Fatal(err)
+			}
+
+			im, err := got.IndexManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := len(im.Manifests), tt.wantCount; got != want {
+				t.Fatalf("got %v manifests, want %v", got, want)
+			}
+
+			// The most recent entry holds the recompressed image.
+			img, err := got.Image(im.Manifests[len(im.Manifests)-1].Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, l := range m.Layers {
+				if got, want := l.MediaType, types.DockerUncompressedLayer; got != want {
+					t.Errorf("layer %v: got media type %v, want %v", i, got, want)
+				}
+			}
+
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ls, err := img.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, l := range ls {
+				if got, err := l.DiffID(); err != nil {
+					t.Fatal(err)
+				} else if want := cf.RootFS.DiffIDs[i]; got != want {
+					t.Errorf("layer %v: got diffID %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+var (
+	errDanglingReference = errors.New("dangling reference")
+	errDigestMismatch    = errors.New("digest mismatch")
+	errDiffIDMismatch    = errors.New("diff ID mismatch")
+)
+
+// referenceVerifier walks the descriptors reachable from the RootIndex of a SIF image, recording
+// those that do not correspond to a stored blob. If manifestsOnly is set, only index and image
+// manifests are verified. If content is set, the digest of each stored blob is also verified.
+type referenceVerifier struct {
+	f             *fileImage
+	manifestsOnly bool
+	content       bool
+	seen          map[v1.Hash]bool
+	errs          []error
+}
+
+// verifyIndex verifies the descriptors in the index manifest with the supplied content.
+func (rv *referenceVerifier) verifyIndex(b []byte) error {
+	var im v1.IndexManifest
+	if err := json.Unmarshal(b, &im); err != nil {
+		return err
+	}
+
+	for _, desc := range im.Manifests {
+		if err := rv.verifyDescriptor(desc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyManifest verifies the config and layer descriptors in the image manifest with the supplied
+// content.
+func (rv *referenceVerifier) verifyManifest(b []byte) error {
+	var m v1.Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	if rv.manifestsOnly {
+		return nil
+	}
+
+	if err := rv.verifyDescriptor(m.Config); err != nil {
+		return err
+	}
+
+	for _, desc := range m.Layers {
+		// Non-distributable layers are not expected to be stored.
+		if !desc.MediaType.IsDistributable() {
+			continue
+		}
+
+		if err := rv.verifyDescriptor(desc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyDescriptor verifies that the blob referenced by desc is stored. If desc references an index
+// or image manifest, the descriptors it contains are verified recursively.
+func (rv *referenceVerifier) verifyDescriptor(desc v1.Descriptor) error {
+	if rv.seen[desc.Digest] {
+		return nil
+	}
+	rv.seen[desc.Digest] = true
+
+	if ok, err := rv.f.hasBlob(desc.Digest); err != nil {
+		return err
+	} else if !ok {
+		rv.errs = append(rv.errs, fmt.Errorf("%w: %v", errDanglingReference, desc.Digest))
+		return nil
+	}
+
+	if rv.content {
+		if ok, err := rv.verifyContent(desc.Digest); err != nil || !ok {
+			return err
+		}
+	}
+
+	if !desc.MediaType.IsIndex() && !desc.MediaType.IsImage() {
+		return nil
+	}
+
+	b, err := rv.f.Bytes(desc.Digest)
+	if err != nil {
+		return err
+	}
+
+	if desc.MediaType.IsIndex() {
+		return rv.verifyIndex(b)
+	}
+	return rv.verifyManifest(b)
+}
+
+// verifyContent verifies that the content of the stored blob with digest h matches h. If not, the
+// mismatch is recorded and false is returned.
+func (rv *referenceVerifier) verifyContent(h v1.Hash) (bool, error) {
+	d, err := rv.f.GetDescriptor(sif.WithOCIBlobDigest(h))
+	if err != nil {
+		return false, err
+	}
+
+	got, _, err := v1.SHA256(d.GetReader())
+	if err != nil {
+		return false, err
+	}
+
+	if got != h {
+		rv.errs = append(rv.errs, fmt.Errorf("%w: %v has digest %v", errDigestMismatch, h, got))
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// VerifyReferences verifies that every manifest, config and layer referenced from the RootIndex of
+// fi corresponds to a stored OCI blob. The content of blobs is not verified. If any references are
+// dangling, the returned error describes all of them.
+func VerifyReferences(fi *sif.FileImage) error {
+	return verifyReferences(fi, false)
+}
+
+// Verify verifies that every manifest, config and layer referenced from the RootIndex of fi
+// corresponds to a stored OCI blob, and that the content of each blob matches its digest. Every
+// stored blob is read in full. If any references are dangling, or any blobs are corrupt, the
+// returned error describes all of them.
+//
+// To verify references without reading the content of config and layer blobs, consider using
+// VerifyReferences.
+func Verify(fi *sif.FileImage) error {
+	rv := referenceVerifier{
+		f:       &fileImage{FileImage: fi},
+		content: true,
+		seen:    make(map[v1.Hash]bool),
+	}
+
+	return rv.verify()
+}
+
+// verifyReferences verifies the references reachable from the RootIndex of fi. If manifestsOnly is
+// set, only references to index and image manifests are verified.
+func verifyReferences(fi *sif.FileImage, manifestsOnly bool) error {
+	rv := referenceVerifier{
+		f:             &fileImage{FileImage: fi},
+		manifestsOnly: manifestsOnly,
+		seen:          make(map[v1.Hash]bool),
+	}
+
+	return rv.verify()
+}
+
+// verify verifies the references reachable from the RootIndex. If any problems are found, the
+// returned error describes all of them.
+func (rv *referenceVerifier) verify() error {
+	d, err := rv.f.GetDescriptor(sif.WithDataType(sif.DataOCIRootIndex))
+	if err != nil {
+		return err
+	}
+
+	b, err := d.GetData()
+	if err != nil {
+		return err
+	}
+
+	if err := rv.verifyIndex(b); err != nil {
+		return err
+	}
+
+	return errors.Join(rv.errs...)
+}
+
+// OpenImageValidated opens the SIF image at path, and performs a fast structural validation of the
+// OCI content it contains. The RootIndex must be present and parse successfully, and every index
+// and image manifest reachable from it must be stored. If writable is set, the image is opened for
+// writing; otherwise it is opened read-only.
+//
+// Configs and layers are not verified, and the content of blobs is not read. To verify that config
+// and layer blobs are stored, consider using VerifyReferences.
+//
+// The caller is responsible for calling UnloadContainer on the returned image.
+func OpenImageValidated(path string, writable bool) (*sif.FileImage, error) {
+	flag := os.O_RDONLY
+	if writable {
+		flag = os.O_RDWR
+	}
+
+	fi, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(flag))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyReferences(fi, true); err != nil {
+		_ = fi.UnloadContainer()
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+// verifyChainOpts accumulates chain verification options.
+type verifyChainOpts struct {
+	diffIDs bool
+}
+
+// VerifyChainOpt are used to specify chain verification options.
+type VerifyChainOpt func(*verifyChainOpts) error
+
+// OptVerifyChainDiffIDs specifies whether the diff IDs in the image config are verified against
+// the uncompressed content of the corresponding layers. This requires every layer to be read and
+// decompressed, so is expensive for large images.
+func OptVerifyChainDiffIDs(b bool) VerifyChainOpt {
+	return func(vo *verifyChainOpts) error {
+		vo.diffIDs = b
+		return nil
+	}
+}
+
+// VerifyChain verifies the consistency of the image in fi referenced by ref. The config blob must
+// be stored and match the digest in the image manifest, each layer referenced by the manifest must
+// be stored, and the config must record a diff ID for each layer.
+//
+// By default, the content of layers is not read. To verify the diff IDs in the config against the
+// uncompressed layer content, consider using OptVerifyChainDiffIDs.
+func VerifyChain(fi *sif.FileImage, ref string, opts ...VerifyChainOpt) error {
+	vo := verifyChainOpts{}
+
+	for _, opt := range opts {
+		if err := opt(&vo); err != nil {
+			return err
+		}
+	}
+
+	img, err := GetImage(fi, ref)
+	if err != nil {
+		return err
+	}
+
+	m, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+
+	f := &fileImage{FileImage: fi}
+
+	// Verify the config blob.
+	if ok, err := f.hasBlob(m.Config.Digest); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("%w: config %v", errDanglingReference, m.Config.Digest)
+	}
+
+	b, err := f.Bytes(m.Config.Digest)
+	if err != nil {
+		return err
+	}
+
+	if h, _, err := v1.SHA256(bytes.NewReader(b)); err != nil {
+		return err
+	} else if h != m.Config.Digest {
+		return fmt.Errorf("%w: config %v has digest %v", errDigestMismatch, m.Config.Digest, h)
+	}
+
+	var cf v1.ConfigFile
+	if err := json.Unmarshal(b, &cf); err != nil {
+		return err
+	}
+
+	// Verify the layer blobs.
+	for _, desc := range m.Layers {
+		if !desc.MediaType.IsDistributable() {
+			continue
+		}
+
+		if ok, err := f.hasBlob(desc.Digest); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%w: layer %v", errDanglingReference, desc.Digest)
+		}
+	}
+
+	if got, want := len(cf.RootFS.DiffIDs), len(m.Layers); got != want {
+		return fmt.Errorf("%w: config has %v diff IDs, manifest has %v layers", errDiffIDMismatch, got, want)
+	}
+
+	if !vo.diffIDs {
+		return nil
+	}
+
+	ls, err := img.Layers()
+	if err != nil {
+		return err
+	}
+
+	for i, l := range ls {
+		if !m.Layers[i].MediaType.IsDistributable() {
+			continue
+		}
+
+		h, err := l.DiffID()
+		if err != nil {
+			return err
+		}
+
+		if want := cf.RootFS.DiffIDs[i]; h != want {
+			return fmt.Errorf("%w: layer %v has diff ID %v, config has %v",
+				errDiffIDMismatch, m.Layers[i].Digest, h, want,
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,457 @@
+// Copyright 2023 Sylabs Inc. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sif_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sylabs/oci-tools/pkg/sif"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// deleteBlob deletes the blob with digest h from fi, without updating any references to it.
+func deleteBlob(tb testing.TB, fi *ssif.FileImage, h v1.Hash) {
+	tb.Helper()
+
+	d, err := fi.GetDescriptor(ssif.WithOCIBlobDigest(h))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := fi.DeleteObject(d.ID()); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestVerifyReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		deleted func(img v1.Image) []v1.Hash
+	}{
+		{
+			name: "Intact",
+			path: "many-layers",
+		},
+		{
+			name: "DanglingLayers",
+			path: "many-layers",
+			deleted: func(img v1.Image) []v1.Hash {
+				ls, err := img.Layers()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var hs []v1.Hash
+				for _, l := range []v1.Layer{ls[0], ls[len(ls)-1]} {
+					h, err := l.Digest()
+					if err != nil {
+						t.Fatal(err)
+					}
+					hs = append(hs, h)
+				}
+				return hs
+			},
+		},
+		{
+			name: "DanglingConfig",
+			path: "hello-world-docker-v2-manifest",
+			deleted: func(img v1.Image) []v1.Hash {
+				h, err := img.ConfigName()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return []v1.Hash{h}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := fileImageFromPath(t, tt.path)
+
+			var deleted []v1.Hash
+
+			if tt.deleted != nil {
+				ii, err := sif.ImageIndexFromFileImage(fi)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				im, err := ii.IndexManifest()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				img, err := ii.Image(im.Manifests[0].Digest)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				deleted = tt.deleted(img)
+				for _, h := range deleted {
+					deleteBlob(t, fi, h)
+				}
+			}
+
+			err := sif.VerifyReferences(fi)
+			if got, want := err != nil, len(deleted) > 0; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			for _, h := range deleted {
+				if !strings.Contains(err.Error(), h.String()) {
+					t.Errorf("error %q does not report %v", err, h)
+				}
+			}
+		})
+	}
+}
+
+// corruptBlob overwrites the first byte of the blob with digest h in the SIF at path.
+func corruptBlob(tb testing.TB, path string, h v1.Hash) {
+	tb.Helper()
+
+	fi, err := ssif.LoadContainerFromPath(path, ssif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer func() { _ = fi.UnloadContainer() }()
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt([]byte{0xff}, blobOffset(tb, fi, h)); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	config, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := ls[0].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		deleted   []v1.Hash
+		corrupted []v1.Hash
+	}{
+		{
+			name: "Intact",
+		},
+		{
+			name:      "CorruptLayer",
+			corrupted: []v1.Hash{layer},
+		},
+		{
+			name:      "MissingConfigCorruptLayer",
+			deleted:   []v1.Hash{config},
+			corrupted: []v1.Hash{layer},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := corpus.SIF(t, "hello-world-docker-v2-manifest")
+
+			for _, h := range tt.corrupted {
+				corruptBlob(t, path, h)
+			}
+
+			fi, err := ssif.LoadContainerFromPath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+			for _, h := range tt.deleted {
+				deleteBlob(t, fi, h)
+			}
+
+			// References are intact unless blobs were deleted.
+			if err := sif.VerifyReferences(fi); (err != nil) != (len(tt.deleted) > 0) {
+				t.Errorf("got VerifyReferences error %v", err)
+			}
+
+			err = sif.Verify(fi)
+			if got, want := err != nil, len(tt.deleted)+len(tt.corrupted) > 0; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			// All problems are reported.
+			for _, h := range append(tt.deleted, tt.corrupted...) {
+				if !strings.Contains(err.Error(), h.String()) {
+					t.Errorf("error %q does not report %v", err, h)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenImageValidated(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		writable bool
+		deleted  func(ii v1.ImageIndex) []v1.Hash
+		wantErr  bool
+	}{
+		{
+			name: "ReadOnly",
+			path: "hello-world-docker-v2-manifest",
+		},
+		{
+			name:     "Writable",
+			path:     "hello-world-docker-v2-manifest",
+			writable: true,
+		},
+		{
+			name: "MissingManifest",
+			path: "hello-world-docker-v2-manifest",
+			deleted: func(ii v1.ImageIndex) []v1.Hash {
+				im, err := ii.IndexManifest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return []v1.Hash{im.Manifests[0].Digest}
+			},
+			wantErr: true,
+		},
+		{
+			name: "MissingLayer",
+			path: "hello-world-docker-v2-manifest",
+			deleted: func(ii v1.ImageIndex) []v1.Hash {
+				im, err := ii.IndexManifest()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				img, err := ii.Image(im.Manifests[0].Digest)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ls, err := img.Layers()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				h, err := ls[0].Digest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return []v1.Hash{h}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := corpus.SIF(t, tt.path)
+
+			if tt.deleted != nil {
+				fi, err := ssif.LoadContainerFromPath(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ii, err := sif.ImageIndexFromFileImage(fi)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, h := range tt.deleted(ii) {
+					deleteBlob(t, fi, h)
+				}
+
+				if err := fi.UnloadContainer(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fi, err := sif.OpenImageValidated(path, tt.writable)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+
+			if err == nil {
+				if err := fi.UnloadContainer(); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+// rawImage is an image with the supplied raw config and manifest. Layers are read from base.
+type rawImage struct {
+	base        v1.Image
+	rawConfig   []byte
+	rawManifest []byte
+}
+
+func (im *rawImage) MediaType() (types.MediaType, error) {
+	return im.base.MediaType()
+}
+
+func (im *rawImage) RawConfigFile() ([]byte, error) {
+	return im.rawConfig, nil
+}
+
+func (im *rawImage) RawManifest() ([]byte, error) {
+	return im.rawManifest, nil
+}
+
+func (im *rawImage) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	return im.base.LayerByDigest(h)
+}
+
+// imageWithConfig returns an image with the layers of base and config cf. Unlike images returned
+// by the mutate packages, the config is not reconciled with the layers.
+func imageWithConfig(tb testing.TB, base v1.Image, cf *v1.ConfigFile) v1.Image {
+	tb.Helper()
+
+	rawConfig, err := json.Marshal(cf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	m, err := base.Manifest()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	m = m.DeepCopy()
+	m.Config.Digest, m.Config.Size, err = v1.SHA256(bytes.NewReader(rawConfig))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	rawManifest, err := json.Marshal(m)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	img, err := partial.CompressedToImage(&rawImage{
+		base:        base,
+		rawConfig:   rawConfig,
+		rawManifest: rawManifest,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return img
+}
+
+func TestVerifyChain(t *testing.T) {
+	good := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	// An image whose config records an incorrect diff ID for its layer.
+	cf, err := good.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cf = cf.DeepCopy()
+	cf.RootFS.DiffIDs[0] = v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "0000000000000000000000000000000000000000000000000000000000000000",
+	}
+
+	bad := imageWithConfig(t, good, cf)
+
+	fi := fileImageWithRefs(t,
+		taggedImage{"good:latest", good},
+		taggedImage{"bad:latest", bad},
+	)
+
+	tests := []struct {
+		name    string
+		ref     string
+		opts    []sif.VerifyChainOpt
+		wantErr bool
+	}{
+		{
+			name: "Good",
+			ref:  "good:latest",
+		},
+		{
+			name: "GoodDiffIDs",
+			ref:  "good:latest",
+			opts: []sif.VerifyChainOpt{sif.OptVerifyChainDiffIDs(true)},
+		},
+		{
+			name: "BadDiffIDsNotVerified",
+			ref:  "bad:latest",
+		},
+		{
+			name:    "BadDiffIDs",
+			ref:     "bad:latest",
+			opts:    []sif.VerifyChainOpt{sif.OptVerifyChainDiffIDs(true)},
+			wantErr: true,
+		},
+		{
+			name:    "NotFound",
+			ref:     "missing:latest",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sif.VerifyChain(fi, tt.ref, tt.opts...)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+		})
+	}
+}
+
+func TestVerifyChainMissingLayer(t *testing.T) {
+	img := corpus.Image(t, "many-layers")
+
+	fi := fileImageWithRefs(t, taggedImage{"many-layers:latest", img})
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ls[len(ls)-1].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleteBlob(t, fi, h)
+
+	err = sif.VerifyChain(fi, "many-layers:latest")
+	if err == nil {
+		t.Fatal("got nil error")
+	}
+
+	if !strings.Contains(err.Error(), h.String()) {
+		t.Errorf("error %q does not report %v", err, h)
+	}
+}
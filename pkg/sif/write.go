@@ -6,10 +6,14 @@ package sif
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/sylabs/sif/v2/pkg/sif"
 )
@@ -29,7 +33,39 @@ func (f *fileImage) writeBlobToFileImage(r io.Reader, rootIndex bool) error {
 	return f.AddObject(di)
 }
 
-// writeIndexToSIF writes an image and all of its manifests and blobs to f.
+// writeBlobToFileImageOnce writes the blob with digest h to f, unless f already contains a blob
+// with that digest. The blob content is only opened if it needs to be written.
+func (f *fileImage) writeBlobToFileImageOnce(h v1.Hash, open func() (io.ReadCloser, error)) error {
+	if ok, err := f.hasBlob(h); err != nil || ok {
+		return err
+	}
+
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := f.writeBlobToFileImage(rc, false); err != nil {
+		return err
+	}
+
+	if f.written != nil {
+		f.written(h)
+	}
+
+	return nil
+}
+
+// bytesOpener returns a function that opens a ReadCloser over b.
+func bytesOpener(b []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+}
+
+// writeImageToFileImage writes an image and all of its manifests and blobs to f. Blobs already
+// present in f are not written again.
 func (f *fileImage) writeImageToFileImage(img v1.Image) error {
 	ls, err := img.Layers()
 	if err != nil {
@@ -37,22 +73,33 @@ func (f *fileImage) writeImageToFileImage(img v1.Image) error {
 	}
 
 	for _, l := range ls {
-		rc, err := l.Compressed()
+		h, err := l.Digest()
 		if err != nil {
 			return err
 		}
 
-		if err := f.writeBlobToFileImage(rc, false); err != nil {
+		if err := f.writeBlobToFileImageOnce(h, l.Compressed); err != nil {
 			return err
 		}
 	}
 
-	cfg, err := img.RawConfigFile()
+	m, err := img.Manifest()
 	if err != nil {
 		return err
 	}
 
-	if err := f.writeBlobToFileImage(bytes.NewReader(cfg), false); err != nil {
+	// Take the config digest from the manifest, and defer retrieving the config until it is known
+	// to be required, as this may involve a network request. Note that img.ConfigName is not used,
+	// as implementations commonly derive it from the config itself.
+	openConfig := func() (io.ReadCloser, error) {
+		cfg, err := img.RawConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		return bytesOpener(cfg)()
+	}
+
+	if err := f.writeBlobToFileImageOnce(m.Config.Digest, openConfig); err != nil {
 		return err
 	}
 
@@ -61,7 +108,12 @@ func (f *fileImage) writeImageToFileImage(img v1.Image) error {
 		return err
 	}
 
-	return f.writeBlobToFileImage(bytes.NewReader(rm), false)
+	h, err := img.Digest()
+	if err != nil {
+		return err
+	}
+
+	return f.writeBlobToFileImageOnce(h, bytesOpener(rm))
 }
 
 type withBlob interface {
@@ -95,7 +147,32 @@ func blobFromIndex(ii v1.ImageIndex, digest v1.Hash) (io.ReadCloser, error) {
 }
 
 // writeIndexToFileImage writes an index and all of its child indexes, manifests and blobs to f.
+// Blobs already present in f are not written again.
 func (f *fileImage) writeIndexToFileImage(ii v1.ImageIndex, rootIndex bool) error {
+	if err := f.writeIndexContentToFileImage(ii); err != nil {
+		return err
+	}
+
+	m, err := ii.RawManifest()
+	if err != nil {
+		return err
+	}
+
+	if rootIndex {
+		return f.writeBlobToFileImage(bytes.NewReader(m), true)
+	}
+
+	h, err := ii.Digest()
+	if err != nil {
+		return err
+	}
+
+	return f.writeBlobToFileImageOnce(h, bytesOpener(m))
+}
+
+// writeIndexContentToFileImage writes the child indexes, manifests and blobs referenced by an
+// index to f, but not the index itself. Blobs already present in f are not written again.
+func (f *fileImage) writeIndexContentToFileImage(ii v1.ImageIndex) error {
 	index, err := ii.IndexManifest()
 	if err != nil {
 		return err
@@ -125,24 +202,77 @@ func (f *fileImage) writeIndexToFileImage(ii v1.ImageIndex, rootIndex bool) erro
 			}
 
 		default:
-			rc, err := blobFromIndex(ii, desc.Digest)
-			if err != nil {
-				return err
+			open := func() (io.ReadCloser, error) {
+				return blobFromIndex(ii, desc.Digest)
 			}
-			defer rc.Close()
 
-			if err := f.writeBlobToFileImage(rc, false); err != nil {
+			if err := f.writeBlobToFileImageOnce(desc.Digest, open); err != nil {
 				return err
 			}
 		}
 	}
 
-	m, err := ii.RawManifest()
+	return nil
+}
+
+// rootIndexManifest returns the index manifest of the RootIndex in f. If f does not contain a
+// RootIndex, an empty OCI index manifest is returned.
+func (f *fileImage) rootIndexManifest() (*v1.IndexManifest, error) {
+	ii, err := f.ImageIndex()
+	if errors.Is(err, sif.ErrNoObjects) || errors.Is(err, sif.ErrObjectNotFound) {
+		return &v1.IndexManifest{
+			SchemaVersion: 2,
+			MediaType:     types.OCIImageIndex,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ii.IndexManifest()
+}
+
+// writeRootIndex replaces the RootIndex in f with im, as described for replaceRootIndex.
+func (f *fileImage) writeRootIndex(im *v1.IndexManifest) error {
+	b, err := json.Marshal(im)
 	if err != nil {
 		return err
 	}
 
-	return f.writeBlobToFileImage(bytes.NewReader(m), rootIndex)
+	return f.replaceRootIndex(b)
+}
+
+// replaceRootIndex replaces the RootIndex in f with the index manifest b. Where a spare descriptor
+// is available, the new RootIndex is written before the existing RootIndex is removed, so that a
+// failure leaves the existing RootIndex intact. Otherwise, the existing RootIndex is removed first,
+// to free its descriptor.
+func (f *fileImage) replaceRootIndex(b []byte) error {
+	ds, err := f.GetDescriptors(sif.WithDataType(sif.DataOCIRootIndex))
+	if err != nil && !errors.Is(err, sif.ErrNoObjects) {
+		return err
+	}
+
+	deleteExisting := func() error {
+		for _, d := range ds {
+			if err := f.DeleteObject(d.ID()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if f.DescriptorsFree() == 0 {
+		if err := deleteExisting(); err != nil {
+			return err
+		}
+		return f.writeBlobToFileImage(bytes.NewReader(b), true)
+	}
+
+	if err := f.writeBlobToFileImage(bytes.NewReader(b), true); err != nil {
+		return err
+	}
+
+	return deleteExisting()
 }
 
 // numDescriptorsForImage returns the number of descriptors required to store img.
@@ -203,7 +333,8 @@ func numDescriptorsForIndex(ii v1.ImageIndex) (int64, error) {
 
 // writeOpts accumulates write options.
 type writeOpts struct {
-	spareDescriptors int64
+	spareDescriptors   int64
+	rootIndexMediaType types.MediaType
 }
 
 // WriteOpt are used to specify write options.
@@ -218,6 +349,20 @@ func OptWriteWithSpareDescriptorCapacity(n int64) WriteOpt {
 	}
 }
 
+// OptWriteRootIndexMediaType specifies the media type of the RootIndex, which must be either
+// types.OCIImageIndex or types.DockerManifestList. By default, the media type of the index being
+// written is retained.
+func OptWriteRootIndexMediaType(mt types.MediaType) WriteOpt {
+	return func(wo *writeOpts) error {
+		if mt != types.OCIImageIndex && mt != types.DockerManifestList {
+			return fmt.Errorf("%w: %v", errUnexpectedMediaType, mt)
+		}
+
+		wo.rootIndexMediaType = mt
+		return nil
+	}
+}
+
 // Write constructs a SIF at path from an ImageIndex.
 //
 // By default, the SIF is created with the exact number of descriptors required to represent ii. To
@@ -233,6 +378,10 @@ func Write(path string, ii v1.ImageIndex, opts ...WriteOpt) error {
 		}
 	}
 
+	if wo.rootIndexMediaType != "" {
+		ii = mutate.IndexMediaType(ii, wo.rootIndexMediaType)
+	}
+
 	n, err := numDescriptorsForIndex(ii)
 	if err != nil {
 		return err
@@ -247,7 +396,31 @@ func Write(path string, ii v1.ImageIndex, opts ...WriteOpt) error {
 	}
 	defer func() { _ = fi.UnloadContainer() }()
 
-	f := fileImage{fi}
+	f := fileImage{FileImage: fi}
 
 	return f.writeIndexToFileImage(ii, true)
 }
+
+// CreateEmpty constructs a SIF at path with an empty RootIndex, to which images may be added later
+// (e.g. using AppendImage). By default, the RootIndex is an OCI image index. To create a Docker
+// manifest list instead, consider using OptWriteRootIndexMediaType. The media type of the RootIndex
+// is retained when content is added to it.
+//
+// An empty SIF is of little use without spare descriptor capacity, so consider using
+// OptWriteWithSpareDescriptorCapacity.
+func CreateEmpty(path string, opts ...WriteOpt) error {
+	return Write(path, empty.Index, opts...)
+}
+
+// NewFromImage constructs a SIF at path containing img. The RootIndex of the SIF contains a single
+// entry for img, annotated with ref. Write options are applied as described for Write.
+func NewFromImage(path string, img v1.Image, ref string, opts ...WriteOpt) error {
+	ii := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add: img,
+		Descriptor: v1.Descriptor{
+			Annotations: map[string]string{refNameAnnotation: ref},
+		},
+	})
+
+	return Write(path, ii, opts...)
+}
@@ -10,9 +10,13 @@ import (
 	"testing"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ggcrmutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/validate"
 	"github.com/sebdah/goldie/v2"
 	"github.com/sylabs/oci-tools/pkg/sif"
 	"github.com/sylabs/oci-tools/test"
+	ssif "github.com/sylabs/sif/v2/pkg/sif"
 )
 
 //nolint:gochecknoglobals
@@ -65,3 +69,181 @@ func TestWrite(t *testing.T) {
 		})
 	}
 }
+
+func TestNewFromImage(t *testing.T) {
+	img := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	path := filepath.Join(t.TempDir(), "image.sif")
+
+	if err := sif.NewFromImage(path, img, "hello-world:latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := ssif.LoadContainerFromPath(path, ssif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	got, err := sif.GetImage(fi, "hello-world:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate.Image(got); err != nil {
+		t.Fatal(err)
+	}
+
+	wantDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotDigest, err := got.Digest(); err != nil {
+		t.Fatal(err)
+	} else if gotDigest != wantDigest {
+		t.Errorf("got digest %v, want %v", gotDigest, wantDigest)
+	}
+}
+
+func TestCreateEmpty(t *testing.T) {
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	tests := []struct {
+		name          string
+		opts          []sif.WriteOpt
+		wantMediaType types.MediaType
+	}{
+		{
+			name:          "Default",
+			wantMediaType: types.OCIImageIndex,
+		},
+		{
+			name:          "DockerManifestList",
+			opts:          []sif.WriteOpt{sif.OptWriteRootIndexMediaType(types.DockerManifestList)},
+			wantMediaType: types.DockerManifestList,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "image.sif")
+
+			opts := append([]sif.WriteOpt{sif.OptWriteWithSpareDescriptorCapacity(16)}, tt.opts...)
+
+			if err := sif.CreateEmpty(path, opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			fi, err := ssif.LoadContainerFromPath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+			// Append images, which must not change the media type of the RootIndex.
+			for _, img := range []v1.Image{base, labeledImage(t, base, "key", "value")} {
+				if err := sif.AppendImage(fi, img); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ii, err := sif.ImageIndexFromFileImage(fi)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validate.Index(ii); err != nil {
+				t.Fatal(err)
+			}
+
+			im, err := ii.IndexManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := im.MediaType, tt.wantMediaType; got != want {
+				t.Errorf("got media type %v, want %v", got, want)
+			}
+
+			if got, want := len(im.Manifests), 2; got != want {
+				t.Fatalf("got %v manifests, want %v", got, want)
+			}
+
+			for _, desc := range im.Manifests {
+				if got, want := desc.MediaType, types.DockerManifestSchema2; got != want {
+					t.Errorf("got child media type %v, want %v", got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("InvalidMediaType", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "image.sif")
+
+		if err := sif.CreateEmpty(path, sif.OptWriteRootIndexMediaType(types.OCIManifestSchema1)); err == nil {
+			t.Fatal("got nil error, want error")
+		}
+	})
+}
+
+func TestAppendImageDockerManifestList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.sif")
+
+	if err := sif.CreateEmpty(path,
+		sif.OptWriteWithSpareDescriptorCapacity(16),
+		sif.OptWriteRootIndexMediaType(types.DockerManifestList),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := ssif.LoadContainerFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fi.UnloadContainer() })
+
+	base := corpus.Image(t, "hello-world-docker-v2-manifest")
+
+	tests := []struct {
+		name    string
+		img     v1.Image
+		wantErr bool
+	}{
+		{
+			name: "Docker",
+			img:  base,
+		},
+		{
+			name:    "OCI",
+			img:     ggcrmutate.MediaType(labeledImage(t, base, "key", "value"), types.OCIManifestSchema1),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sif.AppendImage(fi, tt.img)
+			if got, want := err != nil, tt.wantErr; got != want {
+				t.Fatalf("got error %v, want error %v", err, want)
+			}
+		})
+	}
+
+	// Only the Docker image was added.
+	ii, err := sif.ImageIndexFromFileImage(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(im.Manifests), 1; got != want {
+		t.Fatalf("got %v manifests, want %v", got, want)
+	}
+
+	if got, want := im.Manifests[0].MediaType, types.DockerManifestSchema2; got != want {
+		t.Errorf("got media type %v, want %v", got, want)
+	}
+}